@@ -164,12 +164,17 @@ func (agg *adblockAggregator) DumpToPostgresql(ctx *core.AggregationContext, sql
 		return err
 	}
 
+	logID, err := ctx.Ln.InsertLogfile(sqlDb)
+	if err != nil {
+		return err
+	}
+
 	txn, err := sqlDb.Begin()
 	if err != nil {
 		return err
 	}
 
-	stmt, err := txn.Prepare(`INSERT INTO adblock (url, origin, blocked) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING`)
+	stmt, err := txn.Prepare(`INSERT INTO adblock (logfile_id, url, origin, blocked) VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING`)
 	if err != nil {
 		txn.Rollback()
 		return err
@@ -178,6 +183,7 @@ func (agg *adblockAggregator) DumpToPostgresql(ctx *core.AggregationContext, sql
 	for _, script := range agg.urlPairList {
 
 		_, err = stmt.Exec(
+			logID,
 			script.URL,
 			script.Origin,
 			script.Blocked)
@@ -8,8 +8,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"strconv"
-	"strings"
 
 	"github.com/wspr-ncsu/visiblev8/post-processor/core"
 )
@@ -26,8 +24,8 @@ type CreateCallArgsAggregator struct {
 	// IDL feature name normalization database
 	idl core.IDLTree
 
-	// track set of (lowercase) tag names Document.createElement()'d by a given originCallsite (origin/script/offset)
-	callInfo map[originCallsite][][]string
+	// track the (decoded) argument lists passed at a given originCallsite (origin/script/offset/API)
+	callInfo map[originCallsite][][]core.Value
 }
 
 // NewCreateElementAggregator constructs a CreateElementAggregator
@@ -38,44 +36,32 @@ func NewCreateCallArgsAggregator() (core.Aggregator, error) {
 	}
 	return &CreateCallArgsAggregator{
 		idl:      tree,
-		callInfo: make(map[originCallsite][][]string),
+		callInfo: make(map[originCallsite][][]core.Value),
 	}, nil
 }
 
 // IngestRecord parses a trace record, looking for document.createElement calls to track
 func (agg *CreateCallArgsAggregator) IngestRecord(ctx *core.ExecutionContext, lineNumber int, op byte, fields []string) error {
-	if (op == 'c') && (ctx.Script != nil) && !ctx.Script.VisibleV8 && (ctx.Origin.Origin != "") {
-		offset, err := strconv.Atoi(fields[0])
-		if err != nil {
-			return fmt.Errorf("%d: invalid script offset '%s'", lineNumber, fields[0])
-		}
-
-		rcvr, _ := core.StripCurlies(fields[2])
-		//TODO: split with comma and only grab the receiver
-		name, _ := core.StripQuotes(fields[1])
-
-		// Eliminate "native" prefix indicator from function names
-		name = strings.TrimPrefix(name, "%")
+	return core.IngestAsRecord(agg, ctx, lineNumber, op, fields)
+}
 
+// IngestTypedRecord records the arguments passed at each API call site
+func (agg *CreateCallArgsAggregator) IngestTypedRecord(ctx *core.ExecutionContext, rec *core.Record) error {
+	if (rec.Op == 'c') && (ctx.Script != nil) && !ctx.Script.VisibleV8 && (ctx.Origin.Origin != "") {
 		// We have some names (V8 special cases, numeric indices) that are never useful
-		if core.FilterName(name) {
+		if core.FilterName(rec.Member) {
 			return nil
 		}
 
 		// Normalize IDL names
-		fullName, err := agg.idl.NormalizeMember(rcvr, name)
+		fullName, err := agg.idl.NormalizeMember(rec.Receiver, rec.Member)
 		if err != nil {
-			fullName = fmt.Sprintf("%s.%s", rcvr, name)
+			fullName = fmt.Sprintf("%s.%s", rec.Receiver, rec.Member)
 		}
 
 		// Create the call site to record
-		cite := originCallsite{ctx.Origin.Origin, ctx.Script, offset, fullName}
-		args := agg.callInfo[cite]
-		if args == nil {
-			args = make([][]string, 0)
-			agg.callInfo[cite] = args
-		}
-		agg.callInfo[cite] = append(agg.callInfo[cite], fields[3:])
+		cite := originCallsite{ctx.Origin.Origin, ctx.Script, rec.Offset, fullName}
+		agg.callInfo[cite] = append(agg.callInfo[cite], core.ParseValues(rec.Args))
 	}
 	return nil
 }
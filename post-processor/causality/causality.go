@@ -125,91 +125,79 @@ func (agg *ScriptCausalityAggregator) addIframe(rawURL string, actor *core.Execu
 
 // IngestRecord processes a single trace record (line), looking for dynamic script causality
 func (agg *ScriptCausalityAggregator) IngestRecord(ctx *core.ExecutionContext, lineNumber int, op byte, fields []string) error {
+	return core.IngestAsRecord(agg, ctx, lineNumber, op, fields)
+}
+
+// IngestTypedRecord processes a single parsed trace record, looking for dynamic script causality
+func (agg *ScriptCausalityAggregator) IngestTypedRecord(ctx *core.ExecutionContext, rec *core.Record) error {
 	if (ctx.Script != nil) && !ctx.Script.VisibleV8 && (ctx.Origin.Origin != "") {
-		var name, rcvr string
-		switch op {
-		case 's':
-			rcvr, _ = core.StripCurlies(fields[1])
-			name, _ = core.StripQuotes(fields[2])
-		case 'c':
-			rcvr, _ = core.StripCurlies(fields[2])
-			name, _ = core.StripQuotes(fields[1])
-			// Eliminate "native" prefix indicator from function names
-			name = strings.TrimPrefix(name, "%")
-		default:
+		op, lineNumber := rec.Op, rec.Line
+		rcvr, name := rec.Receiver, rec.Member
+		if op != 's' && op != 'c' {
 			// Short-circuit: we don't handle anything else
 			return nil
 		}
 
-		if strings.Contains(rcvr, ",") {
-			rcvr = strings.Split(rcvr, ",")[1]
+		// The interesting value: what was set ('s') or the first argument passed ('c'); only strings are of any use
+		var value core.Value
+		if op == 's' {
+			value = core.ParseValue(rec.Value)
+		} else if len(rec.Args) > 0 {
+			value = core.ParseValue(rec.Args[0])
+		} else {
+			value = core.ParseValue("")
 		}
+		text, isString := value.Text, value.Kind == core.StringValue
 
 		if (op == 's') && (rcvr == "HTMLScriptElement") {
 			if name == "src" {
 				// Remote inclusion...
-				incURL, ok := core.StripQuotes(fields[3])
-				if ok {
-					agg.addInclusion(incURL, ctx, false)
+				if isString {
+					agg.addInclusion(text, ctx, false)
 				} else {
-					log.Printf("%d: bogus HtmlScriptElement.src = %s\n", lineNumber, fields[3])
+					log.Printf("%d: bogus HtmlScriptElement.src = %s\n", lineNumber, value.Raw)
 				}
 			} else if name == "text" || name == "innerText" {
 				// Inline insertion (TODO: add other comparable attributes)
-				incSrc, ok := core.StripQuotes(fields[3])
-				if ok {
-					agg.addInsertion(core.NewScriptHash(incSrc), ctx, false)
+				if isString {
+					agg.addInsertion(core.NewScriptHash(text), ctx, false)
 				} else {
-					log.Printf("%d: bogus HtmlScriptElement.text = %s\n", lineNumber, fields[3])
+					log.Printf("%d: bogus HtmlScriptElement.text = %s\n", lineNumber, value.Raw)
 				}
 			}
 		} else if (op == 'c') && (rcvr == "HTMLDocument") && (name == "write" || name == "writeln") {
 			// document.write(...) shenanigans!
-			html, ok := "", false
-			if len(fields) > 3 {
-				html, ok = core.StripQuotes(fields[3])
-			}
-			if ok {
-				agg.writeMap[*ctx] += html
+			if isString {
+				agg.writeMap[*ctx] += text
 			} else {
-				log.Printf("%d, document.write(%s)...wat??", lineNumber, html)
+				log.Printf("%d, document.write(%s)...wat??", lineNumber, value.Raw)
 			}
 
 		} else if (op == 's') && (name == "innerHTML" || name == "outerHTML") {
-			html, ok := "", false
-			if len(fields) > 3 {
-				html, ok = core.StripQuotes(fields[3])
-			}
-			if ok {
-				agg.writeMap[*ctx] += html
+			if isString {
+				agg.writeMap[*ctx] += text
 			} else {
-				log.Printf("%d, document.write(%s)...wat??", lineNumber, html)
+				log.Printf("%d, document.write(%s)...wat??", lineNumber, value.Raw)
 			}
 		} else if (op == 's') && (rcvr == "HTMLIFrameElement") && (name == "src" || name == "srcdoc") {
 			if name == "src" {
-				incURL, ok := core.StripQuotes(fields[3])
-				if ok {
-					agg.addIframe(incURL, ctx)
+				if isString {
+					agg.addIframe(text, ctx)
 				} else {
-					log.Printf("%d: bogus HtmlIFrameElement.src = %s\n", lineNumber, fields[3])
+					log.Printf("%d: bogus HtmlIFrameElement.src = %s\n", lineNumber, value.Raw)
 				}
 			} else if name == "srcdoc" {
-				html, ok := "", false
-				if len(fields) > 3 {
-					html, ok = core.StripQuotes(fields[3])
-				}
-				if ok {
-					agg.writeMap[*ctx] += html
+				if isString {
+					agg.writeMap[*ctx] += text
 				} else {
-					log.Printf("%d, iframe.srcdoc(%s)...wat??", lineNumber, html)
+					log.Printf("%d, iframe.srcdoc(%s)...wat??", lineNumber, value.Raw)
 				}
 			}
 		} else if (op == 's') && ((rcvr == "Location") && (name == "href") || (name == "location")) {
-			incURL, ok := core.StripQuotes(fields[3])
-			if ok {
-				agg.addIframe(incURL, ctx)
+			if isString {
+				agg.addIframe(text, ctx)
 			} else {
-				log.Printf("%d: bogus redirect = %s\n", lineNumber, fields[3])
+				log.Printf("%d: bogus redirect = %s\n", lineNumber, value.Raw)
 			}
 		}
 	}
@@ -702,10 +690,21 @@ var scriptCausalityFields = [...]string{
 	"genesis",
 	"parent_hash",
 	"by_url",
+	"parent_cardinality",
+	"child_cardinality",
+}
+
+// nullableCardinality gives a link's parent/child cardinality, or nil for links not matched by URL/hash (which have none)
+func nullableCardinality(card int) interface{} {
+	if card == 0 {
+		return nil
+	}
+	return card
 }
 
 var scriptCausalityGraphMLFields = [...]string{
 	"id",
+	"logfile_id",
 	"xml",
 }
 
@@ -723,21 +722,31 @@ func (agg *ScriptCausalityAggregator) graphmlDumpToPostgresql(records []causalit
 		return err
 	}
 
-	// Prepare for bulk insertion of script causality tuples
+	logID, err := ctx.Ln.InsertLogfile(sqlDb)
+	if err != nil {
+		return err
+	}
+
+	// Prepare for bulk insertion of the GraphML document
 	txn, err := sqlDb.Begin()
 	if err != nil {
 		return err
 	}
-	stmt, err := txn.Prepare(pq.CopyIn("script_causality_graphml", scriptCausalityFields[:]...))
+	stmt, err := txn.Prepare(pq.CopyIn("script_causality_graphml", scriptCausalityGraphMLFields[:]...))
 	if err != nil {
 		txn.Rollback()
 		return err
 	}
 
-	stmt.Exec(
-		uuid.New(),
-		buf,
+	_, err = stmt.Exec(
+		uuid.New().String(),
+		logID,
+		buf.String(),
 	)
+	if err != nil {
+		txn.Rollback()
+		return err
+	}
 
 	// Finish the bulk insertion and commit everything
 	_, err = stmt.Exec()
@@ -814,7 +823,9 @@ func (agg *ScriptCausalityAggregator) DumpToPostgresql(ctx *core.AggregationCont
 				cr.child.CodeHash.SHA2[:],
 				cr.genesis,
 				nullableParentHash,
-				nullableURL)
+				nullableURL,
+				nullableCardinality(cr.pCard),
+				nullableCardinality(cr.cCard))
 			if err != nil {
 				txn.Rollback()
 				return err
@@ -839,3 +850,127 @@ func (agg *ScriptCausalityAggregator) DumpToPostgresql(ctx *core.AggregationCont
 	}
 	return nil
 }
+
+// Table equivalents of script_causality (scriptCausalityFields, plus cardinalities and script IDs) and script_causality_graphml
+var scriptCausalityTable = &core.Table{
+	Name: "script_causality",
+	Columns: []core.Column{
+		{Name: "logfile_id", Type: core.StringColumn},
+		{Name: "visit_domain", Type: core.StringColumn, Nullable: true},
+		{Name: "child_hash", Type: core.BytesColumn},
+		{Name: "child_runtime_id", Type: core.Int64Column},
+		{Name: "child_is_iframe", Type: core.BoolColumn},
+		{Name: "genesis", Type: core.StringColumn},
+		{Name: "parent_hash", Type: core.BytesColumn, Nullable: true},
+		{Name: "parent_runtime_id", Type: core.Int64Column, Nullable: true},
+		{Name: "parent_is_iframe", Type: core.BoolColumn},
+		{Name: "by_url", Type: core.StringColumn, Nullable: true},
+		{Name: "parent_cardinality", Type: core.Int64Column},
+		{Name: "child_cardinality", Type: core.Int64Column},
+	},
+}
+
+var scriptCausalityGraphMLTable = &core.Table{
+	Name: "script_causality_graphml",
+	Columns: []core.Column{
+		{Name: "logfile_id", Type: core.StringColumn},
+		{Name: "xml", Type: core.StringColumn},
+	},
+}
+
+// DumpToTables writes causality links (and/or each log's GraphML document) as table rows
+func (agg *ScriptCausalityAggregator) DumpToTables(ctx *core.AggregationContext, sink core.TableSink) error {
+	records, err := agg.causalityDumper(ctx)
+	if err != nil {
+		log.Printf("error dumping causality tuples from raw data (%s)", err)
+		return err
+	}
+	logID := ctx.Ln.ID.String()
+
+	if ctx.Formats["causality_graphml"] {
+		gml, err := generateGraphML(records, ctx)
+		if err != nil {
+			log.Printf("error converting causality tuples into goGraphML graph object (%s)", err)
+			return err
+		}
+		buf := new(bytes.Buffer)
+		err = gml.Encode(buf, false) // no pretty-printing
+		if err != nil {
+			log.Printf("error serializing causality graph to GraphML (%s)", err)
+			return err
+		}
+		if err = sink.WriteRow(scriptCausalityGraphMLTable, logID, buf.String()); err != nil {
+			return err
+		}
+	}
+
+	if ctx.Formats["causality"] {
+		visitDomain := core.NullableString(ctx.RootDomain)
+		for _, cr := range records {
+			var parentHash, parentID interface{}
+			if cr.parent != nil {
+				parentHash = cr.parent.CodeHash.SHA2[:]
+				parentID = cr.parent.ID
+			}
+			err = sink.WriteRow(scriptCausalityTable,
+				logID, visitDomain, cr.child.CodeHash.SHA2[:], cr.child.ID, cr.isIframe, cr.genesis,
+				parentHash, parentID, cr.parentIsIframe, core.NullableString(cr.url), cr.pCard, cr.cCard)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// COPY-file equivalents of script_causality and script_causality_graphml
+var (
+	scriptCausalityCopyTable        = &core.CopyTable{Name: "script_causality", Fields: scriptCausalityFields[:]}
+	scriptCausalityGraphMLCopyTable = &core.CopyTable{Name: "script_causality_graphml", Fields: scriptCausalityGraphMLFields[:]}
+)
+
+// CopyTables lists the COPY files written by DumpToCopy
+var CopyTables = []*core.CopyTable{scriptCausalityCopyTable, scriptCausalityGraphMLCopyTable}
+
+// DumpToCopy writes the script_causality rows (and GraphML document) DumpToPostgresql would insert
+func (agg *ScriptCausalityAggregator) DumpToCopy(ctx *core.AggregationContext, sink core.CopySink) error {
+	records, err := agg.causalityDumper(ctx)
+	if err != nil {
+		log.Printf("error dumping causality tuples from raw data (%s)", err)
+		return err
+	}
+	logID := ctx.Ln.ID.String()
+
+	if ctx.Formats["causality_graphml"] {
+		gml, err := generateGraphML(records, ctx)
+		if err != nil {
+			log.Printf("error converting causality tuples into goGraphML graph object (%s)", err)
+			return err
+		}
+		buf := new(bytes.Buffer)
+		if err = gml.Encode(buf, false); err != nil {
+			log.Printf("error serializing causality graph to GraphML (%s)", err)
+			return err
+		}
+		if err = sink.CopyRow(scriptCausalityGraphMLCopyTable, uuid.New().String(), logID, buf.String()); err != nil {
+			return err
+		}
+	}
+
+	if !ctx.Formats["causality"] {
+		return nil
+	}
+	for _, cr := range records {
+		var parentHash interface{}
+		if cr.parent != nil {
+			parentHash = cr.parent.CodeHash.SHA2[:]
+		}
+		err = sink.CopyRow(scriptCausalityCopyTable,
+			logID, ctx.RootDomain, cr.child.CodeHash.SHA2[:], cr.genesis, parentHash, core.NullableString(cr.url),
+			nullableCardinality(cr.pCard), nullableCardinality(cr.cCard))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
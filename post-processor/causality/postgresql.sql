@@ -1,4 +0,0 @@
-CREATE TABLE IF EXISTS causality (
-    id TEXT,
-    xml BLOB
-);
\ No newline at end of file
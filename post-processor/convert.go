@@ -0,0 +1,41 @@
+package main
+
+// ---------------------------------------------------------------------------
+// binary conversion ("-convert"): re-encode logs in the compact binary format IngestStream reads back directly
+// ---------------------------------------------------------------------------
+
+import (
+	"log"
+
+	"github.com/wspr-ncsu/visiblev8/post-processor/core"
+)
+
+// binaryLogExt names the binary logs written to -convert-out directories
+const binaryLogExt = ".vv8b"
+
+// convertCluster writes one log (all segments concatenated) as a binary log to the output directory (or stdout)
+func (p *pipeline) convertCluster(inputName string, inputSegments []logSegment) error {
+	aggCtx := p.base
+	inputStream, err := p.openInput(&aggCtx, inputName, inputSegments)
+	if err != nil {
+		return err
+	}
+	defer closeInputs(inputStream)
+
+	if p.convertOut == "" {
+		p.stdoutLock.Lock()
+		defer p.stdoutLock.Unlock()
+		return core.ConvertStream(inputStream, p.stdout, aggCtx.RootName)
+	}
+
+	file, outName, err := createClusterOutput(p.convertOut, inputName, binaryLogExt)
+	if err != nil {
+		return err
+	}
+	log.Printf("Converting %s into %s...\n", inputName, outName)
+	err = core.ConvertStream(inputStream, file, aggCtx.RootName)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
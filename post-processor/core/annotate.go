@@ -1,78 +1,75 @@
 package core
 
 import (
-	"bufio"
 	"encoding/hex"
 	"encoding/json"
-	"fmt"
 	"io"
-	"os"
+	"log"
 	"strconv"
 )
 
-// AnnotateStream is a simplified version of the core parser that just annotates raw lines
-func AnnotateStream(stream io.Reader, aggCtx *AggregationContext) error {
+// AnnotateStream is a simplified version of the core parser that just annotates raw lines (as JSON objects, to <out>)
+func AnnotateStream(stream io.Reader, out io.Writer, aggCtx *AggregationContext) error {
 	ln := NewLogInfo(aggCtx.LogOid, aggCtx.RootName, aggCtx.SubmissionID)
+	ln.Lenient = aggCtx.Lenient
 
 	// Read lines from input
-	scan := bufio.NewScanner(stream)
+	scan := newLogLineScanner(stream)
 
-	// Support LOOOONG lines
-	scan.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), 128*1024*1024)
-
-	// Prepare for JSON output to stdout (no options on that)
-	jstream := json.NewEncoder(os.Stdout)
+	// Prepare for JSON output (no options on that)
+	jstream := json.NewEncoder(out)
 
 	// Start processing log lines
 	var lineCount int
 	for scan.Scan() {
 		line := scan.Bytes()
 		lineCount++
+		if scan.truncated && ln.dropTruncatedLine(lineCount, len(line)) {
+			break
+		}
 		doc := JSONObject{
 			"t": string(line),
 		}
 		if len(line) > 0 {
 			code := line[0]
 			fields := splitFields(line[1:])
-			switch code {
-			case '~':
-				ln.changeIsolate(fields[0])
-			case '$':
-				scriptID, err := strconv.Atoi(fields[0])
-				if err != nil {
-					return err
-				}
-				script := ln.addScript(scriptID, fields[1], fields[2])
-				doc["d"] = scriptID
-				doc["s"] = hex.EncodeToString(script.CodeHash.SHA2[:]) // TODO: move away from SHA2-256-only script hashes to ID stuff
-			case '!':
-				scriptID, err := strconv.Atoi(fields[0])
-				if err != nil {
-					ln.resetContext()
-				} else {
-					ln.changeScript(scriptID)
+			isTrace, err := ln.ingestContextRecord(lineCount, code, fields)
+			if err != nil {
+				return err
+			}
+			if code == '$' && len(fields) > 0 {
+				if scriptID, err := strconv.Atoi(fields[0]); err == nil {
+					if script, ok := ln.World.Scripts[scriptID]; ok {
+						doc["d"] = scriptID
+						doc["s"] = hex.EncodeToString(script.CodeHash.SHA2[:]) // TODO: move away from SHA2-256-only script hashes to ID stuff
+					}
 				}
-			case '@':
-				originString, _ := StripQuotes(fields[0])
-				originSecurityToken, _ := StripQuotes(fields[1])
-				ln.changeOrigin(originString, originSecurityToken)
-			default:
-				offset, err := strconv.Atoi(fields[0])
-				if err != nil {
-					return fmt.Errorf("%d: invalid script offset '%s'", lineCount, fields[0])
+			} else if isTrace {
+				if len(fields) == 0 {
+					err = newAnomaly(lineCount, AnomalyShortRecord, "empty '%c' record", code)
+				} else if offset, perr := strconv.Atoi(fields[0]); perr != nil {
+					err = newAnomaly(lineCount, AnomalyBadRecord, "invalid script offset '%s'", fields[0])
 				} else if offset >= 0 && ln.World.Context.Script != nil {
 					doc["o"] = offset
 				}
+				if err = ln.tolerate(err); err != nil {
+					return err
+				}
 			}
-			if ln.World.Context.Script != nil {
+			if ln.World != nil && ln.World.Context.Script != nil {
 				doc["x"] = ln.World.Context.Script.ID
 			}
 		}
-		jstream.Encode(doc)
+		if err := jstream.Encode(doc); err != nil {
+			return err
+		}
 	}
 	if scan.Err() != nil {
 		return scan.Err()
 	}
+	if ln.Lenient {
+		log.Printf("%s: anomalies tolerated: %s\n", ln.RootName, ln.AnomalySummary())
+	}
 
 	return nil
 }
@@ -0,0 +1,101 @@
+package core
+
+// -------------------------------------------------------------------------------------
+// classification/accounting of malformed log data (fatal in strict mode, counted in lenient mode)
+// -------------------------------------------------------------------------------------
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// AnomalyKind classifies a problem found in a log stream
+type AnomalyKind string
+
+const (
+	// AnomalyMissingIsolate is a record arriving before any '~' isolate header
+	AnomalyMissingIsolate AnomalyKind = "missing-isolate"
+	// AnomalyMissingOrigin is a script or trace record arriving before any '@' origin record
+	AnomalyMissingOrigin AnomalyKind = "missing-origin"
+	// AnomalyOrphanEval is a '$' record naming an eval-parent script ID never defined in the isolate
+	AnomalyOrphanEval AnomalyKind = "orphan-eval"
+	// AnomalyUnknownScript is a '!' record switching to a script ID never defined in the isolate
+	AnomalyUnknownScript AnomalyKind = "unknown-script"
+	// AnomalyRedefinedScript is a '$' record reusing a script ID already defined in the isolate
+	AnomalyRedefinedScript AnomalyKind = "redefined-script"
+	// AnomalyShortRecord is a record with fewer fields than its type requires
+	AnomalyShortRecord AnomalyKind = "short-record"
+	// AnomalyBadRecord is a record with an unparseable script ID or offset field
+	AnomalyBadRecord AnomalyKind = "bad-record"
+	// AnomalyUnknownRecord is a record of a type we do not know
+	AnomalyUnknownRecord AnomalyKind = "unknown-record"
+	// AnomalyTruncatedLine is a final line cut off before its newline (e.g., by a crashed renderer)
+	AnomalyTruncatedLine AnomalyKind = "truncated-line"
+	// AnomalyBadEscape is an invalid escape sequence (bad \x/\u digits, unpaired UTF-16 surrogate; only checked on request)
+	AnomalyBadEscape AnomalyKind = "bad-escape"
+	// AnomalySegmentGap is a multi-segment log missing one or more segment ranks (reported by the lint mode)
+	AnomalySegmentGap AnomalyKind = "segment-gap"
+)
+
+// AnomalyError describes a single anomaly (and is the error returned for it in strict mode)
+type AnomalyError struct {
+	Line   int
+	Kind   AnomalyKind
+	Detail string
+}
+
+func (ae *AnomalyError) Error() string {
+	return fmt.Sprintf("%d: %s", ae.Line, ae.Detail)
+}
+
+// NewAnomaly creates an anomaly report (for problems found outside of IngestStream itself)
+func NewAnomaly(lineNumber int, kind AnomalyKind, format string, args ...interface{}) *AnomalyError {
+	return newAnomaly(lineNumber, kind, format, args...)
+}
+
+func newAnomaly(lineNumber int, kind AnomalyKind, format string, args ...interface{}) *AnomalyError {
+	return &AnomalyError{
+		Line:   lineNumber,
+		Kind:   kind,
+		Detail: fmt.Sprintf(format, args...),
+	}
+}
+
+// tolerate passes <err> through unchanged in strict mode (or if it is not an anomaly at all);
+// in lenient mode it counts the anomaly and swallows it (returning nil)
+func (ln *LogInfo) tolerate(err error) error {
+	var ae *AnomalyError
+	if !ln.Lenient || !errors.As(err, &ae) {
+		return err
+	}
+	if ln.Anomalies == nil {
+		ln.Anomalies = make(map[AnomalyKind]int)
+	}
+	ln.Anomalies[ae.Kind]++
+	if ln.AnomalyHook != nil {
+		ln.AnomalyHook(ae)
+	} else {
+		log.Printf("lenient: %v (%s)\n", ae, ae.Kind)
+	}
+	return nil
+}
+
+// AnomalySummary renders the per-kind anomaly counts of this log as "kind=N, ..." (or "none")
+func (ln *LogInfo) AnomalySummary() string {
+	if len(ln.Anomalies) == 0 {
+		return "none"
+	}
+	kinds := make([]string, 0, len(ln.Anomalies))
+	for kind := range ln.Anomalies {
+		kinds = append(kinds, string(kind))
+	}
+	sort.Strings(kinds)
+	parts := make([]string, len(kinds))
+	for i, kind := range kinds {
+		parts[i] = fmt.Sprintf("%s=%d", kind, ln.Anomalies[AnomalyKind(kind)])
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,244 @@
+package core
+
+// reading log segments straight out of .tar[.gz|.zst|.xz] and .zip bundles (no extraction to disk)
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// archiveFormat identifies the container format of a bundle of log segments
+type archiveFormat int
+
+const (
+	tarArchive archiveFormat = iota
+	zipArchive
+)
+
+// archiveSuffixes maps recognized archive file name suffixes to their container format
+// (tarballs may be compressed with anything NewDecompressingReader understands)
+var archiveSuffixes = []struct {
+	suffix string
+	format archiveFormat
+}{
+	{".tar", tarArchive},
+	{".tar.gz", tarArchive},
+	{".tgz", tarArchive},
+	{".tar.zst", tarArchive},
+	{".tar.xz", tarArchive},
+	{".zip", zipArchive},
+}
+
+// archiveFormatOf identifies an archive by file name alone (ok is FALSE if it does not look like one)
+func archiveFormatOf(name string) (format archiveFormat, ok bool) {
+	for _, as := range archiveSuffixes {
+		if strings.HasSuffix(name, as.suffix) {
+			return as.format, true
+		}
+	}
+	return 0, false
+}
+
+// IsArchiveName reports whether a file name looks like an archive we can read log segments from
+func IsArchiveName(name string) bool {
+	_, ok := archiveFormatOf(name)
+	return ok
+}
+
+// Archive is an indexed bundle of files (e.g., all the log segments of one page visit)
+type Archive struct {
+	Name    string
+	format  archiveFormat
+	members []string // names of regular-file members, in archive order
+}
+
+// OpenArchive indexes the regular-file members of the archive file <name>
+func OpenArchive(name string) (*Archive, error) {
+	format, ok := archiveFormatOf(name)
+	if !ok {
+		return nil, fmt.Errorf("%s: not a recognized archive (wanted one of .tar[.gz|.zst|.xz], .tgz, .zip)", name)
+	}
+	archive := &Archive{Name: name, format: format}
+
+	switch format {
+	case zipArchive:
+		zr, err := zip.OpenReader(name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		defer zr.Close()
+		for _, f := range zr.File {
+			if f.Mode().IsRegular() {
+				archive.members = append(archive.members, f.Name)
+			}
+		}
+	case tarArchive:
+		closer, tr, err := openTar(name)
+		if err != nil {
+			return nil, err
+		}
+		defer closer.Close()
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			if hdr.Typeflag == tar.TypeReg {
+				archive.members = append(archive.members, hdr.Name)
+			}
+		}
+	}
+	return archive, nil
+}
+
+// openTar opens a (possibly compressed) tarball, returning the tar reader and what to close when done with it
+func openTar(name string) (io.Closer, *tar.Reader, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	stream, err := NewDecompressingReader(file)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return stream, tar.NewReader(stream), nil
+}
+
+// Members lists the names of the archive's regular-file members, in archive order
+func (archive *Archive) Members() []string {
+	return archive.members
+}
+
+// OpenMembers streams the named members (each possibly compressed itself) back to back, in the order given
+func (archive *Archive) OpenMembers(members []string) io.ReadCloser {
+	return &memberStream{
+		archive: archive,
+		members: members,
+	}
+}
+
+// memberStream lazily opens and concatenates archive members, one at a time
+type memberStream struct {
+	archive *Archive
+	members []string
+	next    int           // index (into members) of the next member to open
+	current io.ReadCloser // decompressed member being read (nil between members)
+
+	// tarballs are read front to back, so we only rewind (reopen) when a member precedes the last one read
+	tarCloser io.Closer
+	tarReader *tar.Reader
+	tarSeen   map[string]bool // members passed by the current tar reader
+
+	zipReader *zip.ReadCloser
+}
+
+func (ms *memberStream) Read(p []byte) (int, error) {
+	for {
+		if ms.current == nil {
+			if ms.next >= len(ms.members) {
+				return 0, io.EOF
+			}
+			err := ms.openNext()
+			if err != nil {
+				return 0, err
+			}
+		}
+		n, err := ms.current.Read(p)
+		if err == io.EOF {
+			ms.current.Close()
+			ms.current = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+// openNext locates the next wanted member and sets it up as the current stream
+func (ms *memberStream) openNext() error {
+	name := ms.members[ms.next]
+	ms.next++
+
+	var raw io.Reader
+	switch ms.archive.format {
+	case zipArchive:
+		if ms.zipReader == nil {
+			zr, err := zip.OpenReader(ms.archive.Name)
+			if err != nil {
+				return fmt.Errorf("%s: %w", ms.archive.Name, err)
+			}
+			ms.zipReader = zr
+		}
+		for _, f := range ms.zipReader.File {
+			if f.Name == name && f.Mode().IsRegular() {
+				file, err := f.Open()
+				if err != nil {
+					return fmt.Errorf("%s: %s: %w", ms.archive.Name, name, err)
+				}
+				raw = file
+				break
+			}
+		}
+		if raw == nil {
+			return fmt.Errorf("%s: no member named '%s'", ms.archive.Name, name)
+		}
+	case tarArchive:
+		if ms.tarReader == nil || ms.tarSeen[name] {
+			if ms.tarCloser != nil {
+				ms.tarCloser.Close()
+			}
+			closer, tr, err := openTar(ms.archive.Name)
+			if err != nil {
+				return err
+			}
+			ms.tarCloser, ms.tarReader, ms.tarSeen = closer, tr, make(map[string]bool)
+		}
+		for raw == nil {
+			hdr, err := ms.tarReader.Next()
+			if err == io.EOF {
+				return fmt.Errorf("%s: no member named '%s'", ms.archive.Name, name)
+			} else if err != nil {
+				return fmt.Errorf("%s: %w", ms.archive.Name, err)
+			}
+			ms.tarSeen[hdr.Name] = true
+			if hdr.Typeflag == tar.TypeReg && hdr.Name == name {
+				raw = ms.tarReader
+			}
+		}
+	}
+
+	stream, err := NewDecompressingReader(raw)
+	if err != nil {
+		return fmt.Errorf("%s: %s: %w", ms.archive.Name, name, err)
+	}
+	ms.current = stream
+	return nil
+}
+
+func (ms *memberStream) Close() error {
+	closers := make([]io.Closer, 0, 3)
+	if ms.current != nil {
+		closers = append(closers, ms.current)
+	}
+	if ms.tarCloser != nil {
+		closers = append(closers, ms.tarCloser)
+	}
+	if ms.zipReader != nil {
+		closers = append(closers, ms.zipReader)
+	}
+	var firstErr error
+	for _, c := range closers {
+		if err := c.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,305 @@
+package core
+
+// -------------------------------------------------------------------------------------
+// compact binary log encoding (the parsed fields of each line, length-prefixed and string-interned), which IngestStream
+// reads back directly--skipping line scanning and escape decoding on repeated runs over the same logs
+// -------------------------------------------------------------------------------------
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+)
+
+// binaryMagic starts every binary log (the NUL keeps it from ever being mistaken for a text log, or vice versa)
+var binaryMagic = []byte("\x00VV8B\x01")
+
+// binaryReadBufferSize is the read buffer used by IngestStream (also bounding the header, and its root name, in size)
+const binaryReadBufferSize = 64 * 1024
+
+// Line kinds (one per text line, in order, then binaryEnd)
+const (
+	binaryBlank     byte = iota // empty line
+	binaryRecord                // code byte, field count, fields
+	binaryBadRecord             // code byte, description of its first invalid escape, field count, fields
+	binaryTruncated             // length of the final line, which had no newline (the line itself follows)
+	binaryEnd                   // total bytes of the text log
+)
+
+// Field encodings
+const (
+	fieldLiteral byte = iota // length, bytes
+	fieldIntern              // length, bytes (and added to the string table)
+	fieldRef                 // string table index
+	fieldInt                 // zig-zag varint (only for fields that are canonical decimal integers, e.g., offsets)
+)
+
+// Interning limits (long strings are rarely repeated; the table must stay bounded on huge logs)
+const (
+	maxInternLength = 256
+	maxInternCount  = 1 << 22
+)
+
+// maxBinaryStringLength matches the longest line newLogLineScanner accepts
+const maxBinaryStringLength = 128 * 1024 * 1024
+
+// BinaryLogHeader describes a binary log
+type BinaryLogHeader struct {
+	// Root name of the text log it was converted from (to keep aggregator output identical)
+	RootName string
+}
+
+// peekBinaryHeader checks for (and decodes, without consuming anything) a binary log header; nil means a text log
+func peekBinaryHeader(reader *bufio.Reader) (*BinaryLogHeader, error) {
+	magic, _ := reader.Peek(len(binaryMagic))
+	if !bytes.Equal(magic, binaryMagic) {
+		return nil, nil
+	}
+	head, _ := reader.Peek(binaryReadBufferSize)
+	headReader := bytes.NewReader(head[len(binaryMagic):])
+	nameLength, err := binary.ReadUvarint(headReader)
+	if err != nil || nameLength > uint64(headReader.Len()) {
+		return nil, fmt.Errorf("corrupt binary log header")
+	}
+	name := make([]byte, nameLength)
+	headReader.Read(name)
+	return &BinaryLogHeader{RootName: string(name)}, nil
+}
+
+// PeekBinaryLog checks if a stream holds a binary log, returning its header (nil for text logs) and a replacement
+// stream to read from (still positioned at the start)
+func PeekBinaryLog(stream io.Reader) (io.Reader, *BinaryLogHeader, error) {
+	reader := bufio.NewReaderSize(stream, binaryReadBufferSize)
+	header, err := peekBinaryHeader(reader)
+	return reader, header, err
+}
+
+// binaryWriter encodes lines for a binary log
+type binaryWriter struct {
+	out     *bufio.Writer
+	strings map[string]uint64
+	scratch [binary.MaxVarintLen64]byte
+}
+
+func (bw *binaryWriter) uvarint(n uint64) {
+	bw.out.Write(bw.scratch[:binary.PutUvarint(bw.scratch[:], n)])
+}
+
+func (bw *binaryWriter) bytes(b string) {
+	bw.uvarint(uint64(len(b)))
+	bw.out.WriteString(b)
+}
+
+func (bw *binaryWriter) field(field string) {
+	if n, err := strconv.ParseInt(field, 10, 64); err == nil && strconv.FormatInt(n, 10) == field {
+		bw.out.WriteByte(fieldInt)
+		bw.out.Write(bw.scratch[:binary.PutVarint(bw.scratch[:], n)])
+	} else if index, ok := bw.strings[field]; ok {
+		bw.out.WriteByte(fieldRef)
+		bw.uvarint(index)
+	} else if len(field) <= maxInternLength && len(bw.strings) < maxInternCount {
+		bw.strings[field] = uint64(len(bw.strings))
+		bw.out.WriteByte(fieldIntern)
+		bw.bytes(field)
+	} else {
+		bw.out.WriteByte(fieldLiteral)
+		bw.bytes(field)
+	}
+}
+
+// ConvertStream re-encodes a text log as a binary log (rootName is recorded for use in place of the binary log's own name)
+func ConvertStream(stream io.Reader, out io.Writer, rootName string) error {
+	reader := bufio.NewReaderSize(stream, binaryReadBufferSize)
+	if header, err := peekBinaryHeader(reader); err != nil {
+		return err
+	} else if header != nil {
+		return fmt.Errorf("already a binary log")
+	}
+	if len(rootName) > binaryReadBufferSize/2 {
+		return fmt.Errorf("root name too long")
+	}
+
+	bw := &binaryWriter{
+		out:     bufio.NewWriter(out),
+		strings: make(map[string]uint64),
+	}
+	bw.out.Write(binaryMagic)
+	bw.bytes(rootName)
+
+	scan := newLogLineScanner(reader)
+	var lineCount int
+	var byteCount int64
+	for scan.Scan() {
+		line := scan.Bytes()
+		lineCount++
+		byteCount += int64(len(line)) + 1
+		if scan.truncated {
+			// (lenient ingestion drops the line that follows; strict ingestion takes it as usual)
+			byteCount--
+			bw.out.WriteByte(binaryTruncated)
+			bw.uvarint(uint64(len(line)))
+		}
+		if len(line) == 0 {
+			bw.out.WriteByte(binaryBlank)
+			continue
+		}
+		fields, problem := splitFieldsChecked(line[1:])
+		if problem != "" {
+			bw.out.WriteByte(binaryBadRecord)
+			bw.out.WriteByte(line[0])
+			bw.bytes(problem)
+		} else {
+			bw.out.WriteByte(binaryRecord)
+			bw.out.WriteByte(line[0])
+		}
+		bw.uvarint(uint64(len(fields)))
+		for _, field := range fields {
+			bw.field(field)
+		}
+	}
+	if scan.Err() != nil {
+		return scan.Err()
+	}
+	bw.out.WriteByte(binaryEnd)
+	bw.uvarint(uint64(byteCount))
+	log.Printf("%s: converted %d lines (%d bytes) with %d interned strings\n", rootName, lineCount, byteCount, len(bw.strings))
+	return bw.out.Flush()
+}
+
+// binaryReader decodes lines from a binary log
+type binaryReader struct {
+	in      *bufio.Reader
+	strings []string
+}
+
+func (br *binaryReader) uvarint() (uint64, error) {
+	n, err := binary.ReadUvarint(br.in)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (br *binaryReader) bytes() (string, error) {
+	length, err := br.uvarint()
+	if err != nil {
+		return "", err
+	} else if length > maxBinaryStringLength {
+		return "", fmt.Errorf("corrupt binary log (%d-byte string)", length)
+	}
+	buf := make([]byte, length)
+	_, err = io.ReadFull(br.in, buf)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return string(buf), err
+}
+
+func (br *binaryReader) field() (string, error) {
+	tag, err := br.in.ReadByte()
+	if err != nil {
+		return "", io.ErrUnexpectedEOF
+	}
+	switch tag {
+	case fieldInt:
+		n, err := binary.ReadVarint(br.in)
+		if err != nil {
+			return "", io.ErrUnexpectedEOF
+		}
+		return strconv.FormatInt(n, 10), nil
+	case fieldRef:
+		index, err := br.uvarint()
+		if err != nil {
+			return "", err
+		} else if index >= uint64(len(br.strings)) {
+			return "", fmt.Errorf("corrupt binary log (string #%d of %d)", index, len(br.strings))
+		}
+		return br.strings[index], nil
+	case fieldIntern:
+		s, err := br.bytes()
+		br.strings = append(br.strings, s)
+		return s, err
+	case fieldLiteral:
+		return br.bytes()
+	}
+	return "", fmt.Errorf("corrupt binary log (field tag %d)", tag)
+}
+
+// ingestBinaryStream is IngestStream for binary logs (yielding exactly what ingesting the original text would)
+func (ln *LogInfo) ingestBinaryStream(reader *bufio.Reader, aggs []Aggregator) error {
+	reader.Discard(len(binaryMagic))
+	br := &binaryReader{in: reader}
+	if _, err := br.bytes(); err != nil {
+		return err
+	}
+
+	var lineCount int
+	var fields []string
+	var truncated, dropping bool // (after a binaryTruncated marker: the final, newline-less line follows, unless converted by an older build)
+	for {
+		kind, err := reader.ReadByte()
+		if err != nil {
+			return fmt.Errorf("binary log ends without its trailer")
+		}
+		switch kind {
+		case binaryEnd:
+			byteCount, err := br.uvarint()
+			if err != nil {
+				return err
+			}
+			ln.finishStream(lineCount, int64(byteCount))
+			return nil
+		case binaryBlank:
+			lineCount++
+		case binaryTruncated:
+			lineCount++
+			length, err := br.uvarint()
+			if err != nil {
+				return err
+			}
+			truncated, dropping = true, ln.dropTruncatedLine(lineCount, int(length))
+			if dropping {
+				lineCount--
+			}
+		case binaryRecord, binaryBadRecord:
+			if !truncated {
+				lineCount++
+			}
+			code, err := reader.ReadByte()
+			if err != nil {
+				return io.ErrUnexpectedEOF
+			}
+			var problem string
+			if kind == binaryBadRecord {
+				if problem, err = br.bytes(); err != nil {
+					return err
+				}
+			}
+			count, err := br.uvarint()
+			if err != nil {
+				return err
+			} else if count > maxBinaryStringLength {
+				return fmt.Errorf("corrupt binary log (%d fields)", count)
+			}
+			fields = make([]string, count) // (aggregators may hold on to field slices)
+			for i := range fields {
+				if fields[i], err = br.field(); err != nil {
+					return err
+				}
+			}
+			if dropping {
+				continue
+			}
+			err = ln.ingestLine(lineCount, code, fields, problem, aggs)
+			if err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("corrupt binary log (line kind %d)", kind)
+		}
+	}
+}
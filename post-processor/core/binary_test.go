@@ -0,0 +1,108 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// recordingAggregator notes every trace record it is handed, along with the context it came in
+type recordingAggregator struct {
+	records []string
+}
+
+func (agg *recordingAggregator) IngestRecord(ctx *ExecutionContext, lineNumber int, op byte, fields []string) error {
+	var script, origin string
+	if ctx.Script != nil {
+		script = fmt.Sprintf("%s/%d", ctx.Script.Isolate.ID, ctx.Script.ID)
+	}
+	if ctx.Origin != nil {
+		origin = ctx.Origin.Origin
+	}
+	agg.records = append(agg.records, fmt.Sprintf("%d %c %s %s %q", lineNumber, op, script, origin, fields))
+	return nil
+}
+
+// typedRecordingAggregator notes every (parsed) trace record it is handed
+type typedRecordingAggregator struct {
+	recordingAggregator
+}
+
+func (agg *typedRecordingAggregator) IngestTypedRecord(ctx *ExecutionContext, rec *Record) error {
+	agg.records = append(agg.records, fmt.Sprintf("%+v", *rec))
+	return nil
+}
+
+// ingestForTest ingests a log (text or binary), returning what aggregators (raw and typed) saw and the resulting LogInfo
+func ingestForTest(data []byte, lenient bool) ([]string, *LogInfo, error) {
+	agg, typedAgg := new(recordingAggregator), new(typedRecordingAggregator)
+	ln := NewLogInfo([12]byte{}, "vv8-test.0.log", [16]byte{})
+	ln.Lenient = lenient
+	ln.DeriveID = true
+	ln.AnomalyHook = func(*AnomalyError) {}
+	err := ln.IngestStream(bytes.NewReader(data), agg, typedAgg)
+	return append(agg.records, typedAgg.records...), ln, err
+}
+
+func TestBinaryLogEquivalence(t *testing.T) {
+	logs := map[string][]byte{
+		"blank lines":     []byte("~0x1\n@\"https://a.test\"\n\n$1:\"https://a.test/a.js\":x()\n!1\n\nc12:%x:{Window}\n\n"),
+		"escapes":         []byte("~0x1\n@\"https://a.test\"\n$1:\"https://a.test/\\:a.js\":\\u00e9\\ud83d\\ude00\n!1\nc3:%f\\\\:\"\\x41\\:\":\n"),
+		"bad escapes":     []byte("~0x1\n@\"https://a.test\"\n$1::\n!1\nc3:%f:\"\\xzz\":\"\\ud800\"\n"),
+		"truncated":       []byte("~0x1\n@\"https://a.test\"\n$1::\n!1\nc3:%f\nc4:%g:\"cut sh"),
+		"context missing": []byte("c3:%f\n!7\ng5:{Window}:\"x\"\n"),
+	}
+	paths, err := filepath.Glob("../../tests/logs/*/*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		logs[path] = data
+	}
+	if len(paths) == 0 {
+		t.Log("no logs found under tests/logs; checking built-in logs only")
+	}
+
+	for name, text := range logs {
+		var binary bytes.Buffer
+		if err := ConvertStream(bytes.NewReader(text), &binary, "vv8-test.0.log"); err != nil {
+			t.Fatalf("%s: ConvertStream: %v", name, err)
+		}
+		for _, lenient := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s/lenient=%v", name, lenient), func(t *testing.T) {
+				textRecords, textLn, textErr := ingestForTest(text, lenient)
+				binaryRecords, binaryLn, binaryErr := ingestForTest(binary.Bytes(), lenient)
+				if fmt.Sprint(textErr) != fmt.Sprint(binaryErr) {
+					t.Fatalf("errors differ: text %v, binary %v", textErr, binaryErr)
+				}
+				if !reflect.DeepEqual(textRecords, binaryRecords) {
+					t.Errorf("aggregator saw different records:\ntext:   %q\nbinary: %q", textRecords, binaryRecords)
+				}
+				if textErr != nil {
+					return
+				}
+				if textLn.Stats != binaryLn.Stats {
+					t.Errorf("stats differ: text %+v, binary %+v", textLn.Stats, binaryLn.Stats)
+				}
+				if textLn.ID != binaryLn.ID || textLn.Digest != binaryLn.Digest {
+					t.Errorf("log IDs differ: text %s, binary %s", textLn.ID, binaryLn.ID)
+				}
+				for _, data := range [][]byte{text, binary.Bytes()} {
+					if id, err := PeekLogID(bytes.NewReader(data), "vv8-test.0.log", lenient); err != nil || id != textLn.ID {
+						t.Errorf("PeekLogID = %s (%v), want %s", id, err, textLn.ID)
+					}
+				}
+				if !reflect.DeepEqual(textLn.Anomalies, binaryLn.Anomalies) {
+					t.Errorf("anomalies differ: text %v, binary %v", textLn.Anomalies, binaryLn.Anomalies)
+				}
+			})
+		}
+	}
+}
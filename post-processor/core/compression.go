@@ -0,0 +1,183 @@
+package core
+
+// transparent decompression of (possibly) compressed log segments (gzip, zstd, xz)
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Compression identifies the (de)compression scheme applied to a log segment
+type Compression int
+
+const (
+	// Uncompressed means plain-text log data
+	Uncompressed Compression = iota
+	// Gzip means RFC 1952 gzip framing
+	Gzip
+	// Zstd means Zstandard framing
+	Zstd
+	// Xz means .xz (LZMA2) framing
+	Xz
+)
+
+func (c Compression) String() string {
+	switch c {
+	case Gzip:
+		return "gzip"
+	case Zstd:
+		return "zstd"
+	case Xz:
+		return "xz"
+	default:
+		return "none"
+	}
+}
+
+// compressionSuffixes maps recognized file name suffixes to their compression scheme
+var compressionSuffixes = map[string]Compression{
+	".gz":  Gzip,
+	".zst": Zstd,
+	".xz":  Xz,
+}
+
+// compressionMagic maps the leading "magic" bytes of each compressed format to its compression scheme
+var compressionMagic = []struct {
+	magic []byte
+	kind  Compression
+}{
+	{[]byte{0x1f, 0x8b}, Gzip},
+	{[]byte{0x28, 0xb5, 0x2f, 0xfd}, Zstd},
+	{[]byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, Xz},
+}
+
+// CompressionBySuffix guesses the compression scheme of a file from its name alone
+func CompressionBySuffix(name string) Compression {
+	for suffix, kind := range compressionSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return kind
+		}
+	}
+	return Uncompressed
+}
+
+// trimCompressionSuffix strips any recognized compression suffix from a file name
+func trimCompressionSuffix(name string) string {
+	for suffix := range compressionSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return strings.TrimSuffix(name, suffix)
+		}
+	}
+	return name
+}
+
+// sniffCompression identifies the compression scheme of a buffered stream by peeking at its magic bytes
+func sniffCompression(br *bufio.Reader) Compression {
+	for _, m := range compressionMagic {
+		head, _ := br.Peek(len(m.magic))
+		if bytes.Equal(head, m.magic) {
+			return m.kind
+		}
+	}
+	return Uncompressed
+}
+
+// decompressingReader bundles a decompressor with the raw stream under it, so both get closed together
+type decompressingReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (dr *decompressingReader) Close() error {
+	var firstErr error
+	for _, c := range dr.closers {
+		if err := c.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// zstdCloser adapts the (error-less) zstd.Decoder.Close to io.Closer
+type zstdCloser struct {
+	dec *zstd.Decoder
+}
+
+func (zc zstdCloser) Close() error {
+	zc.dec.Close()
+	return nil
+}
+
+// NewDecompressingReader wraps a raw log stream in the right decompressor (if any), identified by magic bytes
+// (closing the result closes <raw>, too, if it is an io.Closer)
+func NewDecompressingReader(raw io.Reader) (io.ReadCloser, error) {
+	stream, _, err := newDecompressingReader(raw)
+	return stream, err
+}
+
+func newDecompressingReader(raw io.Reader) (io.ReadCloser, Compression, error) {
+	dr := &decompressingReader{}
+	if closer, ok := raw.(io.Closer); ok {
+		dr.closers = append(dr.closers, closer)
+	}
+
+	br := bufio.NewReader(raw)
+	kind := sniffCompression(br)
+	switch kind {
+	case Gzip:
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			dr.Close()
+			return nil, kind, err
+		}
+		dr.Reader = gz
+		dr.closers = append([]io.Closer{gz}, dr.closers...)
+	case Zstd:
+		zs, err := zstd.NewReader(br)
+		if err != nil {
+			dr.Close()
+			return nil, kind, err
+		}
+		dr.Reader = zs
+		dr.closers = append([]io.Closer{zstdCloser{zs}}, dr.closers...)
+	case Xz:
+		xzr, err := xz.NewReader(br)
+		if err != nil {
+			dr.Close()
+			return nil, kind, err
+		}
+		dr.Reader = xzr
+	default:
+		dr.Reader = br
+	}
+	return dr, kind, nil
+}
+
+// OpenLogSegment opens a (possibly compressed) log segment file for reading, decompressing on the fly
+func OpenLogSegment(name string) (io.ReadCloser, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	stream, kind, err := newDecompressingReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	// Magic bytes win, but a name/content mismatch is worth a warning (e.g., a mislabeled or truncated archive)
+	if suffixKind := CompressionBySuffix(name); suffixKind != kind {
+		log.Printf("WARNING: %s looks like %s data, not %s (going with %s)\n", name, kind, suffixKind, kind)
+	} else if kind != Uncompressed {
+		log.Printf("Decompressing %s (%s)...\n", name, kind)
+	}
+	return stream, nil
+}
@@ -0,0 +1,536 @@
+package core
+
+// -------------------------------------------------------------------------------------
+// PostgreSQL COPY-format output, for crawlers that cannot reach PG: one <dir>/<table>.tsv file per table
+// (COPY's default text format, same columns as the aggregators' own pq.CopyIn calls), loaded later by LoadCopyDir
+// -------------------------------------------------------------------------------------
+
+import (
+	"bufio"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/lib/pq"
+)
+
+// CopyTable describes a COPY load file: rows bound for one PG table, with columns in Fields order
+//
+// Rows give the log's UUID (not a logfile.id) in any logfile_id (or log_file_id) column; LoadCopyDir swaps in the
+// real ID. Likewise, any visit_domain (or root_domain) column holds -rootdomain (if given), which LoadCopyDir replaces
+// with the log's submission URL wherever PG knows the submission (as GetRootDomain would look it up).
+type CopyTable struct {
+	Name   string   // PG table (and file) name
+	Fields []string // columns, in file order
+	Schema string   // column definitions of the temp import table (if not simply Fields, as typed in the PG table)
+	Load   string   // SQL moving rows from temp table "import_<Name>" into PG (if not a plain INSERT)
+}
+
+// CopySink receives COPY rows for any number of tables (implementations must be safe for concurrent use)
+type CopySink interface {
+	CopyRow(table *CopyTable, row ...interface{}) error
+}
+
+// CopyDumper implements dumping of output data as the rows the aggregator would otherwise COPY into PG itself
+type CopyDumper interface {
+	DumpToCopy(ctx *AggregationContext, sink CopySink) error
+}
+
+// LogfileCopyTable describes each log processed (as InsertLogfile would record it; finished once loaded)
+var LogfileCopyTable = &CopyTable{
+	Name:   "logfile",
+	Fields: []string{"mongo_oid", "uuid", "root_name", "size", "lines", "submissionid"},
+	Load: `
+INSERT INTO logfile (mongo_oid, uuid, root_name, size, lines, submissionid, finished_at)
+	SELECT il.mongo_oid, il.uuid, il.root_name, il.size, il.lines, il.submissionid, now()
+	FROM import_logfile AS il
+ON CONFLICT DO NOTHING;
+`,
+}
+
+// URLCopyTable holds baked URLs (referenced by SHA256 from other tables), loaded as InsertBakedURLs would
+var URLCopyTable = &CopyTable{
+	Name:   "urls",
+	Fields: urlImportFields[:],
+	Load: `
+INSERT INTO urls (
+		sha256, url_full, url_scheme, url_hostname, url_port,
+		url_path, url_query, url_etld1, url_stemmed)
+	SELECT
+		iu.sha256, iu.url_full, iu.url_scheme, iu.url_hostname, iu.url_port,
+		iu.url_path, iu.url_query, iu.url_etld1, iu.url_stemmed
+	FROM import_urls AS iu
+ON CONFLICT DO NOTHING;
+`,
+}
+
+// CopyBakedURLs writes the stash of cooked URLs as URLCopyTable rows
+func (ub *URLBakery) CopyBakedURLs(sink CopySink) error {
+	for _, curl := range ub.stash {
+		if err := sink.CopyRow(URLCopyTable, curl.importValues()...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewCopyDumpDriver creates a driver function to invoke CopyDumper logic on the given aggregator (if possible),
+// first writing the log's own LogfileCopyTable row
+func NewCopyDumpDriver(sink CopySink) DumpDriver {
+	logged := false
+	return func(agg Aggregator, ctx *AggregationContext) error {
+		if !logged {
+			logged = true
+			err := sink.CopyRow(LogfileCopyTable,
+				ctx.Ln.mongoOIDColumn(), ctx.Ln.ID.String(), ctx.Ln.RootName, ctx.Ln.Stats.Bytes, ctx.Ln.Stats.Lines,
+				ctx.Ln.SubmissionID.String())
+			if err != nil {
+				return err
+			}
+		}
+
+		dumper, ok := agg.(CopyDumper)
+		if ok {
+			return dumper.DumpToCopy(ctx, sink)
+		}
+		return fmt.Errorf("%T does not support COPY dumping", agg)
+	}
+}
+
+// isLogColumn reports whether a COPY column holds log UUIDs (resolved to logfile IDs on loading)
+func isLogColumn(field string) bool {
+	return field == "logfile_id" || field == "log_file_id"
+}
+
+// isDomainColumn reports whether a COPY column holds the visit domain (resolved from submissions on loading)
+func isDomainColumn(field string) bool {
+	return field == "visit_domain" || field == "root_domain"
+}
+
+// appendCopyValue appends a value in COPY text format (\N for nil, "\x..." hex for bytea, t/f for booleans)
+func appendCopyValue(buf []byte, value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case nil:
+		return append(buf, `\N`...), nil
+	case string:
+		for i := 0; i < len(v); i++ {
+			switch c := v[i]; c {
+			case '\\':
+				buf = append(buf, `\\`...)
+			case '\n':
+				buf = append(buf, `\n`...)
+			case '\r':
+				buf = append(buf, `\r`...)
+			case '\t':
+				buf = append(buf, `\t`...)
+			default:
+				buf = append(buf, c)
+			}
+		}
+		return buf, nil
+	case []byte:
+		buf = append(buf, `\\x`...) // (the bytea hex prefix, with its backslash escaped)
+		return hex.AppendEncode(buf, v), nil
+	case int:
+		return strconv.AppendInt(buf, int64(v), 10), nil
+	case int64:
+		return strconv.AppendInt(buf, v, 10), nil
+	case float64:
+		if math.IsInf(v, 1) {
+			return append(buf, "Infinity"...), nil
+		} else if math.IsInf(v, -1) {
+			return append(buf, "-Infinity"...), nil
+		}
+		return strconv.AppendFloat(buf, v, 'g', -1, 64), nil
+	case bool:
+		if v {
+			return append(buf, 't'), nil
+		}
+		return append(buf, 'f'), nil
+	case driver.Valuer: // (e.g., pq.Array)
+		dv, err := v.Value()
+		if err != nil {
+			return buf, err
+		}
+		return appendCopyValue(buf, dv)
+	}
+	return buf, fmt.Errorf("unsupported COPY value %#v", value)
+}
+
+// splitCopyLine splits a COPY text-format line (sans newline) into its unescaped values (nil for \N)
+func splitCopyLine(line string) []interface{} {
+	var values []interface{}
+	for _, field := range strings.Split(line, "\t") {
+		if field == `\N` {
+			values = append(values, nil)
+			continue
+		}
+		if strings.IndexByte(field, '\\') < 0 {
+			values = append(values, field)
+			continue
+		}
+		var sb strings.Builder
+		for i := 0; i < len(field); i++ {
+			c := field[i]
+			if c == '\\' && i+1 < len(field) {
+				i++
+				switch c = field[i]; c {
+				case 'b':
+					c = '\b'
+				case 'f':
+					c = '\f'
+				case 'n':
+					c = '\n'
+				case 'r':
+					c = '\r'
+				case 't':
+					c = '\t'
+				case 'v':
+					c = '\v'
+				}
+			}
+			sb.WriteByte(c)
+		}
+		values = append(values, sb.String())
+	}
+	return values
+}
+
+// CopyDirSink is a CopySink writing <dir>/<table>.tsv files (call Close once all rows are in, to flush them)
+type CopyDirSink struct {
+	dir   string
+	lock  sync.Mutex
+	files map[string]*copyFile
+}
+
+// copyFile is a COPY file being written
+type copyFile struct {
+	table   *CopyTable
+	file    *os.File
+	out     *bufio.Writer
+	line    []byte
+	numRows int64
+}
+
+// NewCopyDirSink creates a CopyDirSink writing into an (existing) directory
+func NewCopyDirSink(dir string) *CopyDirSink {
+	return &CopyDirSink{
+		dir:   dir,
+		files: make(map[string]*copyFile),
+	}
+}
+
+// CopyRow writes a row to its table's file (creating the file on its first row)
+func (cs *CopyDirSink) CopyRow(table *CopyTable, row ...interface{}) error {
+	if len(row) != len(table.Fields) {
+		return fmt.Errorf("%s: row has %d values (wanted %d)", table.Name, len(row), len(table.Fields))
+	}
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	cf, ok := cs.files[table.Name]
+	if !ok {
+		file, err := os.OpenFile(filepath.Join(cs.dir, table.Name+".tsv"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return err
+		}
+		cf = &copyFile{table: table, file: file, out: bufio.NewWriter(file)}
+		cs.files[table.Name] = cf
+	} else if cf.table != table {
+		return fmt.Errorf("%s: table declared twice", table.Name)
+	}
+
+	var err error
+	cf.line = cf.line[:0]
+	for i, value := range row {
+		if i > 0 {
+			cf.line = append(cf.line, '\t')
+		}
+		cf.line, err = appendCopyValue(cf.line, value)
+		if err != nil {
+			return fmt.Errorf("%s.%s: %w", table.Name, table.Fields[i], err)
+		}
+	}
+	cf.line = append(cf.line, '\n')
+	if _, err = cf.out.Write(cf.line); err != nil {
+		return err
+	}
+	cf.numRows++
+	return nil
+}
+
+// Close flushes and closes every file written (returning the first error encountered, if any)
+func (cs *CopyDirSink) Close() error {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	names := make([]string, 0, len(cs.files))
+	for name := range cs.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var firstErr error
+	for _, name := range names {
+		cf := cs.files[name]
+		err := cf.out.Flush()
+		if closeErr := cf.file.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			log.Printf("copy: wrote %d rows to %s\n", cf.numRows, cf.file.Name())
+		} else if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// importSchema gives the DDL creating the (transaction-scoped) temp import table of a COPY table
+func (table *CopyTable) importSchema() string {
+	if table.Schema != "" {
+		return fmt.Sprintf(`CREATE TEMP TABLE "import_%s" (%s) ON COMMIT DROP;`, table.Name, table.Schema)
+	}
+	columns := make([]string, len(table.Fields))
+	for i, field := range table.Fields {
+		if isLogColumn(field) {
+			columns[i] = "NULL::TEXT AS " + field // (log UUIDs, until resolved)
+		} else {
+			columns[i] = field
+		}
+	}
+	return fmt.Sprintf(`CREATE TEMP TABLE "import_%s" ON COMMIT DROP AS SELECT %s FROM "%s" WITH NO DATA;`,
+		table.Name, strings.Join(columns, ", "), table.Name)
+}
+
+// loadSQL gives the SQL moving a COPY table's imported rows into PG (by default, inserting the rows of newly loaded logs)
+func (table *CopyTable) loadSQL() string {
+	if table.Load != "" {
+		return table.Load
+	}
+	values := make([]string, len(table.Fields))
+	join := ""
+	for i, field := range table.Fields {
+		if isLogColumn(field) {
+			values[i] = "lf.id"
+			join = fmt.Sprintf(`
+		INNER JOIN import_logfile AS il ON (il.uuid = i.%s)
+		INNER JOIN logfile AS lf ON (lf.uuid = il.uuid)`, field)
+		} else {
+			values[i] = "i." + field
+		}
+	}
+	for i, field := range table.Fields {
+		if isDomainColumn(field) && join != "" {
+			values[i] = fmt.Sprintf("COALESCE(ivd.visit_domain, i.%s)", field)
+			join += `
+		LEFT JOIN import_visit_domains AS ivd ON (ivd.uuid = il.uuid)`
+			break
+		}
+	}
+	return fmt.Sprintf(`
+INSERT INTO "%s" (%s)
+	SELECT %s
+	FROM "import_%s" AS i%s;
+`, table.Name, strings.Join(table.Fields, ", "), strings.Join(values, ", "), table.Name, join)
+}
+
+// copyInFile bulk-inserts a COPY file into its table's temp import table (within the given transaction)
+func copyInFile(txn *sql.Tx, table *CopyTable, name string) (int64, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	if _, err = txn.Exec(table.importSchema()); err != nil {
+		return 0, fmt.Errorf("%s: %w", table.Name, err)
+	}
+	stmt, err := txn.Prepare(pq.CopyIn("import_"+table.Name, table.Fields...))
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	var rowCount int64
+	in := bufio.NewReader(file)
+	for {
+		line, err := in.ReadString('\n')
+		if err == io.EOF && line == "" {
+			break
+		} else if err != nil && err != io.EOF {
+			return 0, err
+		}
+		values := splitCopyLine(strings.TrimSuffix(line, "\n"))
+		if len(values) != len(table.Fields) {
+			return 0, fmt.Errorf("%s:%d: row has %d values (wanted %d)", name, rowCount+1, len(values), len(table.Fields))
+		}
+		if _, err = stmt.Exec(values...); err != nil {
+			return 0, fmt.Errorf("%s:%d: %w", name, rowCount+1, err)
+		}
+		rowCount++
+	}
+	if _, err = stmt.Exec(); err != nil {
+		return 0, fmt.Errorf("%s: %w", name, err)
+	}
+	return rowCount, nil
+}
+
+// replaceLoadedLogs deletes (within the load transaction) every log in import_logfile loaded before, with all its rows
+func replaceLoadedLogs(txn *sql.Tx, dir string) error {
+	rows, err := txn.Query(`SELECT lf.id FROM logfile AS lf INNER JOIN import_logfile AS il ON (il.uuid = lf.uuid);`)
+	if err != nil {
+		return err
+	}
+	var logIDs []int
+	for rows.Next() {
+		var logID int
+		if err = rows.Scan(&logID); err != nil {
+			rows.Close()
+			return err
+		}
+		logIDs = append(logIDs, logID)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, logID := range logIDs {
+		if err = deleteLogfileRows(txn, logID); err != nil {
+			return err
+		}
+	}
+	if len(logIDs) > 0 {
+		log.Printf("copy: %s: replacing %d logs loaded already\n", dir, len(logIDs))
+	}
+	return nil
+}
+
+// resolveVisitDomains fills temp table import_visit_domains with the submission URL of each log being loaded
+// (where PG has a submissions table that knows it), for loadSQL to prefer over the -rootdomain written out
+func resolveVisitDomains(txn *sql.Tx) error {
+	_, err := txn.Exec(`CREATE TEMP TABLE import_visit_domains (uuid TEXT PRIMARY KEY, visit_domain TEXT NOT NULL) ON COMMIT DROP;`)
+	if err != nil {
+		return err
+	}
+	var haveSubmissions bool
+	if err = txn.QueryRow(`SELECT to_regclass('submissions') IS NOT NULL;`).Scan(&haveSubmissions); err != nil {
+		return err
+	} else if !haveSubmissions {
+		return nil
+	}
+	_, err = txn.Exec(`
+INSERT INTO import_visit_domains (uuid, visit_domain)
+	SELECT il.uuid, s.url
+	FROM import_logfile AS il
+		INNER JOIN submissions AS s ON (s.id::TEXT = il.submissionid)
+	WHERE s.url IS NOT NULL
+ON CONFLICT DO NOTHING;
+`)
+	return err
+}
+
+// LoadCopyDir loads a directory of COPY files (as written by a CopyDirSink) into PG as one transaction,
+// resolving logfile_id and visit_domain columns (and whatever else each table's Load SQL resolves) along the way
+//
+// Logs already in PG are skipped, so loading the same directory again changes nothing (unless replacing them,
+// in which case their earlier rows are deleted first, within the same transaction).
+func LoadCopyDir(sqlDb *sql.DB, dir string, tables []*CopyTable, replace bool) error {
+	// (every file must belong to a known table, lest rows go missing unnoticed)
+	known := map[string]bool{LogfileCopyTable.Name: true}
+	for _, table := range tables {
+		known[table.Name] = true
+	}
+	names, err := filepath.Glob(filepath.Join(dir, "*.tsv"))
+	if err != nil {
+		return err
+	}
+	present := make(map[string]bool)
+	for _, name := range names {
+		tableName := strings.TrimSuffix(filepath.Base(name), ".tsv")
+		if !known[tableName] {
+			return fmt.Errorf("%s: no COPY table '%s' is known", name, tableName)
+		}
+		present[tableName] = true
+	}
+	if !present[LogfileCopyTable.Name] {
+		return fmt.Errorf("%s: no logfile.tsv (not a COPY output directory?)", dir)
+	}
+
+	txn, err := sqlDb.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if txn != nil {
+			txn.Rollback()
+		}
+	}()
+
+	// Logs first (minus those loaded before), so other tables can look up their logfile IDs
+	logCount, err := copyInFile(txn, LogfileCopyTable, filepath.Join(dir, "logfile.tsv"))
+	if err != nil {
+		return err
+	}
+	var skipCount int64
+	if replace {
+		if err = replaceLoadedLogs(txn, dir); err != nil {
+			return err
+		}
+	} else {
+		result, err := txn.Exec(`DELETE FROM import_logfile AS il USING logfile AS lf WHERE lf.uuid = il.uuid;`)
+		if err != nil {
+			return err
+		}
+		if skipCount, err = result.RowsAffected(); err != nil {
+			return err
+		}
+	}
+	if skipCount == logCount {
+		log.Printf("copy: %s: all %d logs were loaded already; nothing to do!\n", dir, logCount)
+		return nil
+	} else if skipCount > 0 {
+		log.Printf("copy: %s: skipping %d (out of %d) logs loaded already\n", dir, skipCount, logCount)
+	}
+	if _, err = txn.Exec(LogfileCopyTable.Load); err != nil {
+		return err
+	}
+	if err = resolveVisitDomains(txn); err != nil {
+		return err
+	}
+
+	for _, table := range tables {
+		if !present[table.Name] {
+			continue
+		}
+		importRows, err := copyInFile(txn, table, filepath.Join(dir, table.Name+".tsv"))
+		if err != nil {
+			return err
+		}
+		result, err := txn.Exec(table.loadSQL())
+		if err != nil {
+			return fmt.Errorf("%s: %w", table.Name, err)
+		}
+		insertRows, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		log.Printf("copy: %s: inserted %d (out of %d) %s rows\n", dir, insertRows, importRows, table.Name)
+	}
+
+	if err = txn.Commit(); err != nil {
+		return err
+	}
+	txn = nil
+	log.Printf("copy: %s: loaded %d logs\n", dir, logCount-skipCount)
+	return nil
+}
@@ -8,9 +8,11 @@ import (
 	"bufio"
 	"crypto/sha256"
 	"database/sql"
+	"encoding/binary"
 	"fmt"
 	"io"
 	"log"
+	"path/filepath"
 	"strconv"
 	"strings"
 
@@ -21,6 +23,24 @@ import (
 
 // Take a raw log string, expand all escape sequences, and split it into fields
 func splitFields(line []byte) []string {
+	fields, _ := splitFieldsChecked(line)
+	return fields
+}
+
+// splitFieldsChecked is splitFields, also describing the first invalid escape sequence found (if any);
+// invalid escapes are decoded just the same (best-effort) either way
+func splitFieldsChecked(line []byte) ([]string, string) {
+	var problem string
+	noteProblem := func(i int, format string, args ...interface{}) {
+		if problem == "" {
+			problem = fmt.Sprintf("column %d: ", i+1) + fmt.Sprintf(format, args...)
+		}
+	}
+	isHex := func(digits string) bool {
+		_, err := strconv.ParseUint(digits, 16, 16)
+		return err == nil
+	}
+
 	allFields := make([]string, 0, 8)
 	var curField strings.Builder
 	var curDigs strings.Builder
@@ -37,7 +57,11 @@ func splitFields(line []byte) []string {
 
 	state := Copy
 	surrogatePairFirst = -1
-	for _, c := range line {
+	for i, c := range line {
+		// (a high surrogate must be followed immediately by a "\u" escape, i.e., its low surrogate)
+		if surrogatePairFirst >= 0 && !(state == Esc && c == 'u') && state != Uni && !(state != Esc && c == '\\') {
+			noteProblem(i, "unpaired UTF-16 high surrogate")
+		}
 		switch state {
 		case Copy:
 			if c == '\\' {
@@ -72,6 +96,9 @@ func splitFields(line []byte) []string {
 		case Hex:
 			curDigs.WriteByte(c)
 			if curDigs.Len() == 2 {
+				if !isHex(curDigs.String()) {
+					noteProblem(i, "invalid \\x escape digits '%s'", curDigs.String())
+				}
 				code, _ := strconv.ParseUint(curDigs.String(), 16, 8)
 				curField.WriteRune(rune(code))
 				state = Copy
@@ -80,10 +107,18 @@ func splitFields(line []byte) []string {
 			curDigs.WriteByte(c)
 			if curDigs.Len() == 4 {
 				// A 16-bit Unicode codepoint--how hard could it be?
+				if !isHex(curDigs.String()) {
+					noteProblem(i, "invalid \\u escape digits '%s'", curDigs.String())
+				}
 				rcode, _ := strconv.ParseUint(curDigs.String(), 16, 16)
 				code := int(rcode)
 
 				// Oh the joys of UTF16...
+				if surrogatePairFirst >= 0 && (code < 0xdc00 || code > 0xdfff) {
+					noteProblem(i, "unpaired UTF-16 high surrogate (followed by \\u%04x)", code)
+				} else if surrogatePairFirst < 0 && code >= 0xdc00 && code <= 0xdfff {
+					noteProblem(i, "unpaired UTF-16 low surrogate \\u%04x", code)
+				}
 				if surrogatePairFirst >= 0 {
 					code = (code - 0xdc00) + surrogatePairFirst + 0x10000
 					surrogatePairFirst = -1
@@ -97,6 +132,12 @@ func splitFields(line []byte) []string {
 			}
 		}
 	}
+	if state == Esc || state == Hex || state == Uni {
+		noteProblem(len(line)-1, "incomplete escape sequence at end of line")
+	} else if surrogatePairFirst >= 0 {
+		noteProblem(len(line)-1, "unpaired UTF-16 high surrogate at end of line")
+	}
+
 	// Add on one last field if:
 	// * there is trailing data (normal case)
 	// * we ended on a ':' separator (corner case)
@@ -104,7 +145,7 @@ func splitFields(line []byte) []string {
 		allFields = append(allFields, curField.String())
 	}
 
-	return allFields
+	return allFields, problem
 }
 
 // FilterName identifies V8 object member names that should be filtered out of analysis
@@ -120,14 +161,20 @@ func FilterName(name string) bool {
 	}
 }
 
-// InsertLogfile inserts (if not present) a record about this log file into PG
-func (ln *LogInfo) InsertLogfile(sqldb *sql.DB) (int, error) {
+// LogfileInserter is the subset of *sql.DB (and *sql.Tx) used by InsertLogfile
+type LogfileInserter interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// InsertLogfile inserts (if not present) a record about this log file into PG (or a SQLite transaction)
+func (ln *LogInfo) InsertLogfile(sqldb LogfileInserter) (int, error) {
 	if !ln.Tabled {
 
 		query := `INSERT INTO logfile
 	(mongo_oid, uuid, root_name, size, lines, submissionid) VALUES ($1, $2, $3, $4, $5, $6)
 	ON CONFLICT DO NOTHING`
-		_, err := sqldb.Exec(query, ln.MongoID.String(), ln.ID.String(), ln.RootName, ln.Stats.Bytes, ln.Stats.Lines, ln.SubmissionID.String())
+		_, err := sqldb.Exec(query, ln.mongoOIDColumn(), ln.ID.String(), ln.RootName, ln.Stats.Bytes, ln.Stats.Lines, ln.SubmissionID.String())
 
 		if err != nil {
 			return 0, err
@@ -146,12 +193,50 @@ func (ln *LogInfo) InsertLogfile(sqldb *sql.DB) (int, error) {
 	return logID, nil
 }
 
+// mongoOIDColumn encodes the log's Mongo OID for logfile.mongo_oid the way it always has been: the bytes of its
+// String() form (e.g., `ObjectID("...")`), not the raw 12-byte OID
+func (ln *LogInfo) mongoOIDColumn() []byte {
+	return []byte(ln.MongoID.String())
+}
+
+// logIDNamespace scopes the (SHA1-based) UUIDs derived for logs from their root names and content
+var logIDNamespace = uuid.MustParse("5c0f6f4e-2d7a-4a55-9a36-8f1b1d3e6b20")
+
+// digestLine adds a (non-blank) line to the content hash: its line number, record code, and decoded fields,
+// all length-prefixed (blank lines show up as gaps in the line numbers)
+func (ln *LogInfo) digestLine(lineCount int, code byte, fields []string) {
+	buf := binary.AppendUvarint(ln.digestScratch[:0], uint64(lineCount))
+	buf = append(buf, code)
+	buf = binary.AppendUvarint(buf, uint64(len(fields)))
+	for _, field := range fields {
+		buf = binary.AppendUvarint(buf, uint64(len(field)))
+		buf = append(buf, field...)
+	}
+	ln.digest.Write(buf)
+	if cap(buf) <= 64*1024 {
+		ln.digestScratch = buf // (reused, unless a giant line made it huge)
+	}
+}
+
+// PeekLogID reads a whole log (text or binary) just to derive the ID that ingesting it (with DeriveID) would give,
+// without tracking context or aggregating--e.g., to find out whether a log was imported before paying to ingest it
+func PeekLogID(stream io.Reader, rootName string, lenient bool) (uuid.UUID, error) {
+	ln := NewLogInfo(primitive.NilObjectID, rootName, uuid.Nil)
+	ln.Lenient = lenient // (which decides whether a truncated final line counts)
+	ln.DeriveID = true
+	ln.digestOnly = true
+	ln.AnomalyHook = func(*AnomalyError) {}
+	if err := ln.IngestStream(stream); err != nil {
+		return uuid.Nil, err
+	}
+	return ln.ID, nil
+}
+
 // NewLogInfo constructs a fresh LogInfo for the given vv8log Mongo oid (if available) and root log filename (if available)
 func NewLogInfo(oid primitive.ObjectID, rootName string, submissionID uuid.UUID) *LogInfo {
 	return &LogInfo{
 		MongoID:      oid,
 		SubmissionID: submissionID,
-		ID:           uuid.New(),
 		RootName:     rootName,
 		Isolates:     make(map[string]*IsolateInfo),
 	}
@@ -172,11 +257,11 @@ func (ln *LogInfo) resetContext() {
 	ln.World.resetContext()
 }
 
-func (ln *LogInfo) addScript(id int, src string, code string) *ScriptInfo {
-	_, ok := ln.World.Scripts[id]
-
-	if ok {
-		panic(fmt.Errorf("redefining script ID %d in isolate %s", id, ln.World.ID))
+func (ln *LogInfo) addScript(lineNumber int, id int, src string, code string) (*ScriptInfo, error) {
+	oldScript, redefined := ln.World.Scripts[id]
+	if redefined && !oldScript.Placeholder {
+		// (Lenient mode keeps the first definition)
+		return oldScript, ln.tolerate(newAnomaly(lineNumber, AnomalyRedefinedScript, "redefining script ID %d in isolate %s", id, ln.World.ID))
 	}
 	script := NewScriptInfo(ln.World, id, code, ln.World.Context.Origin)
 
@@ -194,26 +279,55 @@ func (ln *LogInfo) addScript(id int, src string, code string) *ScriptInfo {
 			script.VisibleV8 = true
 		}
 	} else {
-		var parentScript *ScriptInfo
-		parentScript, ok = ln.World.Scripts[parentID]
+		parentScript, ok := ln.World.Scripts[parentID]
 		if !ok {
-			panic(fmt.Errorf("unknown parent script ID %d in isolate %s", parentID, ln.World.ID))
+			err = ln.tolerate(newAnomaly(lineNumber, AnomalyOrphanEval, "unknown parent script ID %d in isolate %s", parentID, ln.World.ID))
+			if err != nil {
+				return nil, err
+			}
+			parentScript = ln.addPlaceholderScript(parentID)
 		}
 		script.setEvaledBy(parentScript)
 		script.VisibleV8 = parentScript.VisibleV8
 	}
 
+	if ln.ScriptStore != nil {
+		err = script.spill(ln.ScriptStore)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if redefined {
+		// Fill in the placeholder in-place (aggregators may already hold pointers to it)
+		script.FirstOrigin = oldScript.FirstOrigin
+		*oldScript = *script
+		return oldScript, nil
+	}
 	ln.World.Scripts[id] = script
 
+	return script, nil
+}
+
+// addPlaceholderScript stands in an empty script for an ID the log never defined (so far)
+func (ln *LogInfo) addPlaceholderScript(id int) *ScriptInfo {
+	script := NewScriptInfo(ln.World, id, "", ln.World.Context.Origin)
+	script.Placeholder = true
+	ln.World.Scripts[id] = script
 	return script
 }
 
-func (ln *LogInfo) changeScript(id int) {
+func (ln *LogInfo) changeScript(lineNumber int, id int) error {
 	script, ok := ln.World.Scripts[id]
 	if !ok {
-		panic(fmt.Errorf("changing to undefined script ID %d in isolate %s", id, ln.World.ID))
+		err := ln.tolerate(newAnomaly(lineNumber, AnomalyUnknownScript, "changing to undefined script ID %d in isolate %s", id, ln.World.ID))
+		if err != nil {
+			return err
+		}
+		script = ln.addPlaceholderScript(id)
 	}
 	ln.World.Context.Script = script
+	return nil
 }
 
 func (ln *LogInfo) changeOrigin(url string, security_token string) {
@@ -261,6 +375,25 @@ func NewScriptInfo(iso *IsolateInfo, id int, code string, activeOrigin *Origin)
 	}
 }
 
+// Source returns the script's code, loading it from the script store if it was spilled there
+func (script *ScriptInfo) Source() (string, error) {
+	if script.store == nil {
+		return script.Code, nil
+	}
+	return script.store.Get(script.CodeHash)
+}
+
+// spill moves the script's code out to a script store
+func (script *ScriptInfo) spill(store *ScriptStore) error {
+	err := store.Put(script.CodeHash, script.Code)
+	if err != nil {
+		return err
+	}
+	script.Code = ""
+	script.store = store
+	return nil
+}
+
 func (script *ScriptInfo) setURL(url string) {
 	script.URL = url
 }
@@ -269,13 +402,118 @@ func (script *ScriptInfo) setEvaledBy(parent *ScriptInfo) {
 	script.EvaledBy = parent
 }
 
-// IngestStream is the entry point for parsing a given log and feeding the records into zero or more aggregators
+// placeholderIsolateID names the isolate lenient mode invents for records preceding any '~' header
+const placeholderIsolateID = "?"
+
+// contextFieldCounts gives the minimum number of fields required by each context record type
+var contextFieldCounts = map[byte]int{
+	'~': 1, // isolate
+	'$': 3, // script ID, URL or parent ID, source
+	'!': 1, // script ID
+	'@': 1, // origin[, security token]
+}
+
+// ingestContextRecord applies a context record (~, $, !, @) to the log state, returning true (and doing nothing) for
+// trace records; malformed records are anomalies (errors in strict mode, skipped or patched up in lenient mode)
+func (ln *LogInfo) ingestContextRecord(lineNumber int, code byte, fields []string) (bool, error) {
+	minFields, isContext := contextFieldCounts[code]
+	if isContext && len(fields) < minFields {
+		return false, ln.tolerate(newAnomaly(lineNumber, AnomalyShortRecord, "truncated '%c' record (%d fields, wanted at least %d): %v", code, len(fields), minFields, fields))
+	}
+	if code != '~' && ln.World == nil {
+		err := ln.tolerate(newAnomaly(lineNumber, AnomalyMissingIsolate, "'%c' record before any isolate ('~') record", code))
+		if err != nil {
+			return false, err
+		}
+		ln.changeIsolate(placeholderIsolateID)
+	}
+	if ln.Lenient && code != '~' && code != '@' && code != '!' && ln.World.Context.Origin == nil {
+		// (Strict mode has always let this slide, leaving nil origins for the aggregators to trip over)
+		ln.tolerate(newAnomaly(lineNumber, AnomalyMissingOrigin, "'%c' record before any origin ('@') record in isolate %s", code, ln.World.ID))
+		ln.changeOrigin("", "")
+	}
+
+	switch code {
+	case '~':
+		ln.changeIsolate(fields[0])
+	case '$':
+		scriptID, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return false, ln.tolerate(newAnomaly(lineNumber, AnomalyBadRecord, "invalid script ID '%s'", fields[0]))
+		}
+		_, err = ln.addScript(lineNumber, scriptID, fields[1], fields[2])
+		return false, err
+	case '!':
+		scriptID, err := strconv.Atoi(fields[0])
+		if err != nil {
+			ln.resetContext()
+		} else {
+			return false, ln.changeScript(lineNumber, scriptID)
+		}
+	case '@':
+		originString, _ := StripQuotes(fields[0])
+		originSecurityToken := "" // If no origin token is provided, assume it's empty (this is possible for cases for chrome internal JS during startup)
+		if len(fields) > 1 {
+			originSecurityToken, _ = StripQuotes(fields[1])
+		}
+		ln.changeOrigin(originString, originSecurityToken)
+	case 'c', 'n', 'g', 's', 'j':
+		return true, nil
+	default:
+		if ln.Lenient {
+			return false, ln.tolerate(newAnomaly(lineNumber, AnomalyUnknownRecord, "unknown record type '%c'", code))
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// logLineScanner is a bufio.Scanner over log lines that can tell if the final line was cut off before its newline
+type logLineScanner struct {
+	*bufio.Scanner
+	truncated bool
+}
+
+// newLogLineScanner prepares a line scanner (with support for LOOOONG lines) over a log stream
+func newLogLineScanner(stream io.Reader) *logLineScanner {
+	ls := &logLineScanner{Scanner: bufio.NewScanner(stream)}
+	ls.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), 128*1024*1024)
+	ls.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := bufio.ScanLines(data, atEOF)
+		if atEOF && token != nil && advance == len(data) && data[len(data)-1] != '\n' {
+			ls.truncated = true
+		}
+		return advance, token, err
+	})
+	return ls
+}
+
+// dropTruncatedLine handles a final line lacking its newline: lenient mode (and so linting) reports it as an anomaly
+// and drops it (a partial record is worse than none; nor does it count as a line), while strict mode processes it like any other line
+func (ln *LogInfo) dropTruncatedLine(lineCount int, length int) bool {
+	if !ln.Lenient {
+		return false
+	}
+	ln.tolerate(newAnomaly(lineCount, AnomalyTruncatedLine, "final line truncated (no newline after %d bytes)", length))
+	return true
+}
+
+// IngestStream is the entry point for parsing a given log (text, or binary from ConvertStream) and feeding the records into zero or more aggregators
 func (ln *LogInfo) IngestStream(stream io.Reader, aggs ...Aggregator) error {
-	// Read lines from input
-	scan := bufio.NewScanner(stream)
+	if ln.DeriveID {
+		ln.digest = sha256.New()
+	} else if ln.ID == uuid.Nil {
+		ln.ID = uuid.New() // (nobody keeps it, but stream records still tell their logs apart)
+	}
+	reader := bufio.NewReaderSize(stream, binaryReadBufferSize)
+	if header, err := peekBinaryHeader(reader); err != nil {
+		return err
+	} else if header != nil {
+		return ln.ingestBinaryStream(reader, aggs)
+	}
 
-	// Support LOOOONG lines
-	scan.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), 128*1024*1024)
+	// Read lines from input
+	scan := newLogLineScanner(reader)
 
 	// Start processing log lines
 	var lineCount int
@@ -284,48 +522,100 @@ func (ln *LogInfo) IngestStream(stream io.Reader, aggs ...Aggregator) error {
 		line := scan.Bytes()
 		lineCount++
 		byteCount += int64(len(line)) + 1
+		if scan.truncated {
+			byteCount--
+			if ln.dropTruncatedLine(lineCount, len(line)) {
+				lineCount--
+				break
+			}
+		}
 		if len(line) > 0 {
-			code := line[0]
-			fields := splitFields(line[1:])
-			switch code {
-			case '~':
-				ln.changeIsolate(fields[0])
-			case '$':
-				scriptID, err := strconv.Atoi(fields[0])
-				if err != nil {
-					return err
-				}
-				ln.addScript(scriptID, fields[1], fields[2])
-			case '!':
-				scriptID, err := strconv.Atoi(fields[0])
-				if err != nil {
-					ln.resetContext()
-				} else {
-					ln.changeScript(scriptID)
-				}
-			case '@':
-				originString, _ := StripQuotes(fields[0])
-				originSecurityToken := "" // If no origin token is provided, assume it's empty (this is possible for cases for chrome internal JS during startup)
-				if len(fields) > 1 {
-					originSecurityToken, _ = StripQuotes(fields[1])
-				}
-				ln.changeOrigin(originString, originSecurityToken)
-			default:
-				for _, agg := range aggs {
-					err := agg.IngestRecord(&ln.World.Context, lineCount, code, fields)
-					if err != nil {
-						return err
-					}
-				}
+			var fields []string
+			var problem string
+			if ln.CheckEscapes {
+				fields, problem = splitFieldsChecked(line[1:])
+			} else {
+				fields = splitFields(line[1:])
+			}
+			err := ln.ingestLine(lineCount, line[0], fields, problem, aggs)
+			if err != nil {
+				return err
 			}
 		}
 	}
 	if scan.Err() != nil {
 		return scan.Err()
 	}
+	ln.finishStream(lineCount, byteCount)
+	return nil
+}
+
+// ingestLine applies one (non-empty, already split) log line to the log state and hands trace records to the aggregators;
+// <problem> describes the line's first invalid escape sequence, if any (only reported when checking escapes)
+func (ln *LogInfo) ingestLine(lineCount int, code byte, fields []string, problem string, aggs []Aggregator) error {
+	ln.Stats.Records[code]++
+	if ln.digest != nil {
+		ln.digestLine(lineCount, code, fields)
+	}
+	if ln.digestOnly {
+		return nil
+	}
+	if ln.CheckEscapes && problem != "" {
+		// (the record is still usable, decoded best-effort)
+		err := ln.tolerate(newAnomaly(lineCount, AnomalyBadEscape, "invalid escape: %s", problem))
+		if err != nil {
+			return err
+		}
+	}
+	isTrace, err := ln.ingestContextRecord(lineCount, code, fields)
+	if err != nil {
+		return err
+	} else if !isTrace {
+		return nil
+	}
+
+	// Parse the record once (only if somebody wants it typed, or if lenient mode must vet it) and hand it to each aggregator
+	var rec *Record
+	if ln.Lenient {
+		rec, err = ParseRecord(lineCount, code, fields)
+		if err != nil {
+			return ln.tolerate(err)
+		}
+	}
+	for _, agg := range aggs {
+		if ragg, ok := agg.(RecordAggregator); ok {
+			if rec == nil {
+				rec, err = ParseRecord(lineCount, code, fields)
+				if err != nil {
+					return err
+				}
+			}
+			err = ragg.IngestTypedRecord(&ln.World.Context, rec)
+		} else {
+			err = agg.IngestRecord(&ln.World.Context, lineCount, code, fields)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// finishStream records the final line/byte counts of a fully ingested log
+func (ln *LogInfo) finishStream(lineCount int, byteCount int64) {
 	ln.Stats.Lines = lineCount
 	ln.Stats.Bytes = byteCount
+	if ln.digest != nil {
+		ln.digestLine(lineCount, 0, nil) // (so trailing blank lines count, too)
+		ln.digest.Sum(ln.Digest[:0])
+		name := trimCompressionSuffix(filepath.Base(ln.RootName)) // (wherever, and however compressed, the log was read from)
+		ln.ID = uuid.NewSHA1(logIDNamespace, append([]byte(name+"\x00"), ln.Digest[:]...))
+	}
+	if ln.digestOnly {
+		return
+	}
 	log.Printf("%d lines (%d bytes) processed\n", ln.Stats.Lines, ln.Stats.Bytes)
-
-	return nil
+	if ln.Lenient {
+		log.Printf("%s: anomalies tolerated: %s\n", ln.RootName, ln.AnomalySummary())
+	}
 }
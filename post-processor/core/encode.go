@@ -0,0 +1,90 @@
+package core
+
+// -------------------------------------------------------------------------------------
+// encoding of log fields (the inverse of splitFields' escape decoding; see "Data Types/Formats" in tests/README.md)
+// -------------------------------------------------------------------------------------
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// EncodeField escapes a (decoded) field value for writing to a log, such that splitFields gives it back exactly:
+// ':' and '\' are '\'-escaped, unprintable ASCII is \xNN-escaped, and everything above ASCII 127 is \uNNNN-escaped
+// (as UTF-16, so non-BMP characters become surrogate pairs); bytes that are not valid UTF-8 pass through unescaped,
+// as splitFields copies such bytes verbatim (and no escape decodes to them)
+func EncodeField(field string) string {
+	var sb strings.Builder
+	sb.Grow(len(field))
+	for i := 0; i < len(field); {
+		r, size := utf8.DecodeRuneInString(field[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			sb.WriteByte(field[i])
+		case r == ':' || r == '\\':
+			sb.WriteByte('\\')
+			sb.WriteRune(r)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&sb, "\\x%02x", r)
+		case r < 0x7f:
+			sb.WriteRune(r)
+		case r > 0xffff:
+			hi, lo := utf16.EncodeRune(r)
+			fmt.Fprintf(&sb, "\\u%04x\\u%04x", hi, lo)
+		default:
+			fmt.Fprintf(&sb, "\\u%04x", r)
+		}
+		i += size
+	}
+	return sb.String()
+}
+
+// EncodeFields escapes and ':'-joins (decoded) field values into the body of a log line (everything after the record code);
+// splitFields gives back the same fields (empty ones included), except that a lone empty field reads back as no fields at all
+func EncodeFields(fields []string) string {
+	encoded := make([]string, len(fields))
+	for i, field := range fields {
+		encoded[i] = EncodeField(field)
+	}
+	return strings.Join(encoded, ":")
+}
+
+// SplitFields expands all escape sequences in the body of a log line (everything after the record code) and splits it into fields
+func SplitFields(line []byte) []string {
+	return splitFields(line)
+}
+
+// SplitRawFields splits the body of a log line on its unescaped ':' separators WITHOUT decoding anything,
+// yielding the same fields as splitFields (but still encoded, exactly as logged)
+func SplitRawFields(line []byte) []string {
+	allFields := make([]string, 0, 8)
+	start := 0
+	skip := 0 // bytes of the current escape sequence still to pass over
+	for i := 0; i < len(line); i++ {
+		if skip > 0 {
+			skip--
+			continue
+		}
+		switch line[i] {
+		case '\\':
+			skip = 1
+			if i+1 < len(line) {
+				if line[i+1] == 'x' {
+					skip = 3
+				} else if line[i+1] == 'u' {
+					skip = 5
+				}
+			}
+		case ':':
+			allFields = append(allFields, string(line[start:i]))
+			start = i + 1
+		}
+	}
+	// (like splitFields: add a last field if there is trailing data, or if we ended on a ':' separator)
+	if len(line) > 0 {
+		allFields = append(allFields, string(line[start:]))
+	}
+	return allFields
+}
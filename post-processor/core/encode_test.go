@@ -0,0 +1,85 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEncodeField(t *testing.T) {
+	tests := []struct {
+		name  string
+		field string
+		want  string
+	}{
+		{"empty", "", ""},
+		{"plain", "hello", "hello"},
+		{"separator", "a:b", `a\:b`},
+		{"backslash", `a\b`, `a\\b`},
+		{"trailing backslash", `a\`, `a\\`},
+		{"control", "a\tb\x7f", `a\x09b\x7f`},
+		{"latin", "caf\u00e9", `caf\u00e9`},
+		{"non-BMP", "\U0001f600", `\ud83d\ude00`},
+		{"invalid UTF-8", "a\xffb", "a\xffb"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := EncodeField(tt.field); got != tt.want {
+				t.Errorf("EncodeField(%q) = %q, want %q", tt.field, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncodeFieldsRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields []string
+		want   []string // (if different from fields)
+	}{
+		{name: "plain", fields: []string{"1234", "Window", "document"}},
+		{name: "separators", fields: []string{"a:b", "::", ":"}},
+		{name: "backslashes", fields: []string{`\`, `\\`, `\x41`, `A`}},
+		{name: "trailing backslash", fields: []string{`a\`, "b"}},
+		{name: "last field trailing backslash", fields: []string{"a", `b\`}},
+		{name: "control", fields: []string{"\x00\x01\n\r\x1f\x7f"}},
+		{name: "BMP", fields: []string{"caf\u00e9", "\u4e2d\u6587", "\uffff"}},
+		{name: "non-BMP", fields: []string{"\U0001f600", "a\U00010000b", "\U0010ffff"}},
+		{name: "invalid UTF-8", fields: []string{"\xff", "a\xc3", "\xed\xa0\x80", "\x80:"}},
+		{name: "empty middle field", fields: []string{"a", "", "b"}},
+		{name: "trailing empty field", fields: []string{"a", ""}},
+		{name: "trailing empty fields", fields: []string{"a", "", ""}},
+		{name: "only empty fields", fields: []string{"", ""}},
+		{name: "no fields", fields: []string{}},
+		// (documented exception: a lone empty field encodes to an empty line body, which reads back as no fields at all)
+		{name: "single empty field", fields: []string{""}, want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := tt.want
+			if want == nil {
+				want = tt.fields
+			}
+			line := EncodeFields(tt.fields)
+			got := splitFields([]byte(line))
+			if len(got) != 0 || len(want) != 0 {
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("splitFields(EncodeFields(%q)) = %q (line %q), want %q", tt.fields, got, line, want)
+				}
+			}
+			if raw := SplitRawFields([]byte(line)); len(raw) != len(got) {
+				t.Errorf("SplitRawFields(%q) gave %d fields, splitFields %d", line, len(raw), len(got))
+			}
+		})
+	}
+}
+
+func TestFormatValueRoundTrip(t *testing.T) {
+	for _, raw := range []string{
+		`"text"`, `""`, "42", "-3.5", "1e+21", "NaN", "-Infinity", "#T", "#F", "#N", "#U",
+		"{Window}", "{17,HTMLDivElement}", "%alert", "myFunc", "<anonymous>", "/a+b/", "?",
+	} {
+		if got := FormatValue(ParseValue(raw)); got != raw {
+			t.Errorf("FormatValue(ParseValue(%q)) = %q", raw, got)
+		}
+	}
+}
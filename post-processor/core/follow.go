@@ -0,0 +1,129 @@
+package core
+
+// -------------------------------------------------------------------------------------
+// tailing live logs (still being written by Chrome) segment by segment
+// -------------------------------------------------------------------------------------
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// FollowReader tails a growing log segment (and the segments succeeding it, as they appear), handing out only
+// complete lines until following stops (on idle timeout or a Stop signal), when any partial final line is flushed, too
+type FollowReader struct {
+	// Successor names the segment expected to follow a given segment file ("" if none can)
+	Successor func(name string) string
+
+	// Stop ends following (once the data already written has been read) when closed
+	Stop <-chan struct{}
+
+	// IdleTimeout ends following after this long without new data
+	IdleTimeout time.Duration
+
+	// PollInterval is how long to wait between checks for new data
+	PollInterval time.Duration
+
+	// Checkpoint (if set) is called every CheckpointEvery from within Read, i.e., while the consumer is waiting on
+	// input--so a line-by-line consumer (like IngestStream) has fully processed every line handed out before it
+	Checkpoint      func() error
+	CheckpointEvery time.Duration
+
+	name           string   // segment currently being read
+	file           *os.File // (open handle on the above)
+	pending        []byte   // data read but not handed out yet (a partial trailing line, at least)
+	buf            []byte
+	done           bool
+	lastData       time.Time
+	lastCheckpoint time.Time
+}
+
+// NewFollowReader starts tailing the log segment file <name> (which must already exist)
+func NewFollowReader(name string) (*FollowReader, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &FollowReader{
+		Successor:      func(string) string { return "" },
+		IdleTimeout:    30 * time.Second,
+		PollInterval:   250 * time.Millisecond,
+		name:           name,
+		file:           file,
+		buf:            make([]byte, 64*1024),
+		lastData:       now,
+		lastCheckpoint: now,
+	}, nil
+}
+
+func (fr *FollowReader) Read(p []byte) (int, error) {
+	for {
+		if fr.Checkpoint != nil && time.Since(fr.lastCheckpoint) >= fr.CheckpointEvery {
+			err := fr.Checkpoint()
+			if err != nil {
+				return 0, err
+			}
+			fr.lastCheckpoint = time.Now()
+		}
+
+		// Hand out complete lines first (and, once we are done following, whatever is left)
+		end := bytes.LastIndexByte(fr.pending, '\n') + 1
+		if fr.done {
+			end = len(fr.pending)
+		}
+		if end > 0 {
+			n := copy(p, fr.pending[:end])
+			fr.pending = fr.pending[n:]
+			return n, nil
+		} else if fr.done {
+			return 0, io.EOF
+		}
+
+		n, err := fr.file.Read(fr.buf)
+		if n > 0 {
+			fr.pending = append(fr.pending, fr.buf[:n]...)
+			fr.lastData = time.Now()
+			continue
+		} else if err != nil && err != io.EOF {
+			return 0, err
+		}
+
+		// Caught up: move on to the next segment once it shows up (after draining this one, in case of a last-moment write)
+		if next := fr.Successor(fr.name); next != "" {
+			if nextFile, err := os.Open(next); err == nil {
+				rest, err := io.ReadAll(fr.file)
+				fr.file.Close()
+				if err != nil {
+					nextFile.Close()
+					return 0, err
+				}
+				fr.pending = append(fr.pending, rest...)
+				log.Printf("follow: %s -> %s\n", fr.name, next)
+				fr.name, fr.file = next, nextFile
+				fr.lastData = time.Now()
+				continue
+			}
+		}
+
+		// ...or wait for more data
+		select {
+		case <-fr.Stop:
+			log.Printf("follow: stopped at %s\n", fr.name)
+			fr.done = true
+		case <-time.After(fr.PollInterval):
+			if time.Since(fr.lastData) >= fr.IdleTimeout {
+				log.Printf("follow: no new data in %s for %v; stopping\n", fr.name, fr.IdleTimeout)
+				fr.done = true
+			}
+		}
+	}
+}
+
+// Close releases the segment currently open
+func (fr *FollowReader) Close() error {
+	return fr.file.Close()
+}
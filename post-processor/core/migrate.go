@@ -0,0 +1,184 @@
+package core
+
+// -------------------------------------------------------------------------------------
+// PostgreSQL schema migrations: numbered DDL scripts (migrations/NNNN_name.sql) built into the binary,
+// applied in order (each in its own transaction) and recorded in the schema_migrations table
+// -------------------------------------------------------------------------------------
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Migration is one numbered schema change
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// migrationNamePattern matches migration file names (version, name)
+var migrationNamePattern = regexp.MustCompile(`^(\d{4})_(\w+)\.sql$`)
+
+// migrations holds the embedded migrations, in version order (numbered 1, 2, 3, ... without gaps)
+var migrations = mustLoadMigrations()
+
+func mustLoadMigrations() []Migration {
+	names, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		panic(err)
+	}
+	var list []Migration
+	for _, entry := range names {
+		match := migrationNamePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			panic(fmt.Sprintf("misnamed migration file '%s'", entry.Name()))
+		}
+		version, _ := strconv.Atoi(match[1])
+		ddl, err := migrationFiles.ReadFile(path.Join("migrations", entry.Name()))
+		if err != nil {
+			panic(err)
+		}
+		list = append(list, Migration{Version: version, Name: match[2], SQL: string(ddl)})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	for i, m := range list {
+		if m.Version != i+1 {
+			panic(fmt.Sprintf("migration %04d_%s is out of sequence", m.Version, m.Name))
+		}
+	}
+	return list
+}
+
+// SchemaVersion is the schema version this code writes to (that of the latest migration)
+func SchemaVersion() int {
+	return len(migrations)
+}
+
+// migrationTableSchema records which migrations have been applied
+const migrationTableSchema = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+	version INT PRIMARY KEY NOT NULL,
+	name TEXT NOT NULL,
+	applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+`
+
+// MigrateUp applies every migration not yet recorded in schema_migrations (returning how many it applied)
+//
+// Each migration runs in its own transaction, holding a lock on schema_migrations, so concurrent runs take turns.
+func MigrateUp(sqlDb *sql.DB) (int, error) {
+	if _, err := sqlDb.Exec(migrationTableSchema); err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	for _, m := range migrations {
+		done, err := applyMigration(sqlDb, m)
+		if err != nil {
+			return applied, fmt.Errorf("migration %04d_%s: %w", m.Version, m.Name, err)
+		}
+		if done {
+			log.Printf("migrate: applied %04d_%s\n", m.Version, m.Name)
+			applied++
+		}
+	}
+	return applied, nil
+}
+
+// applyMigration applies one migration (unless already applied), reporting whether it did
+func applyMigration(sqlDb *sql.DB, m Migration) (bool, error) {
+	txn, err := sqlDb.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer txn.Rollback() // (no-op once committed)
+
+	if _, err = txn.Exec(`LOCK TABLE schema_migrations IN SHARE ROW EXCLUSIVE MODE;`); err != nil {
+		return false, err
+	}
+	var present bool
+	err = txn.QueryRow(`SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)`, m.Version).Scan(&present)
+	if err != nil || present {
+		return false, err
+	}
+	if _, err = txn.Exec(m.SQL); err != nil {
+		return false, err
+	}
+	if _, err = txn.Exec(`INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, m.Version, m.Name); err != nil {
+		return false, err
+	}
+	return true, txn.Commit()
+}
+
+// MigrationStatus tells whether (and when) a migration was applied
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time // nil if pending
+	Unknown   bool       // applied, but not embedded here (i.e., made by a newer build)
+}
+
+// GetMigrationStatus lists every migration embedded here or recorded in schema_migrations, in version order
+func GetMigrationStatus(sqlDb *sql.DB) ([]MigrationStatus, error) {
+	statuses := make([]MigrationStatus, len(migrations))
+	for i, m := range migrations {
+		statuses[i].Migration = m
+	}
+
+	rows, err := sqlDb.Query(`SELECT version, name, applied_at FROM schema_migrations ORDER BY version`)
+	if isUndefinedTable(err) {
+		return statuses, nil // (nothing applied yet)
+	} else if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var m Migration
+		var appliedAt time.Time
+		if err = rows.Scan(&m.Version, &m.Name, &appliedAt); err != nil {
+			return nil, err
+		}
+		if m.Version >= 1 && m.Version <= len(statuses) {
+			statuses[m.Version-1].AppliedAt = &appliedAt
+		} else {
+			statuses = append(statuses, MigrationStatus{Migration: m, AppliedAt: &appliedAt, Unknown: true})
+		}
+	}
+	return statuses, rows.Err()
+}
+
+// CheckSchemaVersion makes sure the database has (at least) the schema version this code writes to
+func CheckSchemaVersion(sqlDb *sql.DB) error {
+	var version int
+	err := sqlDb.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	if isUndefinedTable(err) {
+		return fmt.Errorf("PostgreSQL schema is not versioned (need version %d; run with '-migrate up')", SchemaVersion())
+	} else if err != nil {
+		return err
+	}
+	if version < SchemaVersion() {
+		return fmt.Errorf("PostgreSQL schema is at version %d (need version %d; run with '-migrate up')", version, SchemaVersion())
+	} else if version > SchemaVersion() {
+		log.Printf("WARNING: PostgreSQL schema is at version %d, newer than this build's %d\n", version, SchemaVersion())
+	}
+	return nil
+}
+
+// isUndefinedTable tells whether a PG error is "relation does not exist"
+func isUndefinedTable(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "42P01"
+}
@@ -1,3 +1,4 @@
+-- Baseline: the schema as it stood before migrations were versioned (safe to apply over a hand-made copy of it)
 -- WARNING: this schema depends on the `urls` tables from the VPC Postgres schema being available!
 CREATE TABLE IF NOT EXISTS urls_import_schema (
     id SERIAL PRIMARY KEY NOT NULL,
@@ -214,6 +215,11 @@ CREATE TABLE IF NOT EXISTS script_creation (
 	eval_parent_hash BYTEA
 );
 
+-- UPGRADES (of script_creation tables made before these columns were added): V8's runtime_id/isolate_ptr, and the active origin
+ALTER TABLE IF EXISTS script_creation ADD COLUMN IF NOT EXISTS isolate_ptr TEXT;
+ALTER TABLE IF EXISTS script_creation ADD COLUMN IF NOT EXISTS runtime_id INT;
+ALTER TABLE IF EXISTS script_creation ADD COLUMN IF NOT EXISTS first_origin TEXT;
+
 -- Feature usage information (for polymorphic callsites)
 CREATE TABLE IF NOT EXISTS poly_feature_usage (
 	id SERIAL PRIMARY KEY NOT NULL,
@@ -228,14 +234,17 @@ CREATE TABLE IF NOT EXISTS poly_feature_usage (
 );
 
 -- Script causality/provenance enum type
-CREATE TYPE script_genesis AS ENUM (
-	'unknown',         -- No pattern (or multiple ambiguous patterns) match genesis data
-	'static',          -- No parent, URL provided (appears to be loaded in document HTML [of some frame])
-	'eval',            -- Eval-parent (redundant/overlap with script_creation, but that's life)
-	'include',         -- Direct HTMLScriptElement.src manipulation matches subsequent URL-load of script
-	'insert',          -- Direct HTMLScriptElement.text(et al.) manipulation matches SHA256 of subsequent non-URL-load of script
-	'write_include',   -- Document.write-injected <script src="..." /> that matches subsequent URL-load of script
-	'write_insert');   -- Document.write-injected <script>...</script> that matches SHA256 of subsequent non-URL-load of script
+DO $$ BEGIN
+	CREATE TYPE script_genesis AS ENUM (
+		'unknown',         -- No pattern (or multiple ambiguous patterns) match genesis data
+		'static',          -- No parent, URL provided (appears to be loaded in document HTML [of some frame])
+		'eval',            -- Eval-parent (redundant/overlap with script_creation, but that's life)
+		'include',         -- Direct HTMLScriptElement.src manipulation matches subsequent URL-load of script
+		'insert',          -- Direct HTMLScriptElement.text(et al.) manipulation matches SHA256 of subsequent non-URL-load of script
+		'write_include',   -- Document.write-injected <script src="..." /> that matches subsequent URL-load of script
+		'write_insert');   -- Document.write-injected <script>...</script> that matches SHA256 of subsequent non-URL-load of script
+EXCEPTION WHEN duplicate_object THEN NULL;
+END $$;
 
 -- Script causality/provenance link data
 CREATE TABLE IF NOT EXISTS script_causality (
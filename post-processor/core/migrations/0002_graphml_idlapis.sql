@@ -0,0 +1,17 @@
+-- Tables written by aggregators but missing from the baseline
+
+-- Per-log GraphML document of script causality (see the `causality_graphml` pass)
+CREATE TABLE IF NOT EXISTS script_causality_graphml (
+	id TEXT PRIMARY KEY NOT NULL,					-- Random UUID for this document
+	logfile_id INT REFERENCES logfile (id) NOT NULL,
+	xml TEXT NOT NULL
+);
+
+-- APIs used that the IDL data does not define, with the scripts using each (see the `idlapis` aggregator)
+CREATE TABLE IF NOT EXISTS idlapis (
+	id SERIAL PRIMARY KEY NOT NULL,
+	api TEXT NOT NULL,					-- Full API name (e.g., "Window.foo")
+	script_list TEXT[] NOT NULL,		-- One "<op> <offset> <script-url> <origin>" entry per use
+	log_file_id INT REFERENCES logfile (id) NOT NULL,
+	root_domain TEXT NOT NULL
+);
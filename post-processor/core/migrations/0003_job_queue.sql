@@ -0,0 +1,19 @@
+-- Queue of post-processing jobs, claimed (FOR UPDATE SKIP LOCKED) by `-worker` processes
+CREATE TABLE IF NOT EXISTS postprocess_jobs (
+	id SERIAL PRIMARY KEY NOT NULL,
+	log_path TEXT NOT NULL,					-- Input to process (log file, archive, directory, or @OID)
+	submissionid TEXT,						-- Submission ID to associate with the log (if any)
+	root_domain TEXT,						-- Root domain to associate with the log (if any)
+	aggs TEXT NOT NULL,						-- '+'-delimited aggregation passes to perform
+	status TEXT NOT NULL DEFAULT 'pending',	-- 'pending', 'running', 'done', or 'failed' (for good)
+	attempts INT NOT NULL DEFAULT 0,		-- Number of times claimed so far
+	max_attempts INT NOT NULL DEFAULT 3,	-- Give up (status 'failed') after this many failed attempts
+	not_before TIMESTAMPTZ NOT NULL DEFAULT now(),	-- Not claimable until then (retry backoff)
+	claimed_by TEXT,						-- Worker currently (or last) running the job
+	heartbeat_at TIMESTAMPTZ,				-- Last sign of life from that worker (stale claims are requeued)
+	started_at TIMESTAMPTZ,					-- Start of the latest attempt
+	finished_at TIMESTAMPTZ,				-- End of the latest attempt
+	error TEXT,								-- Error text of the latest failed attempt
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS postprocess_jobs_pending ON postprocess_jobs (not_before) WHERE status = 'pending';
@@ -0,0 +1,15 @@
+-- Per-log summary statistics (record/isolate/script/origin counts; see the `stats` aggregator)
+CREATE TABLE IF NOT EXISTS log_stats (
+	id SERIAL PRIMARY KEY NOT NULL,
+	logfile_id INT REFERENCES logfile (id) NOT NULL,
+	records JSONB NOT NULL,			-- Record count by op code (e.g., {"c": 1234, "$": 56, ...})
+	isolates INT NOT NULL,			-- Isolates seen
+	scripts INT NOT NULL,			-- Scripts loaded (all isolates)
+	url_scripts INT NOT NULL,		-- ...of which loaded from a URL
+	eval_scripts INT NOT NULL,		-- ...of which eval'd by another script
+	visiblev8_scripts INT NOT NULL,	-- ...of which VisibleV8/puppeteer-injected
+	script_bytes BIGINT NOT NULL,	-- Total size of all script source
+	origins INT NOT NULL,			-- Distinct security origins seen
+	scripts_per_isolate JSONB NOT NULL,	-- Script count by isolate ID
+	top_scripts JSONB NOT NULL		-- Busiest scripts by trace-record count ([{"script_hash": ..., "url": ..., "records": ...}, ...])
+);
@@ -0,0 +1,30 @@
+-- Re-importing logs: every per-log table gets a (indexed) logfile_id, so a log's rows can be found and replaced,
+-- and logfile rows record when every aggregator's rows were in
+
+-- Set once a log's import finished (NULL for imports cut short, and for logs imported before this migration)
+ALTER TABLE logfile ADD COLUMN IF NOT EXISTS finished_at TIMESTAMPTZ;
+
+-- (NULL for rows imported before this migration)
+ALTER TABLE script_flow ADD COLUMN IF NOT EXISTS logfile_id INT REFERENCES logfile (id);
+ALTER TABLE adblock ADD COLUMN IF NOT EXISTS logfile_id INT REFERENCES logfile (id);
+ALTER TABLE thirdpartyfirstparty ADD COLUMN IF NOT EXISTS logfile_id INT REFERENCES logfile (id);
+ALTER TABLE multi_origin_obj ADD COLUMN IF NOT EXISTS logfile_id INT REFERENCES logfile (id);
+ALTER TABLE multi_origin_api_names ADD COLUMN IF NOT EXISTS logfile_id INT REFERENCES logfile (id);
+ALTER TABLE script_blobs ADD COLUMN IF NOT EXISTS logfile_id INT REFERENCES logfile (id);
+
+CREATE INDEX IF NOT EXISTS mega_instances_logfile_id ON mega_instances (logfile_id);
+CREATE INDEX IF NOT EXISTS script_blobs_logfile_id ON script_blobs (logfile_id);
+CREATE INDEX IF NOT EXISTS adblock_logfile_id ON adblock (logfile_id);
+CREATE INDEX IF NOT EXISTS thirdpartyfirstparty_logfile_id ON thirdpartyfirstparty (logfile_id);
+CREATE INDEX IF NOT EXISTS js_api_features_summary_logfile_id ON js_api_features_summary (logfile_id);
+CREATE INDEX IF NOT EXISTS script_flow_logfile_id ON script_flow (logfile_id);
+CREATE INDEX IF NOT EXISTS feature_usage_logfile_id ON feature_usage (logfile_id);
+CREATE INDEX IF NOT EXISTS multi_origin_obj_logfile_id ON multi_origin_obj (logfile_id);
+CREATE INDEX IF NOT EXISTS multi_origin_api_names_logfile_id ON multi_origin_api_names (logfile_id);
+CREATE INDEX IF NOT EXISTS script_creation_logfile_id ON script_creation (logfile_id);
+CREATE INDEX IF NOT EXISTS poly_feature_usage_logfile_id ON poly_feature_usage (logfile_id);
+CREATE INDEX IF NOT EXISTS script_causality_logfile_id ON script_causality (logfile_id);
+CREATE INDEX IF NOT EXISTS create_elements_logfile_id ON create_elements (logfile_id);
+CREATE INDEX IF NOT EXISTS log_stats_logfile_id ON log_stats (logfile_id);
+CREATE INDEX IF NOT EXISTS script_causality_graphml_logfile_id ON script_causality_graphml (logfile_id);
+CREATE INDEX IF NOT EXISTS idlapis_log_file_id ON idlapis (log_file_id);
@@ -8,9 +8,14 @@ package core
 import (
 	"context"
 	"fmt"
+	"io"
+	"net/url"
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
@@ -21,7 +26,16 @@ func getDialURL() string {
 	db := GetEnvDefault("MONGODB_AUTHDB", "admin")
 	user := GetEnvDefault("MONGODB_USER", "")
 	pass := GetEnvDefault("MONGODB_PASS", "")
-	return fmt.Sprintf("mongodb://%s:%s@%s:%s/%s", user, pass, host, port, db)
+	if user == "" {
+		// No credentials (e.g., a local test mongod)
+		return fmt.Sprintf("mongodb://%s:%s/%s", host, port, db)
+	}
+	return fmt.Sprintf("mongodb://%s@%s:%s/%s", url.UserPassword(user, pass), host, port, db)
+}
+
+// GetLogDatabaseName names the Mongo database whose (default "fs") GridFS bucket holds vv8log blobs
+func GetLogDatabaseName() string {
+	return GetEnvDefault("MONGODB_LOGDB", "admin")
 }
 
 // MongoConnection bundles together essential state and stats for a live MongoDB connection
@@ -57,3 +71,46 @@ func DialMongo() (MongoConnection, error) {
 	conn.Client = client
 	return conn, nil
 }
+
+// GridFSLogFile looks up the GridFS file entry (name, upload metadata, etc.) of a vv8log blob
+func GridFSLogFile(db *mongo.Database, oid primitive.ObjectID) (*gridfs.File, error) {
+	bucket, err := gridfs.NewBucket(db)
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := bucket.Find(bson.M{"_id": oid})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+	if !cursor.Next(context.Background()) {
+		if cursor.Err() != nil {
+			return nil, cursor.Err()
+		}
+		return nil, fmt.Errorf("no vv8log with OID %s in GridFS bucket %s.fs", oid.Hex(), db.Name())
+	}
+	var file gridfs.File
+	err = cursor.Decode(&file)
+	if err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// OpenGridFSLog streams a (possibly compressed) vv8log blob out of GridFS, chunk by chunk, returning its file name too
+func OpenGridFSLog(db *mongo.Database, oid primitive.ObjectID) (io.ReadCloser, string, error) {
+	bucket, err := gridfs.NewBucket(db)
+	if err != nil {
+		return nil, "", err
+	}
+	stream, err := bucket.OpenDownloadStream(oid)
+	if err != nil {
+		return nil, "", fmt.Errorf("vv8log OID %s: %w", oid.Hex(), err)
+	}
+	name := stream.GetFile().Name
+	reader, err := NewDecompressingReader(stream)
+	if err != nil {
+		return nil, "", fmt.Errorf("vv8log OID %s (%s): %w", oid.Hex(), name, err)
+	}
+	return reader, name, nil
+}
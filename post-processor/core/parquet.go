@@ -0,0 +1,452 @@
+package core
+
+// -------------------------------------------------------------------------------------
+// Apache Parquet output (one file per table per run; see https://github.com/apache/parquet-format):
+// flat schemas, PLAIN-encoded values, RLE definition levels, Snappy-compressed v1 data pages, Thrift-compact footers
+// -------------------------------------------------------------------------------------
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/klauspost/compress/snappy"
+)
+
+// parquetRowGroupSize is how many rows are buffered (per table) before being written out as a row group
+const parquetRowGroupSize = 64 * 1024
+
+// parquetMagic brackets every Parquet file
+var parquetMagic = []byte("PAR1")
+
+// Parquet format enum values used here
+const (
+	parquetBoolean   = 0 // Type
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetRequired = 0 // FieldRepetitionType
+	parquetOptional = 1
+
+	parquetUTF8 = 0 // ConvertedType
+
+	parquetPlain = 0 // Encoding
+	parquetRLE   = 3
+
+	parquetSnappy = 1 // CompressionCodec
+
+	parquetDataPage = 0 // PageType
+)
+
+// parquetTypes maps our column types to Parquet physical types
+var parquetTypes = map[ColumnType]int32{
+	StringColumn:  parquetByteArray,
+	BytesColumn:   parquetByteArray,
+	Int64Column:   parquetInt64,
+	Float64Column: parquetDouble,
+	BoolColumn:    parquetBoolean,
+}
+
+// ParquetSink is a TableSink writing <dir>/<table>.parquet files (call Close once all rows are in, to finish them)
+type ParquetSink struct {
+	dir   string
+	lock  sync.Mutex
+	files map[string]*parquetFile
+}
+
+// NewParquetSink creates a ParquetSink writing into an (existing) directory
+func NewParquetSink(dir string) *ParquetSink {
+	return &ParquetSink{
+		dir:   dir,
+		files: make(map[string]*parquetFile),
+	}
+}
+
+// WriteRow buffers a row for its table (creating the table's file on its first row)
+func (ps *ParquetSink) WriteRow(table *Table, row ...interface{}) error {
+	if err := table.checkRow(row); err != nil {
+		return err
+	}
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+	pf, ok := ps.files[table.Name]
+	if !ok {
+		var err error
+		pf, err = newParquetFile(filepath.Join(ps.dir, table.Name+".parquet"), table)
+		if err != nil {
+			return err
+		}
+		ps.files[table.Name] = pf
+	} else if pf.table != table {
+		return fmt.Errorf("%s: table declared twice", table.Name)
+	}
+	return pf.appendRow(row)
+}
+
+// Close finishes every file written (returning the first error encountered, if any)
+func (ps *ParquetSink) Close() error {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+	names := make([]string, 0, len(ps.files))
+	for name := range ps.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var firstErr error
+	for _, name := range names {
+		pf := ps.files[name]
+		err := pf.close()
+		if err == nil {
+			log.Printf("parquet: wrote %d rows to %s\n", pf.numRows, pf.file.Name())
+		} else if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// parquetColumn buffers one column's values for the current row group
+type parquetColumn struct {
+	values  bytes.Buffer // PLAIN-encoded non-null values (except booleans)
+	bools   []bool       // non-null boolean values (bit-packed on flush)
+	defined []bool       // per-row definition flags (nullable columns only)
+	count   int          // rows buffered
+}
+
+// parquetFile is a Parquet file under construction
+type parquetFile struct {
+	table     *Table
+	file      *os.File
+	offset    int64
+	columns   []parquetColumn
+	rowGroups []thriftStruct
+	numRows   int64
+}
+
+func newParquetFile(name string, table *Table) (*parquetFile, error) {
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, err
+	}
+	pf := &parquetFile{
+		table:   table,
+		file:    file,
+		columns: make([]parquetColumn, len(table.Columns)),
+	}
+	if err = pf.write(parquetMagic); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return pf, nil
+}
+
+func (pf *parquetFile) write(data []byte) error {
+	n, err := pf.file.Write(data)
+	pf.offset += int64(n)
+	return err
+}
+
+func (pf *parquetFile) appendRow(row []interface{}) error {
+	var scratch [8]byte
+	for i, value := range row {
+		col := &pf.columns[i]
+		col.count++
+		if pf.table.Columns[i].Nullable {
+			col.defined = append(col.defined, value != nil)
+		}
+		switch v := value.(type) {
+		case string:
+			binary.LittleEndian.PutUint32(scratch[:4], uint32(len(v)))
+			col.values.Write(scratch[:4])
+			col.values.WriteString(v)
+		case []byte:
+			binary.LittleEndian.PutUint32(scratch[:4], uint32(len(v)))
+			col.values.Write(scratch[:4])
+			col.values.Write(v)
+		case int:
+			binary.LittleEndian.PutUint64(scratch[:], uint64(v))
+			col.values.Write(scratch[:])
+		case int64:
+			binary.LittleEndian.PutUint64(scratch[:], uint64(v))
+			col.values.Write(scratch[:])
+		case float64:
+			binary.LittleEndian.PutUint64(scratch[:], math.Float64bits(v))
+			col.values.Write(scratch[:])
+		case bool:
+			col.bools = append(col.bools, v)
+		}
+	}
+	if pf.columns[0].count >= parquetRowGroupSize {
+		return pf.flushRowGroup()
+	}
+	return nil
+}
+
+// rleBitmap encodes 0/1 levels with the RLE/bit-packing hybrid encoding (bit width 1, RLE runs only)
+func rleBitmap(levels []bool) []byte {
+	var buf bytes.Buffer
+	var scratch [binary.MaxVarintLen64]byte
+	for i := 0; i < len(levels); {
+		j := i
+		for j < len(levels) && levels[j] == levels[i] {
+			j++
+		}
+		buf.Write(scratch[:binary.PutUvarint(scratch[:], uint64(j-i)<<1)])
+		if levels[i] {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+		i = j
+	}
+	return buf.Bytes()
+}
+
+// flushRowGroup writes the buffered rows as a row group (one data page per column)
+func (pf *parquetFile) flushRowGroup() error {
+	numRows := int64(pf.columns[0].count)
+	if numRows == 0 {
+		return nil
+	}
+	var chunks []thriftStruct
+	var groupBytes int64
+	for i := range pf.columns {
+		col := &pf.columns[i]
+		spec := pf.table.Columns[i]
+
+		var page bytes.Buffer
+		if spec.Nullable {
+			levels := rleBitmap(col.defined)
+			var length [4]byte
+			binary.LittleEndian.PutUint32(length[:], uint32(len(levels)))
+			page.Write(length[:])
+			page.Write(levels)
+		}
+		if spec.Type == BoolColumn {
+			packed := make([]byte, (len(col.bools)+7)/8)
+			for j, b := range col.bools {
+				if b {
+					packed[j/8] |= 1 << (j % 8)
+				}
+			}
+			page.Write(packed)
+		} else {
+			page.Write(col.values.Bytes())
+		}
+		compressed := snappy.Encode(nil, page.Bytes())
+
+		header, err := thriftStruct{
+			{1, int32(parquetDataPage)},
+			{2, int32(page.Len())},
+			{3, int32(len(compressed))},
+			{5, thriftStruct{
+				{1, int32(numRows)},
+				{2, int32(parquetPlain)},
+				{3, int32(parquetRLE)},
+				{4, int32(parquetRLE)},
+			}},
+		}.encode()
+		if err != nil {
+			return fmt.Errorf("%s: page header: %w", spec.Name, err)
+		}
+
+		pageOffset := pf.offset
+		if err := pf.write(header); err != nil {
+			return err
+		}
+		if err := pf.write(compressed); err != nil {
+			return err
+		}
+		uncompressedSize := int64(len(header) + page.Len())
+		compressedSize := int64(len(header) + len(compressed))
+		groupBytes += uncompressedSize
+		chunks = append(chunks, thriftStruct{
+			{2, pageOffset},
+			{3, thriftStruct{
+				{1, parquetTypes[spec.Type]},
+				{2, []int32{parquetPlain, parquetRLE}},
+				{3, []string{spec.Name}},
+				{4, int32(parquetSnappy)},
+				{5, numRows},
+				{6, uncompressedSize},
+				{7, compressedSize},
+				{9, pageOffset},
+			}},
+		})
+		*col = parquetColumn{}
+	}
+	pf.rowGroups = append(pf.rowGroups, thriftStruct{
+		{1, chunks},
+		{2, groupBytes},
+		{3, numRows},
+	})
+	pf.numRows += numRows
+	return nil
+}
+
+// close writes out any remaining rows and the footer
+func (pf *parquetFile) close() error {
+	err := pf.flushRowGroup()
+	if err != nil {
+		pf.file.Close()
+		return err
+	}
+
+	schema := []thriftStruct{{
+		{4, "schema"},
+		{5, int32(len(pf.table.Columns))},
+	}}
+	for _, col := range pf.table.Columns {
+		elem := thriftStruct{{1, parquetTypes[col.Type]}}
+		if col.Nullable {
+			elem = append(elem, thriftField{3, int32(parquetOptional)})
+		} else {
+			elem = append(elem, thriftField{3, int32(parquetRequired)})
+		}
+		elem = append(elem, thriftField{4, col.Name})
+		if col.Type == StringColumn {
+			elem = append(elem, thriftField{6, int32(parquetUTF8)})
+		}
+		schema = append(schema, elem)
+	}
+	footer, err := thriftStruct{
+		{1, int32(1)},
+		{2, schema},
+		{3, pf.numRows},
+		{4, pf.rowGroups},
+		{6, "vv8-postprocessor"},
+	}.encode()
+	if err != nil {
+		pf.file.Close()
+		return fmt.Errorf("%s: footer: %w", pf.table.Name, err)
+	}
+
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(len(footer)))
+	for _, data := range [][]byte{footer, length[:], parquetMagic} {
+		if err = pf.write(data); err != nil {
+			pf.file.Close()
+			return err
+		}
+	}
+	return pf.file.Close()
+}
+
+// -------------------------------------------------------------------------------------
+// just enough of the Thrift compact protocol to write Parquet metadata
+// -------------------------------------------------------------------------------------
+
+// thriftField is a (field ID, value) pair; values are int32, int64, string, thriftStruct, or lists thereof
+type thriftField struct {
+	id    int16
+	value interface{}
+}
+
+// thriftStruct is a Thrift struct, as its fields (in increasing ID order)
+type thriftStruct []thriftField
+
+// Thrift compact protocol type codes
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStrct  = 12
+)
+
+func (ts thriftStruct) encode() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := ts.encodeTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func thriftVarint(buf *bytes.Buffer, v int64) {
+	var scratch [binary.MaxVarintLen64]byte
+	buf.Write(scratch[:binary.PutVarint(scratch[:], v)]) // (zig-zag, as Thrift compact wants)
+}
+
+func thriftString(buf *bytes.Buffer, s string) {
+	var scratch [binary.MaxVarintLen64]byte
+	buf.Write(scratch[:binary.PutUvarint(scratch[:], uint64(len(s)))])
+	buf.WriteString(s)
+}
+
+func thriftListHeader(buf *bytes.Buffer, elemType byte, size int) {
+	if size < 15 {
+		buf.WriteByte(byte(size<<4) | elemType)
+	} else {
+		var scratch [binary.MaxVarintLen64]byte
+		buf.WriteByte(0xf0 | elemType)
+		buf.Write(scratch[:binary.PutUvarint(scratch[:], uint64(size))])
+	}
+}
+
+func (ts thriftStruct) encodeTo(buf *bytes.Buffer) error {
+	var lastID int16
+	for _, field := range ts {
+		var typ byte
+		switch field.value.(type) {
+		case int32:
+			typ = thriftI32
+		case int64:
+			typ = thriftI64
+		case string:
+			typ = thriftBinary
+		case thriftStruct:
+			typ = thriftStrct
+		case []int32, []string, []thriftStruct:
+			typ = thriftList
+		default:
+			return fmt.Errorf("thrift: field %d: unsupported value %T", field.id, field.value)
+		}
+		if delta := field.id - lastID; delta > 0 && delta <= 15 {
+			buf.WriteByte(byte(delta<<4) | typ)
+		} else {
+			buf.WriteByte(typ)
+			thriftVarint(buf, int64(field.id))
+		}
+		lastID = field.id
+
+		switch v := field.value.(type) {
+		case int32:
+			thriftVarint(buf, int64(v))
+		case int64:
+			thriftVarint(buf, v)
+		case string:
+			thriftString(buf, v)
+		case thriftStruct:
+			if err := v.encodeTo(buf); err != nil {
+				return err
+			}
+		case []int32:
+			thriftListHeader(buf, thriftI32, len(v))
+			for _, e := range v {
+				thriftVarint(buf, int64(e))
+			}
+		case []string:
+			thriftListHeader(buf, thriftBinary, len(v))
+			for _, e := range v {
+				thriftString(buf, e)
+			}
+		case []thriftStruct:
+			thriftListHeader(buf, thriftStrct, len(v))
+			for _, e := range v {
+				if err := e.encodeTo(buf); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	buf.WriteByte(0) // (stop)
+	return nil
+}
@@ -0,0 +1,340 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/source"
+)
+
+// readThrift decodes a Thrift compact-protocol struct into a map of field ID to value (int64, []byte, []interface{},
+// or a nested map), enough to check the metadata ParquetSink writes
+func readThrift(r *bytes.Reader) (map[int16]interface{}, error) {
+	fields := make(map[int16]interface{})
+	var lastID int16
+	for {
+		header, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		} else if header == 0 {
+			return fields, nil
+		}
+		id := lastID + int16(header>>4)
+		if header>>4 == 0 {
+			n, err := binary.ReadVarint(r)
+			if err != nil {
+				return nil, err
+			}
+			id = int16(n)
+		}
+		lastID = id
+		if fields[id], err = readThriftValue(r, header&0x0f); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func readThriftValue(r *bytes.Reader, typ byte) (interface{}, error) {
+	switch typ {
+	case thriftI32, thriftI64:
+		return binary.ReadVarint(r)
+	case thriftBinary:
+		length, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		data := make([]byte, length)
+		_, err = io.ReadFull(r, data)
+		return data, err
+	case thriftStrct:
+		return readThrift(r)
+	case thriftList:
+		header, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		size := uint64(header >> 4)
+		if size == 15 {
+			if size, err = binary.ReadUvarint(r); err != nil {
+				return nil, err
+			}
+		}
+		list := make([]interface{}, size)
+		for i := range list {
+			if list[i], err = readThriftValue(r, header&0x0f); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	}
+	return nil, fmt.Errorf("unexpected thrift type %d", typ)
+}
+
+// readParquetColumn decodes one column chunk's (single, PLAIN-encoded) data page into a value per row (nil for nulls)
+func readParquetColumn(t *testing.T, file []byte, chunk map[int16]interface{}, column Column, numRows int) []interface{} {
+	meta := chunk[3].(map[int16]interface{})
+	r := bytes.NewReader(file[meta[9].(int64):])
+	header, err := readThrift(r)
+	if err != nil {
+		t.Fatalf("%s: page header: %v", column.Name, err)
+	}
+	compressed := make([]byte, header[3].(int64))
+	io.ReadFull(r, compressed)
+	page, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		t.Fatalf("%s: page: %v", column.Name, err)
+	} else if int64(len(page)) != header[2].(int64) {
+		t.Fatalf("%s: page is %d bytes, header says %d", column.Name, len(page), header[2])
+	}
+
+	defined := make([]bool, 0, numRows)
+	if column.Nullable {
+		length := binary.LittleEndian.Uint32(page)
+		levels := bytes.NewReader(page[4 : 4+length])
+		page = page[4+length:]
+		for levels.Len() > 0 {
+			run, _ := binary.ReadUvarint(levels)
+			level, _ := levels.ReadByte()
+			for i := uint64(0); i < run>>1; i++ {
+				defined = append(defined, level == 1)
+			}
+		}
+	} else {
+		for i := 0; i < numRows; i++ {
+			defined = append(defined, true)
+		}
+	}
+	if len(defined) != numRows {
+		t.Fatalf("%s: %d definition levels for %d rows", column.Name, len(defined), numRows)
+	}
+
+	values := make([]interface{}, numRows)
+	next := 0 // (index of the next non-null value)
+	for i := range values {
+		if !defined[i] {
+			continue
+		}
+		switch column.Type {
+		case StringColumn, BytesColumn:
+			length := binary.LittleEndian.Uint32(page)
+			values[i] = string(page[4 : 4+length])
+			page = page[4+length:]
+		case Int64Column:
+			values[i] = int64(binary.LittleEndian.Uint64(page))
+			page = page[8:]
+		case Float64Column:
+			values[i] = math.Float64frombits(binary.LittleEndian.Uint64(page))
+			page = page[8:]
+		case BoolColumn:
+			values[i] = page[next/8]&(1<<(next%8)) != 0
+		}
+		next++
+	}
+	return values
+}
+
+func TestParquetSink(t *testing.T) {
+	table := &Table{
+		Name: "test_table",
+		Columns: []Column{
+			{Name: "name", Type: StringColumn},
+			{Name: "hash", Type: BytesColumn},
+			{Name: "count", Type: Int64Column},
+			{Name: "ratio", Type: Float64Column, Nullable: true},
+			{Name: "flag", Type: BoolColumn},
+			{Name: "note", Type: StringColumn, Nullable: true},
+			{Name: "maybe", Type: BoolColumn, Nullable: true},
+		},
+	}
+	rows := [][]interface{}{
+		{"first", []byte{0, 1, 2}, 1, 0.5, true, "note", nil},
+		{"", []byte{}, int64(-7), nil, false, nil, true},
+		{"café", []byte{0xff}, int64(math.MaxInt64), math.Inf(-1), true, nil, nil},
+		{"last", []byte("x"), 0, 2.0, true, "", false},
+	}
+	dir := t.TempDir()
+	sink := NewParquetSink(dir)
+	for _, row := range rows {
+		if err := sink.WriteRow(table, row...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.WriteRow(table, "too", "short"); err == nil {
+		t.Error("WriteRow accepted a row with the wrong number of columns")
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.ReadFile(filepath.Join(dir, "test_table.parquet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(file, parquetMagic) || !bytes.HasSuffix(file, parquetMagic) {
+		t.Fatal("missing PAR1 magic")
+	}
+	footerLength := int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+	footer, err := readThrift(bytes.NewReader(file[len(file)-8-footerLength : len(file)-8]))
+	if err != nil {
+		t.Fatalf("footer: %v", err)
+	}
+	if footer[3].(int64) != int64(len(rows)) {
+		t.Errorf("footer says %d rows, want %d", footer[3], len(rows))
+	}
+	schema := footer[2].([]interface{})
+	if len(schema) != len(table.Columns)+1 {
+		t.Fatalf("schema has %d elements, want %d", len(schema), len(table.Columns)+1)
+	}
+	for i, column := range table.Columns {
+		elem := schema[i+1].(map[int16]interface{})
+		wantRepetition := int64(parquetRequired)
+		if column.Nullable {
+			wantRepetition = parquetOptional
+		}
+		if string(elem[4].([]byte)) != column.Name || elem[1].(int64) != int64(parquetTypes[column.Type]) || elem[3].(int64) != wantRepetition {
+			t.Errorf("schema element %d = %v, want column %+v", i+1, elem, column)
+		}
+	}
+
+	rowGroups := footer[4].([]interface{})
+	if len(rowGroups) != 1 {
+		t.Fatalf("%d row groups, want 1", len(rowGroups))
+	}
+	chunks := rowGroups[0].(map[int16]interface{})[1].([]interface{})
+	for i, column := range table.Columns {
+		got := readParquetColumn(t, file, chunks[i].(map[int16]interface{}), column, len(rows))
+		want := make([]interface{}, len(rows))
+		for j, row := range rows {
+			switch v := row[i].(type) {
+			case []byte:
+				want[j] = string(v)
+			case int:
+				want[j] = int64(v)
+			default:
+				want[j] = v
+			}
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("column %s = %v, want %v", column.Name, got, want)
+		}
+	}
+}
+
+// memParquetFile serves an in-memory Parquet file to parquet-go's reader
+type memParquetFile struct {
+	*bytes.Reader
+}
+
+func (mf memParquetFile) Write([]byte) (int, error) { return 0, io.ErrShortWrite }
+func (mf memParquetFile) Close() error              { return nil }
+func (mf memParquetFile) Open(string) (source.ParquetFile, error) {
+	return memParquetFile{bytes.NewReader(mf.bytes())}, nil
+}
+func (mf memParquetFile) Create(string) (source.ParquetFile, error) { return nil, io.ErrShortWrite }
+func (mf memParquetFile) bytes() []byte {
+	data := make([]byte, mf.Size())
+	mf.ReadAt(data, 0)
+	return data
+}
+
+// TestParquetSinkReadBack reads ParquetSink output (spanning several row groups) back with an independent reader
+func TestParquetSinkReadBack(t *testing.T) {
+	table := &Table{
+		Name: "test_table",
+		Columns: []Column{
+			{Name: "name", Type: StringColumn},
+			{Name: "hash", Type: BytesColumn},
+			{Name: "count", Type: Int64Column},
+			{Name: "ratio", Type: Float64Column, Nullable: true},
+			{Name: "flag", Type: BoolColumn},
+			{Name: "note", Type: StringColumn, Nullable: true},
+		},
+	}
+	numRows := parquetRowGroupSize + 1000
+	row := func(i int) []interface{} {
+		var ratio, note interface{}
+		if i%3 != 0 {
+			ratio = float64(i) / 4
+		}
+		if i%5 == 0 {
+			note = fmt.Sprintf("note \u00e9 %d", i)
+		}
+		return []interface{}{fmt.Sprintf("row %d", i), []byte{byte(i), 0, byte(i >> 8)}, int64(i) * -3, ratio, i%7 == 0, note}
+	}
+	dir := t.TempDir()
+	sink := NewParquetSink(dir)
+	for i := 0; i < numRows; i++ {
+		if err := sink.WriteRow(table, row(i)...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.ReadFile(filepath.Join(dir, "test_table.parquet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pr, err := reader.NewParquetColumnReader(memParquetFile{bytes.NewReader(file)}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := pr.GetNumRows(); got != int64(numRows) {
+		t.Fatalf("reader sees %d rows, want %d", got, numRows)
+	}
+	if got := len(pr.Footer.RowGroups); got != 2 {
+		t.Errorf("%d row groups, want 2", got)
+	}
+	for c, column := range table.Columns {
+		values, _, _, err := pr.ReadColumnByIndex(int64(c), int64(numRows))
+		if err != nil {
+			t.Fatalf("%s: %v", column.Name, err)
+		} else if len(values) != numRows {
+			t.Fatalf("%s: read %d values, want %d", column.Name, len(values), numRows)
+		}
+		for i, got := range values {
+			want := row(i)[c]
+			if b, ok := want.([]byte); ok {
+				want = string(b) // (parquet-go reads BYTE_ARRAY values as strings)
+			}
+			if got != want {
+				t.Errorf("%s[%d] = %#v, want %#v", column.Name, i, got, want)
+				break
+			}
+		}
+	}
+}
+
+func TestThriftEncodeUnsupported(t *testing.T) {
+	_, err := thriftStruct{{1, int32(1)}, {2, thriftStruct{{1, uint8(3)}}}}.encode()
+	if err == nil {
+		t.Error("encode accepted a uint8 field value")
+	}
+}
+
+func TestRLEBitmap(t *testing.T) {
+	tests := []struct {
+		levels []bool
+		want   []byte
+	}{
+		{nil, nil},
+		{[]bool{true}, []byte{2, 1}},
+		{[]bool{true, true, false}, []byte{4, 1, 2, 0}},
+		{make([]bool, 100), []byte{0xc8, 0x01, 0}},
+	}
+	for _, tt := range tests {
+		if got := rleBitmap(tt.levels); !bytes.Equal(got, tt.want) {
+			t.Errorf("rleBitmap(%v) = %v, want %v", tt.levels, got, tt.want)
+		}
+	}
+}
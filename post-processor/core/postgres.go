@@ -1,6 +1,7 @@
 package core
 
 import (
+	"context"
 	"crypto/sha256"
 	"database/sql"
 	"fmt"
@@ -68,9 +69,18 @@ func NullableTimestamp(val time.Time) interface{} {
 	return nullable
 }
 
+// SQLSession is the subset of *sql.DB (and *sql.Conn) used by the bulk-import helpers; temp import tables live only
+// as long as the session that created them, so multi-step imports on a shared pool should pin a *sql.Conn
+type SQLSession interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
 // CreateImportTable creates a temp table copying the schema of a given prototype table
-func CreateImportTable(sqlDb *sql.DB, likeTable, importTableName string) error {
-	_, err := sqlDb.Exec(fmt.Sprintf(`CREATE TEMP TABLE "%s" (LIKE "%s" INCLUDING DEFAULTS INCLUDING INDEXES);`, importTableName, likeTable))
+func CreateImportTable(sqlDb SQLSession, likeTable, importTableName string) error {
+	_, err := sqlDb.ExecContext(context.Background(), fmt.Sprintf(`CREATE TEMP TABLE "%s" (LIKE "%s" INCLUDING DEFAULTS INCLUDING INDEXES);`, importTableName, likeTable))
 	if err != nil {
 		return err
 	}
@@ -81,10 +91,10 @@ func CreateImportTable(sqlDb *sql.DB, likeTable, importTableName string) error {
 type BulkFieldGenerator func() ([]interface{}, error)
 
 // BulkInsertRows performs a bulk-insert transaction, streaming callback-provided data into a temp import table
-func BulkInsertRows(sqlDb *sql.DB, functionName, tableName string, fieldNames []string, generator BulkFieldGenerator) (int64, error) {
+func BulkInsertRows(sqlDb SQLSession, functionName, tableName string, fieldNames []string, generator BulkFieldGenerator) (int64, error) {
 	var rowCount int64
 
-	txn, err := sqlDb.Begin()
+	txn, err := sqlDb.BeginTx(context.Background(), nil)
 	if err != nil {
 		return 0, err
 	}
@@ -170,6 +180,21 @@ type bakedURL struct {
 	Stemmed  string
 }
 
+// importValues gives the row values of a baked URL, in urlImportFields order
+func (curl *bakedURL) importValues() []interface{} {
+	return []interface{}{
+		curl.Sha256[:],
+		curl.Full,
+		NullableString(curl.Scheme),
+		NullableString(curl.Hostname),
+		NullableString(curl.Port),
+		NullableString(curl.Path),
+		NullableString(curl.Query),
+		NullableString(curl.Etld1),
+		NullableString(curl.Stemmed),
+	}
+}
+
 // urlsFields holds the in-order list of field names used for bulk-inserting crawl records into a temp-clone of `urls_import_schema`
 var urlImportFields = [...]string{
 	"sha256",
@@ -228,7 +253,7 @@ func (ub *URLBakery) URLToHash(rawurl string) SHA2Block {
 }
 
 // InsertBakedURLs performs a de-duping bulk insert of cooked URL records into PG's `urls` table
-func (ub *URLBakery) InsertBakedURLs(sqlDb *sql.DB) error {
+func (ub *URLBakery) InsertBakedURLs(sqlDb SQLSession) error {
 	if len(ub.stash) == 0 {
 		log.Println("urlBakery.insertBakedURLs: no baked URLs in the oven; nothing to do!")
 		return nil
@@ -242,7 +267,7 @@ func (ub *URLBakery) InsertBakedURLs(sqlDb *sql.DB) error {
 	}
 	defer func() {
 		log.Printf("urlBakery.insertBakedURLs: dropping temp import table...\n")
-		_, err := sqlDb.Exec(`DROP TABLE import_urls;`)
+		_, err := sqlDb.ExecContext(context.Background(), `DROP TABLE import_urls;`)
 		if err != nil {
 			log.Printf("urlBakery.insertBakedURLs: error (%v) dropping `import_urls` temp table\n", err)
 		}
@@ -264,25 +289,14 @@ func (ub *URLBakery) InsertBakedURLs(sqlDb *sql.DB) error {
 			return nil, nil // signal end-of-stream
 		}
 
-		values := []interface{}{
-			curl.Sha256[:],
-			curl.Full,
-			NullableString(curl.Scheme),
-			NullableString(curl.Hostname),
-			NullableString(curl.Port),
-			NullableString(curl.Path),
-			NullableString(curl.Query),
-			NullableString(curl.Etld1),
-			NullableString(curl.Stemmed),
-		}
-		return values, nil
+		return curl.importValues(), nil
 	})
 	if err != nil {
 		return err
 	}
 
 	log.Println("urlBakery.insertBakedURLs: copy-inserting from temp table...")
-	result, err := sqlDb.Exec(`
+	result, err := sqlDb.ExecContext(context.Background(), `
 INSERT INTO urls (
 		sha256, url_full, url_scheme, url_hostname, url_port,
 		url_path, url_query, url_etld1, url_stemmed)
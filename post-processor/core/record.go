@@ -0,0 +1,183 @@
+package core
+
+// -------------------------------------------------------------------------------------
+// typed trace records (c/n/g/s/j lines parsed once, up front, for every aggregator)
+// -------------------------------------------------------------------------------------
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Record is a single trace record (call, new, get, set, or "j" API access) with its receiver/member/arguments already picked apart
+type Record struct {
+	// Line number (1-based) in the log stream
+	Line int
+
+	// Record type ('c' call, 'n' new-style call, 'g' get, 's' set, 'j' API access; anything else is passed through unparsed)
+	Op byte
+
+	// Kind of 'j' record access ('g' get, 's' set, 'c' call, 'n' new-style call; 0 for all other record types)
+	SubOp byte
+
+	// Character offset into the active script (i.e., the "call site")
+	Offset int
+
+	// Receiver constructor name (e.g., "HTMLDocument"); for 'n' records, the constructor being invoked
+	Receiver string
+
+	// Receiver object ID (from "{id,Ctor}" receivers; empty if not logged)
+	ObjectID string
+
+	// Member name (property or function name, with quotes and native-function marker removed; empty for 'n' records)
+	Member string
+
+	// Was the called function (or constructor) logged as a native ("%"-prefixed) function?
+	Native bool
+
+	// Argument values ('c' and 'n' records, and their 'j' variants), unescaped but not yet typed (see ParseValues)
+	Args []string
+
+	// Value assigned ('s' and 'j' set records), read ('j' get records), or returned ('j' call records),
+	// unescaped but not yet typed (see ParseValue)
+	Value string
+
+	// Original fields, as split by the log parser
+	Fields []string
+}
+
+// RecordAggregator is the typed-record counterpart of Aggregator; IngestStream hands these parsed Records directly
+type RecordAggregator interface {
+	IngestTypedRecord(ctx *ExecutionContext, rec *Record) error
+}
+
+// IngestAsRecord adapts the legacy Aggregator.IngestRecord call to a RecordAggregator (parsing the fields itself)
+func IngestAsRecord(agg RecordAggregator, ctx *ExecutionContext, lineNumber int, op byte, fields []string) error {
+	rec, err := ParseRecord(lineNumber, op, fields)
+	if err != nil {
+		return err
+	}
+	return agg.IngestTypedRecord(ctx, rec)
+}
+
+// SplitReceiver breaks a raw receiver value ("{Ctor}", "{id,Ctor}", or anything else) into its constructor name and object ID (if any)
+func SplitReceiver(raw string) (ctor string, objID string) {
+	ctor, _ = StripCurlies(raw)
+	if id, name, ok := strings.Cut(ctor, ","); ok {
+		if _, err := strconv.ParseUint(id, 10, 64); err == nil {
+			return name, id
+		}
+	}
+	return ctor, ""
+}
+
+// splitFunction strips quotes and the native-function marker ('%') from a raw function name
+func splitFunction(raw string) (name string, native bool) {
+	name, _ = StripQuotes(raw)
+	if strings.HasPrefix(name, "%") {
+		return name[1:], true
+	}
+	return name, false
+}
+
+// ParseRecord builds a Record from the fields of a trace line; ops it does not know come back with only Line/Op/Fields set
+func ParseRecord(lineNumber int, op byte, fields []string) (*Record, error) {
+	rec := &Record{
+		Line:   lineNumber,
+		Op:     op,
+		Fields: fields,
+	}
+
+	var minFields int
+	switch op {
+	case 'c':
+		minFields = 3 // offset, function, receiver[, args...]
+	case 'n':
+		minFields = 2 // offset, function[, args...]
+	case 'g':
+		minFields = 3 // offset, receiver, property
+	case 's':
+		minFields = 3 // offset, receiver, property[, value]
+	case 'j':
+		minFields = 2 // offset, sub-op[, ...] (checked again below, once we know the sub-op)
+	default:
+		return rec, nil
+	}
+	if len(fields) < minFields {
+		return nil, newAnomaly(lineNumber, AnomalyShortRecord, "truncated '%c' record (%d fields, wanted at least %d): %v", op, len(fields), minFields, fields)
+	}
+
+	offset, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return nil, newAnomaly(lineNumber, AnomalyBadRecord, "invalid script offset '%s'", fields[0])
+	}
+	rec.Offset = offset
+
+	switch op {
+	case 'c':
+		rec.Member, rec.Native = splitFunction(fields[1])
+		rec.Receiver, rec.ObjectID = SplitReceiver(fields[2])
+		rec.Args = fields[3:]
+	case 'n':
+		var ctor string
+		ctor, rec.ObjectID = SplitReceiver(fields[1])
+		rec.Receiver, rec.Native = splitFunction(ctor)
+		rec.Args = fields[2:]
+	case 'g', 's':
+		rec.Receiver, rec.ObjectID = SplitReceiver(fields[1])
+		rec.Member, _ = StripQuotes(fields[2])
+		if op == 's' && len(fields) > 3 {
+			rec.Value = fields[3]
+		}
+	case 'j':
+		return parseJRecord(rec)
+	}
+	return rec, nil
+}
+
+// parseJRecord fills in the sub-op-specific parts of a 'j' record:
+// * j<offset>:g|s:<object>:<property>:<value>
+// * j<offset>:c:<function>:<receiver>:<result>[:<args>...]
+// * j<offset>:n:<function>[:<args>...]
+func parseJRecord(rec *Record) (*Record, error) {
+	fields := rec.Fields
+	if len(fields[1]) != 1 {
+		return nil, newAnomaly(rec.Line, AnomalyBadRecord, "invalid 'j' record sub-op '%s'", fields[1])
+	}
+	rec.SubOp = fields[1][0]
+
+	var minFields int
+	switch rec.SubOp {
+	case 'g', 's':
+		minFields = 4 // offset, sub-op, object, property[, value]
+	case 'c':
+		minFields = 5 // offset, sub-op, function, receiver, result[, args...]
+	case 'n':
+		minFields = 3 // offset, sub-op, function[, args...]
+	default:
+		return nil, newAnomaly(rec.Line, AnomalyBadRecord, "invalid 'j' record sub-op '%c'", rec.SubOp)
+	}
+	if len(fields) < minFields {
+		return nil, newAnomaly(rec.Line, AnomalyShortRecord, "truncated 'j%c' record (%d fields, wanted at least %d): %v", rec.SubOp, len(fields), minFields, fields)
+	}
+
+	switch rec.SubOp {
+	case 'g', 's':
+		rec.Receiver, rec.ObjectID = SplitReceiver(fields[2])
+		rec.Member, _ = StripQuotes(fields[3])
+		if len(fields) > 4 {
+			rec.Value = fields[4]
+		}
+	case 'c':
+		rec.Member, rec.Native = splitFunction(fields[2])
+		rec.Receiver, rec.ObjectID = SplitReceiver(fields[3])
+		rec.Value = fields[4]
+		rec.Args = fields[5:]
+	case 'n':
+		var ctor string
+		ctor, rec.ObjectID = SplitReceiver(fields[2])
+		rec.Receiver, rec.Native = splitFunction(ctor)
+		rec.Args = fields[3:]
+	}
+	return rec, nil
+}
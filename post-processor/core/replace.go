@@ -0,0 +1,112 @@
+package core
+
+// -------------------------------------------------------------------------------------
+// Re-importing logs into PostgreSQL: finding a log's earlier import (by its content-derived ID), deleting every row
+// it left behind, and marking imports finished (so ones cut short can be told apart)
+// -------------------------------------------------------------------------------------
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+
+	"github.com/google/uuid"
+)
+
+// logTables lists every table holding per-log rows, with the column naming the log (mega_usages, which
+// hangs off mega_instances, is handled separately); shared tables (urls, mega_scripts, mega_features) are left alone
+var logTables = [...]struct{ table, column string }{
+	{"mega_instances", "logfile_id"},
+	{"script_blobs", "logfile_id"},
+	{"adblock", "logfile_id"},
+	{"thirdpartyfirstparty", "logfile_id"},
+	{"js_api_features_summary", "logfile_id"},
+	{"script_flow", "logfile_id"},
+	{"feature_usage", "logfile_id"},
+	{"multi_origin_obj", "logfile_id"},
+	{"multi_origin_api_names", "logfile_id"},
+	{"script_creation", "logfile_id"},
+	{"poly_feature_usage", "logfile_id"},
+	{"script_causality", "logfile_id"},
+	{"create_elements", "logfile_id"},
+	{"log_stats", "logfile_id"},
+	{"script_causality_graphml", "logfile_id"},
+	{"idlapis", "log_file_id"},
+}
+
+// LogfileState tells whether a log (by ID) is in PG at all and, if so, whether its import finished
+func LogfileState(sqlDb *sql.DB, id uuid.UUID) (present bool, finished bool, err error) {
+	var finishedAt sql.NullTime
+	err = sqlDb.QueryRow(`SELECT finished_at FROM logfile WHERE uuid = $1`, id.String()).Scan(&finishedAt)
+	if err == sql.ErrNoRows {
+		return false, false, nil
+	} else if err != nil {
+		return false, false, err
+	}
+	return true, finishedAt.Valid, nil
+}
+
+// ResetLogfile deletes every row imported from a log (by ID), as one transaction, and marks its import unfinished;
+// the logfile row itself stays (and is reused by the re-import), so until FinishLogfile the log reads as an import
+// cut short, and a crash in between leaves it to be redone
+func ResetLogfile(sqlDb *sql.DB, id uuid.UUID) error {
+	txn, err := sqlDb.Begin()
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback() // (no-op once committed)
+
+	var logID int
+	err = txn.QueryRow(`UPDATE logfile SET finished_at = NULL WHERE uuid = $1 RETURNING id`, id.String()).Scan(&logID)
+	if err == sql.ErrNoRows {
+		return nil // (somebody beat us to it)
+	} else if err != nil {
+		return err
+	}
+	if err = deleteLogRows(txn, logID); err != nil {
+		return err
+	}
+	return txn.Commit()
+}
+
+// deleteLogfileRows deletes a log (by logfile ID) and every row imported from it (within the given transaction)
+func deleteLogfileRows(txn *sql.Tx, logID int) error {
+	if err := deleteLogRows(txn, logID); err != nil {
+		return err
+	}
+	_, err := txn.Exec(`DELETE FROM logfile WHERE id = $1;`, logID)
+	return err
+}
+
+// deleteLogRows deletes every row imported from a log (by logfile ID), but not its logfile row (within the given transaction)
+func deleteLogRows(txn *sql.Tx, logID int) error {
+	result, err := txn.Exec(`
+DELETE FROM mega_usages AS mu
+	USING mega_instances AS mi
+	WHERE mi.id = mu.instance_id AND mi.logfile_id = $1;
+`, logID)
+	if err != nil {
+		return fmt.Errorf("mega_usages: %w", err)
+	}
+	total, _ := result.RowsAffected()
+	for _, lt := range logTables {
+		result, err = txn.Exec(fmt.Sprintf(`DELETE FROM "%s" WHERE "%s" = $1;`, lt.table, lt.column), logID)
+		if err != nil {
+			return fmt.Errorf("%s: %w", lt.table, err)
+		}
+		rows, _ := result.RowsAffected()
+		total += rows
+	}
+	log.Printf("deleted %d rows imported from logfile %d\n", total, logID)
+	return nil
+}
+
+// FinishLogfile marks this log's import finished (inserting its logfile record first, if no aggregator did)
+func (ln *LogInfo) FinishLogfile(sqlDb *sql.DB) error {
+	logID, err := ln.InsertLogfile(sqlDb)
+	if err != nil {
+		return err
+	}
+	_, err = sqlDb.Exec(`UPDATE logfile SET finished_at = now() WHERE id = $1`, logID)
+	return err
+}
@@ -0,0 +1,103 @@
+package core
+
+// -------------------------------------------------------------------------------------
+// content-addressed on-disk storage of script bodies (keeps script text out of RAM)
+// -------------------------------------------------------------------------------------
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ScriptStore spills script bodies to files named by their ScriptHash, so identical bodies (across isolates, logs,
+// and concurrent jobs) are stored once; it is safe for concurrent use
+type ScriptStore struct {
+	dir   string
+	owned bool // did we create dir (so Close should remove it)?
+
+	lock   sync.Mutex
+	stored map[ScriptHash]bool // bodies known to be on disk already
+}
+
+// NewScriptStore opens (creating if needed) a script store rooted at <dir>; an empty <dir> means a private temp
+// directory, removed again by Close
+func NewScriptStore(dir string) (*ScriptStore, error) {
+	store := &ScriptStore{
+		dir:    dir,
+		stored: make(map[ScriptHash]bool),
+	}
+	var err error
+	if dir == "" {
+		store.dir, err = os.MkdirTemp("", "vv8-scripts-")
+		store.owned = true
+	} else {
+		err = os.MkdirAll(dir, 0755)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// path gives the file name for a given script hash (sharded by the first SHA2 byte, to keep directories small)
+func (store *ScriptStore) path(hash ScriptHash) string {
+	return filepath.Join(store.dir, fmt.Sprintf("%02x", hash.SHA2[0]), fmt.Sprintf("%x-%x-%d", hash.SHA2, hash.SHA3, hash.Length))
+}
+
+// Put stores a script body under its hash (doing nothing if it is already there)
+func (store *ScriptStore) Put(hash ScriptHash, code string) error {
+	store.lock.Lock()
+	known := store.stored[hash]
+	store.lock.Unlock()
+	if known {
+		return nil
+	}
+
+	path := store.path(hash)
+	if _, err := os.Stat(path); err != nil {
+		// Write to a temp file and rename it into place, so readers (and racing writers) never see partial bodies
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			return err
+		}
+		tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+		if err != nil {
+			return err
+		}
+		_, err = tmp.WriteString(code)
+		if cerr := tmp.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = os.Rename(tmp.Name(), path)
+		}
+		if err != nil {
+			os.Remove(tmp.Name())
+			return err
+		}
+	}
+
+	store.lock.Lock()
+	store.stored[hash] = true
+	store.lock.Unlock()
+	return nil
+}
+
+// Get loads a script body by its hash
+func (store *ScriptStore) Get(hash ScriptHash) (string, error) {
+	code, err := os.ReadFile(store.path(hash))
+	if err != nil {
+		return "", err
+	}
+	return string(code), nil
+}
+
+// Close releases the store (removing it from disk if it was a private temp directory)
+func (store *ScriptStore) Close() error {
+	if store.owned {
+		return os.RemoveAll(store.dir)
+	}
+	return nil
+}
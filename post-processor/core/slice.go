@@ -0,0 +1,242 @@
+package core
+
+// -------------------------------------------------------------------------------------
+// log slicing: extract the trace records of one origin/isolate/script (plus just enough context to replay them)
+// -------------------------------------------------------------------------------------
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// SliceFilter selects the trace records kept in a slice; every criterion set must match (unset criteria match anything)
+type SliceFilter struct {
+	// Security origin (regexp, matched against the active origin)
+	Origin *regexp.Regexp
+
+	// Isolate "pointer" string (exact)
+	Isolate string
+
+	// Active script ID (-1 for any)
+	ScriptID int
+
+	// Script URL (regexp, matched against the URL of the active script or, for eval'd scripts, any of its eval ancestors)
+	URL *regexp.Regexp
+
+	// Script hash (hex SHA2-256 prefix, matched against the active script)
+	Hash string
+
+	// Line range (1-based, inclusive; 0 for unbounded)
+	FirstLine, LastLine int
+}
+
+// NewSliceFilter creates a filter matching everything
+func NewSliceFilter() *SliceFilter {
+	return &SliceFilter{ScriptID: -1}
+}
+
+// ParseLineRange parses "FIRST-LAST" (either end may be omitted) into a 1-based inclusive range
+func (sf *SliceFilter) ParseLineRange(raw string) error {
+	first, last, ok := strings.Cut(raw, "-")
+	if !ok {
+		return fmt.Errorf("invalid line range '%s' (wanted FIRST-LAST)", raw)
+	}
+	var err error
+	if first != "" {
+		if sf.FirstLine, err = strconv.Atoi(first); err != nil || sf.FirstLine < 1 {
+			return fmt.Errorf("invalid first line '%s'", first)
+		}
+	}
+	if last != "" {
+		if sf.LastLine, err = strconv.Atoi(last); err != nil || sf.LastLine < 1 {
+			return fmt.Errorf("invalid last line '%s'", last)
+		}
+	}
+	if sf.LastLine > 0 && sf.LastLine < sf.FirstLine {
+		return fmt.Errorf("empty line range '%s'", raw)
+	}
+	return nil
+}
+
+// matches decides whether a trace record (on a given line, in a given context) belongs in the slice
+func (sf *SliceFilter) matches(lineNumber int, iso *IsolateInfo, ctx *ExecutionContext) bool {
+	if (sf.FirstLine > 0 && lineNumber < sf.FirstLine) || (sf.LastLine > 0 && lineNumber > sf.LastLine) {
+		return false
+	}
+	if sf.Isolate != "" && iso.ID != sf.Isolate {
+		return false
+	}
+	if sf.Origin != nil && (ctx.Origin == nil || !sf.Origin.MatchString(ctx.Origin.Origin)) {
+		return false
+	}
+	script := ctx.Script
+	if sf.ScriptID >= 0 || sf.URL != nil || sf.Hash != "" {
+		if script == nil || script.Placeholder {
+			return false
+		}
+	}
+	if sf.ScriptID >= 0 && script.ID != sf.ScriptID {
+		return false
+	}
+	if sf.Hash != "" && !strings.HasPrefix(hex.EncodeToString(script.CodeHash.SHA2[:]), strings.ToLower(sf.Hash)) {
+		return false
+	}
+	if sf.URL != nil {
+		found := false
+		for s := script; s != nil && !found; s = s.EvaledBy {
+			found = s.URL != "" && sf.URL.MatchString(s.URL)
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// slicer remembers the raw context records seen so far, and which of them the slice has replayed
+type slicer struct {
+	out *bufio.Writer
+
+	isolateLines map[*IsolateInfo]string // raw '~' record of each isolate
+	originLines  map[*Origin]string      // raw '@' record that set each origin
+	scriptLines  map[*ScriptInfo]string  // raw '$' record that defined each script
+
+	outIsolate *IsolateInfo                   // isolate active in the slice
+	outContext map[*IsolateInfo]*sliceContext // per-isolate context as the slice has it
+	outScripts map[*ScriptInfo]bool           // scripts defined in the slice
+}
+
+// sliceContext is the active origin/script of an isolate, as replayed into the slice
+type sliceContext struct {
+	origin string // raw '@' record
+	script *ScriptInfo
+}
+
+func (sl *slicer) emit(line string) {
+	sl.out.WriteString(line)
+	sl.out.WriteByte('\n')
+}
+
+// switchOrigin replays the '@' record setting an origin (unless already active in the slice)
+func (sl *slicer) switchOrigin(origin *Origin) {
+	line, ok := sl.originLines[origin]
+	if ok && sl.outContext[sl.outIsolate].origin != line {
+		sl.emit(line)
+		sl.outContext[sl.outIsolate].origin = line
+	}
+}
+
+// defineScript replays a script's '$' record (after those of its eval ancestors), under the origin it was defined in
+func (sl *slicer) defineScript(script *ScriptInfo) {
+	if script == nil || sl.outScripts[script] {
+		return
+	}
+	sl.defineScript(script.EvaledBy)
+	line, ok := sl.scriptLines[script]
+	if !ok {
+		return // (a placeholder; the slice will be just as lenient-only as the original)
+	}
+	sl.switchOrigin(script.FirstOrigin)
+	sl.emit(line)
+	sl.outScripts[script] = true
+}
+
+// keep writes a trace record to the slice, preceded by whatever context records it needs
+func (sl *slicer) keep(iso *IsolateInfo, line []byte) {
+	if sl.outContext[iso] == nil {
+		sl.outContext[iso] = &sliceContext{}
+	}
+	if sl.outIsolate != iso {
+		if isoLine, ok := sl.isolateLines[iso]; ok {
+			sl.emit(isoLine)
+		}
+		sl.outIsolate = iso
+		sl.outContext[iso].script = nil
+	}
+	script := iso.Context.Script
+	sl.defineScript(script)
+	sl.switchOrigin(iso.Context.Origin)
+	if sl.outContext[iso].script != script {
+		if script != nil {
+			sl.emit(fmt.Sprintf("!%d", script.ID))
+		} else if isoLine, ok := sl.isolateLines[iso]; ok {
+			sl.emit(isoLine) // (re-entering the isolate clears its active script)
+		}
+		sl.outContext[iso].script = script
+	}
+	sl.out.Write(line)
+	sl.out.WriteByte('\n')
+}
+
+// SliceStream writes a new VV8 log of the trace records matching <filter>, with the minimal context records
+// ('~', '@', '$' [including eval ancestors], and '!') needed for it to ingest just like the originals did
+func SliceStream(stream io.Reader, out io.Writer, aggCtx *AggregationContext, filter *SliceFilter) error {
+	ln := NewLogInfo(aggCtx.LogOid, aggCtx.RootName, aggCtx.SubmissionID)
+	ln.Lenient = aggCtx.Lenient
+	sl := &slicer{
+		out:          bufio.NewWriter(out),
+		isolateLines: make(map[*IsolateInfo]string),
+		originLines:  make(map[*Origin]string),
+		scriptLines:  make(map[*ScriptInfo]string),
+		outContext:   make(map[*IsolateInfo]*sliceContext),
+		outScripts:   make(map[*ScriptInfo]bool),
+	}
+
+	scan := newLogLineScanner(stream)
+	var lineCount, kept int
+	for scan.Scan() {
+		line := scan.Bytes()
+		lineCount++
+		if scan.truncated && ln.dropTruncatedLine(lineCount, len(line)) {
+			lineCount--
+			break
+		}
+		if len(line) == 0 {
+			continue
+		}
+		code := line[0]
+		fields := splitFields(line[1:])
+		isTrace, err := ln.ingestContextRecord(lineCount, code, fields)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case isTrace:
+			if filter.matches(lineCount, ln.World, &ln.World.Context) {
+				sl.keep(ln.World, line)
+				kept++
+			}
+		case code == '~':
+			if _, ok := sl.isolateLines[ln.World]; !ok {
+				sl.isolateLines[ln.World] = string(line)
+			}
+		case code == '@' && len(fields) >= contextFieldCounts['@']:
+			if ln.World.Context.Origin != nil {
+				sl.originLines[ln.World.Context.Origin] = string(line)
+			}
+		case code == '$' && len(fields) >= contextFieldCounts['$']:
+			if scriptID, err := strconv.Atoi(fields[0]); err == nil {
+				if script, ok := ln.World.Scripts[scriptID]; ok && !script.Placeholder {
+					if _, seen := sl.scriptLines[script]; !seen {
+						sl.scriptLines[script] = string(line)
+					}
+				}
+			}
+		}
+	}
+	if scan.Err() != nil {
+		return scan.Err()
+	}
+	log.Printf("%s: kept %d trace records (of %d lines)\n", ln.RootName, kept, lineCount)
+	if ln.Lenient {
+		log.Printf("%s: anomalies tolerated: %s\n", ln.RootName, ln.AnomalySummary())
+	}
+	return sl.out.Flush()
+}
@@ -0,0 +1,154 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+// slicedRecord is a trace record as an aggregator sees it (sans line number, which slicing changes)
+type slicedRecord struct {
+	record  string   // op and fields
+	isolate string   // active isolate
+	script  int      // active script ID (-1 for none)
+	hash    string   // active script's SHA2 (hex)
+	origin  string   // active origin ("" for none)
+	urls    []string // URLs of the active script and its eval ancestors
+}
+
+// contextRecordingAggregator notes every trace record along with the context it ran in
+type contextRecordingAggregator struct {
+	records []slicedRecord
+}
+
+func (agg *contextRecordingAggregator) IngestRecord(ctx *ExecutionContext, lineNumber int, op byte, fields []string) error {
+	rec := slicedRecord{record: fmt.Sprintf("%c%q", op, fields), script: -1}
+	if ctx.Script != nil {
+		rec.isolate = ctx.Script.Isolate.ID
+		rec.script = ctx.Script.ID
+		rec.hash = fmt.Sprintf("%x", ctx.Script.CodeHash.SHA2)
+		for s := ctx.Script; s != nil; s = s.EvaledBy {
+			rec.urls = append(rec.urls, s.URL)
+		}
+	}
+	if ctx.Origin != nil {
+		rec.origin = ctx.Origin.Origin
+	}
+	agg.records = append(agg.records, rec)
+	return nil
+}
+
+// ingestContexts ingests a log strictly, giving each trace record with its context
+func ingestContexts(t *testing.T, data []byte) []slicedRecord {
+	agg := new(contextRecordingAggregator)
+	ln := NewLogInfo([12]byte{}, "vv8-test.0.log", [16]byte{})
+	ln.AnomalyHook = func(anomaly *AnomalyError) {
+		t.Errorf("anomaly: %v", anomaly)
+	}
+	if err := ln.IngestStream(bytes.NewReader(data), agg); err != nil {
+		t.Fatal(err)
+	}
+	return agg.records
+}
+
+func TestSliceStream(t *testing.T) {
+	logs := map[string][]byte{
+		"isolates and evals": []byte("~0x1\n" +
+			"@\"https\\://a.test\"\n" +
+			"$1:\"https\\://a.test/main.js\":eval(\"x()\")\n" +
+			"!1\n" +
+			"c10:%eval:{Window}:\"x()\"\n" +
+			"$2:1:x()\n" +
+			"!2\n" +
+			"c1:%x:{Window}\n" +
+			"@\"https\\://b.test\"\n" +
+			"$3:\"https\\://b.test/other.js\":y()\n" +
+			"!3\n" +
+			"g5:{Window}:\"document\"\n" +
+			"~0x2\n" +
+			"@\"https\\://a.test\"\n" +
+			"$1:\"https\\://c.test/w.js\":z()\n" +
+			"!1\n" +
+			"s7:{Window}:\"name\":\"v\"\n" +
+			"~0x1\n" +
+			"!2\n" +
+			"g3:{Window}:\"location\"\n" +
+			"!1\n" +
+			"@\"https\\://a.test\"\n" +
+			"c11:%eval:{Window}:\"x()\"\n"),
+	}
+	paths, err := filepath.Glob("../../tests/logs/*/*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		if logs[path], err = os.ReadFile(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, data := range logs {
+		original := ingestContexts(t, data)
+		if len(original) == 0 {
+			t.Fatalf("%s: no trace records", name)
+		}
+
+		// (slice by the context of the last record run by an eval'd script, if any, else the first record's)
+		pick := original[0]
+		for _, rec := range original {
+			if len(rec.urls) > 1 {
+				pick = rec
+			}
+		}
+		origin := regexp.MustCompile("^" + regexp.QuoteMeta(pick.origin) + "$")
+		url := regexp.MustCompile("^" + regexp.QuoteMeta(pick.urls[len(pick.urls)-1]) + "$")
+		filters := []struct {
+			name   string
+			filter *SliceFilter
+			want   func(rec slicedRecord) bool
+		}{
+			{"script", &SliceFilter{ScriptID: pick.script}, func(rec slicedRecord) bool {
+				return rec.script == pick.script
+			}},
+			{"isolate script", &SliceFilter{Isolate: pick.isolate, ScriptID: pick.script}, func(rec slicedRecord) bool {
+				return rec.isolate == pick.isolate && rec.script == pick.script
+			}},
+			{"origin", &SliceFilter{ScriptID: -1, Origin: origin}, func(rec slicedRecord) bool {
+				return rec.origin != "" && origin.MatchString(rec.origin)
+			}},
+			{"script URL", &SliceFilter{ScriptID: -1, URL: url}, func(rec slicedRecord) bool {
+				for _, u := range rec.urls {
+					if u != "" && url.MatchString(u) {
+						return true
+					}
+				}
+				return false
+			}},
+		}
+
+		for _, f := range filters {
+			t.Run(fmt.Sprintf("%s/%s", name, f.name), func(t *testing.T) {
+				var want []slicedRecord
+				for _, rec := range original {
+					if f.want(rec) {
+						want = append(want, rec)
+					}
+				}
+				if len(want) == 0 {
+					t.Fatal("filter matches no records")
+				}
+				var slice bytes.Buffer
+				if err := SliceStream(bytes.NewReader(data), &slice, &AggregationContext{RootName: "vv8-test.0.log"}, f.filter); err != nil {
+					t.Fatal(err)
+				}
+				if got := ingestContexts(t, slice.Bytes()); !reflect.DeepEqual(got, want) {
+					t.Errorf("slice kept:\n%+v\nwant:\n%+v\nslice:\n%s", got, want, slice.String())
+				}
+			})
+		}
+	}
+}
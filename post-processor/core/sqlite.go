@@ -0,0 +1,287 @@
+package core
+
+// -------------------------------------------------------------------------------------
+// SQLite output: a self-contained, single-file stand-in for the PostgreSQL `mega` schema (urls, logfile, mega_*),
+// filled with the same temp-import-table + "INSERT ... SELECT ... ON CONFLICT DO NOTHING" dedupe flow
+// -------------------------------------------------------------------------------------
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3" // (registers "sqlite3")
+)
+
+// sqliteSchema mirrors the mega tables of migrations/0001_baseline.sql (SERIAL -> INTEGER PRIMARY KEY, BYTEA -> BLOB); applied on every open
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS urls_import_schema (
+    id INTEGER PRIMARY KEY NOT NULL,
+    sha256 BLOB UNIQUE NOT NULL,
+    url_full TEXT,
+    url_scheme TEXT,
+    url_hostname TEXT,
+    url_port TEXT,
+    url_path TEXT,
+    url_query TEXT,
+    url_etld1 TEXT,
+    url_stemmed TEXT
+);
+
+CREATE TABLE IF NOT EXISTS urls (
+    id INTEGER PRIMARY KEY NOT NULL,
+    sha256 BLOB UNIQUE NOT NULL,
+    url_full TEXT,
+    url_scheme TEXT,
+    url_hostname TEXT,
+    url_port TEXT,
+    url_path TEXT,
+    url_query TEXT,
+    url_etld1 TEXT,
+    url_stemmed TEXT
+);
+
+CREATE TABLE IF NOT EXISTS logfile (
+    id INTEGER PRIMARY KEY NOT NULL,
+    mongo_oid BLOB NOT NULL,
+    uuid TEXT NOT NULL UNIQUE,
+    root_name TEXT NOT NULL,
+    size BIGINT NOT NULL,
+    lines INT NOT NULL,
+    submissionid TEXT
+);
+
+CREATE TABLE IF NOT EXISTS mega_scripts (
+    id INTEGER PRIMARY KEY NOT NULL,
+    sha2 BLOB NOT NULL,
+    sha3 BLOB NOT NULL,
+    size INT NOT NULL,
+    UNIQUE (sha2, sha3, size)
+);
+
+CREATE TABLE IF NOT EXISTS mega_scripts_import_schema (
+    sha2 BLOB NOT NULL,
+    sha3 BLOB NOT NULL,
+    size INT NOT NULL,
+    PRIMARY KEY (sha2, sha3, size)
+);
+
+CREATE TABLE IF NOT EXISTS mega_instances (
+    id INTEGER PRIMARY KEY NOT NULL,
+    instance_hash BLOB UNIQUE NOT NULL,
+    logfile_id INT REFERENCES logfile(id),
+    script_id INT REFERENCES mega_scripts(id),
+    isolate_ptr TEXT NOT NULL,
+    runtime_id INT NOT NULL,
+    origin_url_id INT REFERENCES urls(id),
+    script_url_id INT REFERENCES urls(id),
+    eval_parent_hash BLOB
+);
+
+CREATE TABLE IF NOT EXISTS mega_instances_import_schema (
+    instance_hash BLOB UNIQUE NOT NULL,
+    logfile_id INT,
+    script_id INT,
+    isolate_ptr TEXT NOT NULL,
+    runtime_id INT NOT NULL,
+    origin_url_sha256 BLOB,
+    script_url_sha256 BLOB,
+    eval_parent_hash BLOB
+);
+
+CREATE TABLE IF NOT EXISTS mega_features (
+    id INTEGER PRIMARY KEY NOT NULL,
+    sha256 BLOB UNIQUE NOT NULL,
+    full_name TEXT NOT NULL,
+    receiver_name TEXT,
+    member_name TEXT,
+    idl_base_receiver TEXT,
+    idl_member_role CHAR(1)
+);
+
+CREATE TABLE IF NOT EXISTS mega_features_import_schema (
+    sha256 BLOB PRIMARY KEY,
+    full_name TEXT NOT NULL,
+    receiver_name TEXT,
+    member_name TEXT,
+    idl_base_receiver TEXT,
+    idl_member_role CHAR(1)
+);
+
+-- (PostgreSQL makes primary-key columns NOT NULL implicitly; SQLite must be told)
+CREATE TABLE IF NOT EXISTS mega_usages (
+    instance_id INT REFERENCES mega_instances(id) NOT NULL,
+    feature_id INT REFERENCES mega_features(id) NOT NULL,
+    origin_url_id INT REFERENCES urls(id) NOT NULL,
+    usage_offset INT NOT NULL,
+    usage_mode CHAR(1) NOT NULL,
+    usage_count INT NOT NULL,
+    PRIMARY KEY (instance_id, feature_id, origin_url_id, usage_offset, usage_mode)
+);
+
+CREATE TABLE IF NOT EXISTS mega_usages_import_schema (
+    instance_id INT NOT NULL,
+    feature_id INT NOT NULL,
+    origin_url_sha256 BLOB NOT NULL,
+    usage_offset INT NOT NULL,
+    usage_mode CHAR(1) NOT NULL,
+    usage_count INT NOT NULL,
+    PRIMARY KEY (instance_id, feature_id, origin_url_sha256, usage_offset, usage_mode)
+);
+`
+
+// OpenSQLite opens (creating it if needed) a SQLite database file, making sure the schema is in place
+//
+// The pool holds a single connection: temp import tables are per-connection, and SQLite has one writer at a time anyway
+// (so concurrent workers simply take turns); other processes writing the same file wait for their turn, too.
+func OpenSQLite(path string) (*sql.DB, error) {
+	if path == "" || strings.ContainsAny(path, "?#") {
+		return nil, fmt.Errorf("invalid SQLite database path '%s'", path)
+	}
+	sqliteDb, err := sql.Open("sqlite3", path+"?_busy_timeout=60000&_txlock=immediate&_journal_mode=WAL")
+	if err != nil {
+		return nil, err
+	}
+	sqliteDb.SetMaxOpenConns(1)
+	if _, err = sqliteDb.Exec(sqliteSchema); err != nil {
+		sqliteDb.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return sqliteDb, nil
+}
+
+// SQLiteDumper implements dumping of output data into a SQLite database (within the given transaction)
+type SQLiteDumper interface {
+	DumpToSQLite(ctx *AggregationContext, tx *sql.Tx) error
+}
+
+// NewSQLiteDumpDriver creates a driver function to invoke SQLiteDumper logic on the given aggregator (if possible),
+// committing each aggregator's output as one transaction
+func NewSQLiteDumpDriver(sqliteDb *sql.DB) DumpDriver {
+	return func(agg Aggregator, ctx *AggregationContext) error {
+		dumper, ok := agg.(SQLiteDumper)
+		if !ok {
+			log.Printf("WARNING: %T does not support SQLite dumping!", agg)
+			return nil
+		}
+
+		tx, err := sqliteDb.Begin()
+		if err != nil {
+			return err
+		}
+		if err = dumper.DumpToSQLite(ctx, tx); err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				log.Printf("SQLite rollback failed: %v\n", rollbackErr)
+			}
+			ctx.Ln.Tabled = false // (the logfile row went, too)
+			return err
+		}
+		return tx.Commit()
+	}
+}
+
+// CreateSQLiteImportTable is CreateImportTable for SQLite: a temp table with the columns of a given prototype table
+func CreateSQLiteImportTable(tx *sql.Tx, likeTable, importTableName string) error {
+	_, err := tx.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS temp."%s"; CREATE TEMP TABLE "%s" AS SELECT * FROM "%s" WHERE 0;`,
+		importTableName, importTableName, likeTable))
+	return err
+}
+
+// DropSQLiteImportTable drops a temp table made by CreateSQLiteImportTable (logging, not returning, any error)
+func DropSQLiteImportTable(tx *sql.Tx, functionName, importTableName string) {
+	log.Printf("%s: dropping temp table '%s'...", functionName, importTableName)
+	if _, err := tx.Exec(fmt.Sprintf(`DROP TABLE temp."%s";`, importTableName)); err != nil {
+		log.Printf("%s: failed to drop `%s` temp table (%v)\n", functionName, importTableName, err)
+	}
+}
+
+// BulkInsertRowsSQLite is BulkInsertRows for SQLite, streaming callback-provided data into a temp import table
+// (within the caller's transaction, which commits or rolls back everything)
+func BulkInsertRowsSQLite(tx *sql.Tx, functionName, tableName string, fieldNames []string, generator BulkFieldGenerator) (int64, error) {
+	var rowCount int64
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(fieldNames)), ", ")
+	stmt, err := tx.Prepare(fmt.Sprintf(`INSERT INTO "%s" (%s) VALUES (%s);`, tableName, strings.Join(fieldNames, ", "), placeholders))
+	if err != nil {
+		log.Printf("%s: tx.Prepare(...) failed: %v\n", functionName, err)
+		return 0, err
+	}
+	defer stmt.Close()
+
+	lastProgressReport := time.Now()
+	for {
+		values, err := generator()
+		if err != nil { // error/abort
+			log.Printf("%s: generator(...) failed: %v\n", functionName, err)
+			return 0, err
+		} else if values == nil { // end-of-stream
+			break
+		}
+		if _, err = stmt.Exec(values...); err != nil {
+			log.Printf("%s: stmt.Exec(...) failed: %v\n", functionName, err)
+			return 0, err
+		}
+		rowCount++
+		if time.Since(lastProgressReport) >= (time.Second * 5) {
+			log.Printf("%s: processed %d records so far...\n", functionName, rowCount)
+			lastProgressReport = time.Now()
+		}
+	}
+	log.Printf("%s: done processing after %d records\n", functionName, rowCount)
+
+	return rowCount, nil
+}
+
+// InsertBakedURLsSQLite is InsertBakedURLs for SQLite: a de-duping bulk insert of cooked URL records into `urls`
+func (ub *URLBakery) InsertBakedURLsSQLite(tx *sql.Tx) error {
+	if len(ub.stash) == 0 {
+		log.Println("urlBakery.insertBakedURLsSQLite: no baked URLs in the oven; nothing to do!")
+		return nil
+	}
+
+	if err := CreateSQLiteImportTable(tx, "urls_import_schema", "import_urls"); err != nil {
+		return err
+	}
+	defer DropSQLiteImportTable(tx, "urlBakery.insertBakedURLsSQLite", "import_urls")
+
+	stash := make([]*bakedURL, 0, len(ub.stash))
+	for _, curl := range ub.stash {
+		stash = append(stash, curl)
+	}
+	importRows, err := BulkInsertRowsSQLite(tx, "urlBakery.insertBakedURLsSQLite", "import_urls", urlImportFields[:], func() ([]interface{}, error) {
+		if len(stash) == 0 {
+			return nil, nil // signal end-of-stream
+		}
+		curl := stash[0]
+		stash = stash[1:]
+		return curl.importValues(), nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// (SQLite needs the WHERE to tell ON CONFLICT apart from a join constraint)
+	result, err := tx.Exec(`
+INSERT INTO urls (
+		sha256, url_full, url_scheme, url_hostname, url_port,
+		url_path, url_query, url_etld1, url_stemmed)
+	SELECT
+		iu.sha256, iu.url_full, iu.url_scheme, iu.url_hostname, iu.url_port,
+		iu.url_path, iu.url_query, iu.url_etld1, iu.url_stemmed
+	FROM import_urls AS iu
+	WHERE true
+ON CONFLICT DO NOTHING;
+`)
+	if err != nil {
+		return err
+	}
+	insertRows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	log.Printf("urlBakery.insertBakedURLsSQLite: inserted %d (out of %d) import rows\n", insertRows, importRows)
+
+	return nil
+}
@@ -0,0 +1,120 @@
+package core
+
+// typed-table output interfaces/utilities (for columnar destinations like Parquet files)
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/google/uuid"
+)
+
+// ColumnType is the storage type of a table column
+type ColumnType int
+
+const (
+	// StringColumn holds UTF-8 text (Go string)
+	StringColumn ColumnType = iota
+	// BytesColumn holds raw bytes, e.g., script hashes (Go []byte)
+	BytesColumn
+	// Int64Column holds integers (Go int or int64)
+	Int64Column
+	// Float64Column holds floating-point numbers (Go float64)
+	Float64Column
+	// BoolColumn holds booleans (Go bool)
+	BoolColumn
+)
+
+// Column describes one column of a table
+type Column struct {
+	Name     string
+	Type     ColumnType
+	Nullable bool // may rows give nil for this column?
+}
+
+// Table describes an output table; rows are given as one value per column, in column order
+type Table struct {
+	Name    string
+	Columns []Column
+}
+
+// TableSink receives typed rows for any number of tables (implementations must be safe for concurrent use)
+type TableSink interface {
+	WriteRow(table *Table, row ...interface{}) error
+}
+
+// TableDumper implements dumping of output data as rows of typed tables (declared by the aggregator itself)
+type TableDumper interface {
+	DumpToTables(ctx *AggregationContext, sink TableSink) error
+}
+
+// LogfileTable describes each log processed (the logfile_id of other tables refers to its id)
+var LogfileTable = &Table{
+	Name: "logfile",
+	Columns: []Column{
+		{Name: "id", Type: StringColumn},
+		{Name: "mongo_oid", Type: BytesColumn},
+		{Name: "root_name", Type: StringColumn},
+		{Name: "size", Type: Int64Column},
+		{Name: "lines", Type: Int64Column},
+		{Name: "submissionid", Type: StringColumn, Nullable: true},
+		{Name: "visit_domain", Type: StringColumn, Nullable: true},
+	},
+}
+
+// checkRow verifies that a row fits a table's columns
+func (table *Table) checkRow(row []interface{}) error {
+	if len(row) != len(table.Columns) {
+		return fmt.Errorf("%s: row has %d values (wanted %d)", table.Name, len(row), len(table.Columns))
+	}
+	for i, col := range table.Columns {
+		var ok bool
+		switch row[i].(type) {
+		case nil:
+			ok = col.Nullable
+		case string:
+			ok = col.Type == StringColumn
+		case []byte:
+			ok = col.Type == BytesColumn
+		case int, int64:
+			ok = col.Type == Int64Column
+		case float64:
+			ok = col.Type == Float64Column
+		case bool:
+			ok = col.Type == BoolColumn
+		}
+		if !ok {
+			return fmt.Errorf("%s.%s: invalid value %#v", table.Name, col.Name, row[i])
+		}
+	}
+	return nil
+}
+
+// NewTableDumpDriver creates a driver function to invoke TableDumper logic on the given aggregator (if possible),
+// first writing the log's own row to LogfileTable
+func NewTableDumpDriver(sink TableSink) DumpDriver {
+	logged := false
+	return func(agg Aggregator, ctx *AggregationContext) error {
+		if !logged {
+			logged = true
+			var submissionID interface{}
+			if ctx.Ln.SubmissionID != uuid.Nil {
+				submissionID = ctx.Ln.SubmissionID.String()
+			}
+			err := sink.WriteRow(LogfileTable,
+				ctx.Ln.ID.String(), ctx.Ln.mongoOIDColumn(), ctx.Ln.RootName, ctx.Ln.Stats.Bytes, ctx.Ln.Stats.Lines,
+				submissionID, NullableString(ctx.RootDomain))
+			if err != nil {
+				return err
+			}
+		}
+
+		dumper, ok := agg.(TableDumper)
+		if ok {
+			return dumper.DumpToTables(ctx, sink)
+		}
+
+		log.Printf("WARNING: %T does not support table (Parquet) dumping!", agg)
+		return nil
+	}
+}
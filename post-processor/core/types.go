@@ -1,8 +1,10 @@
 package core
 
 import (
+	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"hash"
 
 	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -37,13 +39,19 @@ type AggregationContext struct {
 	MongoDb      *mongo.Database    // shared MongoDB connection (may be nil)
 	SQLDb        *sql.DB            // shared PG connection (may be nil)
 	RootDomain   string             // if present, used to provide the root domain of the submission (only used by causality right now)
+	Lenient      bool               // tolerate (and count) malformed/truncated log data instead of failing fast?
+	ScriptStore  *ScriptStore       // if present, script bodies are spilled here instead of being kept in RAM
 }
 
 // A LogInfo tracks all essential context information for a VV8 log under processing
 type LogInfo struct {
-	// A unique ID assigned to the log
+	// The log's ID: derived from its root name and content once ingested (so reprocessing a log gives the same ID)
+	// if DeriveID is set, or else a random one assigned as ingestion starts
 	ID uuid.UUID
 
+	// Hash the log's content while ingesting it, to derive ID and Digest? (only worth it for outputs that keep the ID)
+	DeriveID bool
+
 	// Database id of the vv8log record being processed
 	MongoID primitive.ObjectID
 
@@ -62,11 +70,39 @@ type LogInfo struct {
 	// Has a entry for this log been added to the database?
 	Tabled bool
 
+	// Tolerate malformed log data (patching up the context with placeholders) instead of failing fast?
+	Lenient bool
+
+	// How many anomalies of each kind were tolerated (lenient mode only)?
+	Anomalies map[AnomalyKind]int
+
+	// If set, called with each anomaly tolerated (lenient mode only), instead of logging it
+	AnomalyHook func(ae *AnomalyError)
+
+	// Report invalid escape sequences (bad \x/\u digits, unpaired UTF-16 surrogates) as anomalies?
+	// (by default they are silently decoded as best we can)
+	CheckEscapes bool
+
+	// Where to spill script bodies (if nil, they are kept in RAM)
+	ScriptStore *ScriptStore
+
 	// Statistics on log size
 	Stats struct {
-		Lines int
-		Bytes int64
+		Lines   int
+		Bytes   int64
+		Records [256]int // lines seen, by record code (first character)
 	}
+
+	// SHA256 of the log's content (each record's line number, code, and decoded fields), once ingested with DeriveID
+	// (the same for a text log, compressed or not, and its binary conversion)
+	Digest [sha256.Size]byte
+
+	// Running content hash (and scratch space) behind Digest (nil unless deriving the ID)
+	digest        hash.Hash
+	digestScratch []byte
+
+	// Only hash lines (to derive the ID), without tracking context or aggregating? (see PeekLogID)
+	digestOnly bool
 }
 
 // ExecutionContext provides context to a trace record: the active script and the enforced SOP domain (if any)
@@ -103,9 +139,15 @@ type ScriptInfo struct {
 	// Is this a "visible-v8://" script (which shouldn't be included in usage stats)?
 	VisibleV8 bool
 
-	// Script code
+	// Is this a stand-in for a script never (or not yet) defined in the log? (lenient mode only; no code/URL)
+	Placeholder bool
+
+	// Script code (empty if spilled to a ScriptStore; use Source() to get it either way)
 	Code string
 
+	// Where the code went, if spilled
+	store *ScriptStore
+
 	// CodeHash tries very hard to uniquely identify the script by its (length, SHA2-256, SHA3-256) tuple
 	CodeHash ScriptHash
 
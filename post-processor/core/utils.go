@@ -18,23 +18,33 @@ func GetEnvDefault(key, def string) string {
 // ClosingReader attempts to close the underlying reader on EOF
 type ClosingReader struct {
 	reader io.Reader
+	closed bool
 }
 
 func (cr *ClosingReader) Read(p []byte) (int, error) {
 	n, err := cr.reader.Read(p)
-	if err != nil {
-		closer, ok := cr.reader.(io.Closer)
-		if ok {
-			cerr := closer.Close()
-			if cerr != nil {
-				// Log the failure but do not die/panic--continue back to caller
-				log.Print(cerr)
-			}
+	if err != nil && !cr.closed {
+		cerr := cr.Close()
+		if cerr != nil {
+			// Log the failure but do not die/panic--continue back to caller
+			log.Print(cerr)
 		}
 	}
 	return n, err
 }
 
+// Close closes the underlying reader (if it can be closed) early, e.g., when giving up before EOF; closing again is a no-op
+func (cr *ClosingReader) Close() error {
+	if cr.closed {
+		return nil
+	}
+	cr.closed = true
+	if closer, ok := cr.reader.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
 // NewClosingReader wraps an existing io.Reader to auto-close on EOF
 func NewClosingReader(rawReader io.Reader) *ClosingReader {
 	return &ClosingReader{reader: rawReader}
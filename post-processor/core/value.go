@@ -0,0 +1,172 @@
+package core
+
+// -------------------------------------------------------------------------------------
+// decoding of logged JS values (see "Data Types/Formats" in tests/README.md)
+// -------------------------------------------------------------------------------------
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ValueKind tags the JS type of a decoded log value
+type ValueKind string
+
+const (
+	// StringValue is a quoted JS string ("...")
+	StringValue ValueKind = "string"
+	// NumberValue is a JS number as V8 prints it (42, 3.1415926, 1e+21, NaN, -Infinity, ...)
+	NumberValue ValueKind = "number"
+	// BooleanValue is #T or #F
+	BooleanValue ValueKind = "boolean"
+	// NullValue is #N
+	NullValue ValueKind = "null"
+	// UndefinedValue is #U
+	UndefinedValue ValueKind = "undefined"
+	// ObjectValue is {Ctor} or {id,Ctor}
+	ObjectValue ValueKind = "object"
+	// FunctionValue is a bare function name, <anonymous>, or %native
+	FunctionValue ValueKind = "function"
+	// RegExpValue is /pattern/
+	RegExpValue ValueKind = "regexp"
+	// UnknownValue is anything VV8 (or we) could not make sense of (?, #?, empty, ...)
+	UnknownValue ValueKind = "unknown"
+)
+
+// Value is a single decoded JS value from a log field
+type Value struct {
+	Kind ValueKind
+
+	// String contents, regexp pattern, or function name (depending on Kind)
+	Text string
+
+	// Numeric value (NumberValue only)
+	Number float64
+
+	// Boolean value (BooleanValue only)
+	Bool bool
+
+	// Constructor name and object ID (ObjectValue only; ID is empty if not logged)
+	Ctor     string
+	ObjectID string
+
+	// Is this a native ("%"-prefixed) function? (FunctionValue only)
+	Native bool
+
+	// The field as logged
+	Raw string
+}
+
+// ParseValue decodes a single (already unescaped) log field into a tagged Value
+func ParseValue(raw string) Value {
+	v := Value{Kind: UnknownValue, Raw: raw}
+	switch {
+	case raw == "" || raw == "?" || raw == "#?":
+		// Unknown/unloggable
+	case raw == "#T" || raw == "#F":
+		v.Kind = BooleanValue
+		v.Bool = raw == "#T"
+	case raw == "#N":
+		v.Kind = NullValue
+	case raw == "#U":
+		v.Kind = UndefinedValue
+	case len(raw) >= 2 && raw[0] == '"' && raw[len(raw)-1] == '"':
+		v.Kind = StringValue
+		v.Text = raw[1 : len(raw)-1]
+	case len(raw) >= 2 && raw[0] == '{' && raw[len(raw)-1] == '}':
+		v.Kind = ObjectValue
+		v.Ctor, v.ObjectID = SplitReceiver(raw)
+	case len(raw) >= 2 && raw[0] == '/' && raw[len(raw)-1] == '/':
+		v.Kind = RegExpValue
+		v.Text = raw[1 : len(raw)-1]
+	case raw[0] == '#':
+		// Some oddball we do not know about
+	default:
+		if isJSNumber(raw) {
+			v.Kind = NumberValue
+			v.Number, _ = strconv.ParseFloat(raw, 64) // (out-of-range numbers come back as ±Inf)
+		} else {
+			v.Kind = FunctionValue
+			v.Text, v.Native = strings.CutPrefix(raw, "%")
+		}
+	}
+	return v
+}
+
+// isJSNumber tells if a field is a number as V8 prints one: NaN, [-]Infinity, or [-]digits[.digits][e(+|-)digits]
+// (ParseFloat alone would also take function names like "inf" or "nan", hex floats, "+1", ".5", etc.)
+func isJSNumber(raw string) bool {
+	if raw == "NaN" {
+		return true
+	}
+	raw = strings.TrimPrefix(raw, "-")
+	if raw == "Infinity" {
+		return true
+	}
+	digits := func() bool {
+		n := 0
+		for n < len(raw) && raw[n] >= '0' && raw[n] <= '9' {
+			n++
+		}
+		raw = raw[n:]
+		return n > 0
+	}
+	if !digits() {
+		return false
+	}
+	if strings.HasPrefix(raw, ".") {
+		raw = raw[1:]
+		if !digits() {
+			return false
+		}
+	}
+	if strings.HasPrefix(raw, "e+") || strings.HasPrefix(raw, "e-") {
+		raw = raw[2:]
+		if !digits() {
+			return false
+		}
+	}
+	return raw == ""
+}
+
+// ParseValues decodes a list of log fields (e.g., call arguments)
+func ParseValues(raws []string) []Value {
+	values := make([]Value, len(raws))
+	for i, raw := range raws {
+		values[i] = ParseValue(raw)
+	}
+	return values
+}
+
+// MarshalJSON renders a Value as a small {"type": ..., ...} JSON object
+func (v Value) MarshalJSON() ([]byte, error) {
+	doc := JSONObject{"type": v.Kind}
+	switch v.Kind {
+	case StringValue:
+		doc["value"] = v.Text
+	case NumberValue:
+		if math.IsNaN(v.Number) || math.IsInf(v.Number, 0) {
+			// Not representable as a JSON number; keep what was logged
+			doc["value"] = v.Raw
+		} else {
+			doc["value"] = v.Number
+		}
+	case BooleanValue:
+		doc["value"] = v.Bool
+	case ObjectValue:
+		doc["ctor"] = v.Ctor
+		if v.ObjectID != "" {
+			doc["id"] = v.ObjectID
+		}
+	case FunctionValue:
+		doc["name"] = v.Text
+		doc["native"] = v.Native
+	case RegExpValue:
+		doc["pattern"] = v.Text
+	case UnknownValue:
+		doc["raw"] = v.Raw
+	}
+	return json.Marshal(doc)
+}
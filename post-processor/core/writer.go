@@ -0,0 +1,106 @@
+package core
+
+// -------------------------------------------------------------------------------------
+// writing VV8 logs (see "Record Types/Formats" in tests/README.md), for tools that produce logs rather than consume them
+// -------------------------------------------------------------------------------------
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+)
+
+// LogWriter serializes records in the exact VV8 log text format (one record per line, fields escaped by EncodeField)
+type LogWriter struct {
+	out *bufio.Writer
+}
+
+// NewLogWriter creates a (buffered; remember to Flush) LogWriter over a stream
+func NewLogWriter(out io.Writer) *LogWriter {
+	return &LogWriter{out: bufio.NewWriter(out)}
+}
+
+// WriteRecord writes any record, given its code and (decoded) fields
+func (lw *LogWriter) WriteRecord(code byte, fields ...string) error {
+	lw.out.WriteByte(code)
+	lw.out.WriteString(EncodeFields(fields))
+	return lw.out.WriteByte('\n')
+}
+
+// WriteIsolate writes a '~' record, switching to the isolate with the given "pointer" string
+func (lw *LogWriter) WriteIsolate(id string) error {
+	return lw.WriteRecord('~', id)
+}
+
+// WriteOrigin writes an '@' record, switching the current isolate's security origin (the token is left off if empty)
+func (lw *LogWriter) WriteOrigin(origin, securityToken string) error {
+	if securityToken == "" {
+		return lw.WriteRecord('@', quote(origin))
+	}
+	return lw.WriteRecord('@', quote(origin), quote(securityToken))
+}
+
+// WriteScript writes a '$' record defining a script loaded from a URL (or an empty name, for VisibleV8's own scripts)
+func (lw *LogWriter) WriteScript(id int, url string, code string) error {
+	return lw.WriteRecord('$', strconv.Itoa(id), quote(url), code)
+}
+
+// WriteEvalScript writes a '$' record defining a script eval'd (or otherwise generated) by another script
+func (lw *LogWriter) WriteEvalScript(id int, parentID int, code string) error {
+	return lw.WriteRecord('$', strconv.Itoa(id), strconv.Itoa(parentID), code)
+}
+
+// WriteContext writes a '!' record, switching the current isolate's active script
+func (lw *LogWriter) WriteContext(scriptID int) error {
+	return lw.WriteRecord('!', strconv.Itoa(scriptID))
+}
+
+// WriteAccess writes a trace record ('c', 'n', 'g', 's', or 'j') from its fields, exactly as ParseRecord got them
+func (lw *LogWriter) WriteAccess(rec *Record) error {
+	return lw.WriteRecord(rec.Op, rec.Fields...)
+}
+
+// Flush writes out any buffered records
+func (lw *LogWriter) Flush() error {
+	return lw.out.Flush()
+}
+
+// quote flanks a JS string with double quotes, as VV8 logs it (internal quotes are NOT escaped)
+func quote(s string) string {
+	return `"` + s + `"`
+}
+
+// FormatValue is the inverse of ParseValue: it renders a Value as a (decoded) log field
+func FormatValue(v Value) string {
+	switch v.Kind {
+	case StringValue:
+		return quote(v.Text)
+	case NumberValue:
+		if v.Raw != "" {
+			return v.Raw // (keep iostream formatting, NaN/Infinity, etc., as logged)
+		}
+		return strconv.FormatFloat(v.Number, 'g', -1, 64)
+	case BooleanValue:
+		if v.Bool {
+			return "#T"
+		}
+		return "#F"
+	case NullValue:
+		return "#N"
+	case UndefinedValue:
+		return "#U"
+	case ObjectValue:
+		if v.ObjectID != "" {
+			return "{" + v.ObjectID + "," + v.Ctor + "}"
+		}
+		return "{" + v.Ctor + "}"
+	case FunctionValue:
+		if v.Native {
+			return "%" + v.Text
+		}
+		return v.Text
+	case RegExpValue:
+		return "/" + v.Text + "/"
+	}
+	return v.Raw // (unknown values are kept as logged)
+}
@@ -10,7 +10,6 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"strconv"
 	"strings"
 
 	"github.com/lib/pq"
@@ -47,33 +46,20 @@ func NewCreateElementAggregator() (core.Aggregator, error) {
 
 // IngestRecord parses a trace record, looking for document.createElement calls to track
 func (agg *CreateElementAggregator) IngestRecord(ctx *core.ExecutionContext, lineNumber int, op byte, fields []string) error {
-	if (op == 'c') && (ctx.Script != nil) && !ctx.Script.VisibleV8 && (ctx.Origin.Origin != "") {
-		offset, err := strconv.Atoi(fields[0])
-		if err != nil {
-			return fmt.Errorf("%d: invalid script offset '%s'", lineNumber, fields[0])
-		}
-
-		var rcvr, name string
-
-		// only getters and callers are interesting
-		switch op {
-		case 'c':
-			rcvr, _ = core.StripCurlies(fields[2])
-			name, _ = core.StripQuotes(fields[1])
+	return core.IngestAsRecord(agg, ctx, lineNumber, op, fields)
+}
 
-			name = strings.TrimPrefix(name, "%")
-		default: // ignore, we don't care about other ops
-		}
+// IngestTypedRecord looks for document.createElement calls (and their tag-name arguments) in a parsed trace record
+func (agg *CreateElementAggregator) IngestTypedRecord(ctx *core.ExecutionContext, rec *core.Record) error {
+	// only callers are interesting
+	if (rec.Op == 'c') && (ctx.Script != nil) && !ctx.Script.VisibleV8 && (ctx.Origin.Origin != "") {
+		rcvr, name := rec.Receiver, rec.Member
 
 		// We have some names (V8 special cases, numeric indices) that are never useful
 		if core.FilterName(name) {
 			return nil
 		}
 
-		if strings.Contains(rcvr, ",") {
-			rcvr = strings.Split(rcvr, ",")[1]
-		}
-
 		// Normalize IDL names
 		fullName, err := agg.idl.NormalizeMember(rcvr, name)
 		if err != nil {
@@ -81,15 +67,15 @@ func (agg *CreateElementAggregator) IngestRecord(ctx *core.ExecutionContext, lin
 		}
 
 		// FINALLY: if we are CALLING "Document.createElement", record the value of the first argument
-		if fullName == "HTMLDocument.createElement" {
-			tagName, ok := core.StripQuotes(fields[3])
-			log.Printf("op=%c, rcvr=%s, name=%s, fullName=%s, \n", op, rcvr, name, fullName)
+		if fullName == "HTMLDocument.createElement" && len(rec.Args) > 0 {
+			tagName, ok := core.StripQuotes(rec.Args[0])
+			log.Printf("op=%c, rcvr=%s, name=%s, fullName=%s, \n", rec.Op, rcvr, name, fullName)
 
-			for _, field := range fields {
+			for _, field := range rec.Fields {
 				log.Printf("field: %s\n", field)
 			}
 			if ok {
-				cite := originCallsite{ctx.Origin.Origin, ctx.Script, offset}
+				cite := originCallsite{ctx.Origin.Origin, ctx.Script, rec.Offset}
 				tagSet := agg.tagMap[cite]
 				if tagSet == nil {
 					tagSet = make(map[string]int)
@@ -216,3 +202,58 @@ func (agg *CreateElementAggregator) DumpToPostgresql(ctx *core.AggregationContex
 	}
 	return nil
 }
+
+// elementCreationTable is the table equivalent of create_elements (elementCreationFields)
+var elementCreationTable = &core.Table{
+	Name: "create_elements",
+	Columns: []core.Column{
+		{Name: "logfile_id", Type: core.StringColumn},
+		{Name: "visit_domain", Type: core.StringColumn, Nullable: true},
+		{Name: "security_origin", Type: core.StringColumn},
+		{Name: "script_hash", Type: core.BytesColumn},
+		{Name: "script_offset", Type: core.Int64Column},
+		{Name: "tag_name", Type: core.StringColumn},
+		{Name: "create_count", Type: core.Int64Column},
+	},
+}
+
+// DumpToTables writes create-element tuple records as table rows
+func (agg *CreateElementAggregator) DumpToTables(ctx *core.AggregationContext, sink core.TableSink) error {
+	if ctx.Formats["create_element"] {
+		logID := ctx.Ln.ID.String()
+		visitDomain := core.NullableString(ctx.RootDomain)
+		for cite, tagSet := range agg.tagMap {
+			for tagName, tagCount := range tagSet {
+				err := sink.WriteRow(elementCreationTable,
+					logID, visitDomain, cite.Origin, cite.Script.CodeHash.SHA2[:], cite.Offset, tagName, tagCount)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// elementCreationCopyTable is the COPY-file equivalent of create_elements
+var elementCreationCopyTable = &core.CopyTable{Name: "create_elements", Fields: elementCreationFields[:]}
+
+// CopyTables lists the COPY files written by DumpToCopy
+var CopyTables = []*core.CopyTable{elementCreationCopyTable}
+
+// DumpToCopy writes the create_elements rows DumpToPostgresql would insert
+func (agg *CreateElementAggregator) DumpToCopy(ctx *core.AggregationContext, sink core.CopySink) error {
+	if ctx.Formats["create_element"] {
+		logID := ctx.Ln.ID.String()
+		for cite, tagSet := range agg.tagMap {
+			for tagName, tagCount := range tagSet {
+				err := sink.CopyRow(elementCreationCopyTable,
+					logID, ctx.RootDomain, cite.Origin, cite.Script.CodeHash.SHA2[:], cite.Offset, tagName, tagCount)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
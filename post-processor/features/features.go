@@ -14,8 +14,6 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"strconv"
-	"strings"
 
 	"github.com/lib/pq"
 
@@ -36,7 +34,7 @@ type UsageInfo struct {
 	// Feature name (generally, RECEVER_CTOR_NAME.ITEM_NAME)
 	Name string
 
-	// Usage mode ('g' get, 'c' call, 's' set, 'n' new-style call)
+	// Usage mode ('g' get, 'c' call, 's' set, 'n' new-style call, 'j' "j" record API access of any kind)
 	Usage rune
 }
 
@@ -72,37 +70,27 @@ func NewFeatureUsageAggregator() (core.Aggregator, error) {
 
 // IngestRecord parses a trace/callsite record and aggregates API feature usage
 func (agg *FeatureUsageAggregator) IngestRecord(ctx *core.ExecutionContext, lineNumber int, op byte, fields []string) error {
+	return core.IngestAsRecord(agg, ctx, lineNumber, op, fields)
+}
+
+// IngestTypedRecord aggregates API feature usage from a parsed trace/callsite record
+func (agg *FeatureUsageAggregator) IngestTypedRecord(ctx *core.ExecutionContext, rec *core.Record) error {
 	if (ctx.Script != nil) && !ctx.Script.VisibleV8 && (ctx.Origin.Origin != "") {
-		offset, err := strconv.Atoi(fields[0])
-		if err != nil {
-			return fmt.Errorf("%d: invalid script offset '%s'", lineNumber, fields[0])
-		}
-
-		var rcvr, name, fullName string
-		switch op {
-		case 'g':
-			rcvr, _ = core.StripCurlies(fields[1])
-			name, _ = core.StripQuotes(fields[2])
-		case 'c':
-			rcvr, _ = core.StripCurlies(fields[2])
-			name, _ = core.StripQuotes(fields[1])
-			// Eliminate "native" prefix indicator from function names
-			name = strings.TrimPrefix(name, "%")
-		case 's':
-			rcvr, _ = core.StripCurlies(fields[1])
-			name, _ = core.StripQuotes(fields[2])
+		switch rec.Op {
+		case 'g', 'c', 's':
+		case 'j':
+			if rec.SubOp == 'n' {
+				return nil // (see below)
+			}
 		case 'n':
 			// Radical experiment: ignore all "new" records!
 			return nil
 		default:
 			// Oops--what was this?
-			log.Printf("%d: wat? %c: %v", lineNumber, op, fields)
+			log.Printf("%d: wat? %c: %v", rec.Line, rec.Op, rec.Fields)
 			return nil
 		}
-
-		if strings.Contains(rcvr, ",") {
-			rcvr = strings.Split(rcvr, ",")[1]
-		}
+		rcvr, name := rec.Receiver, rec.Member
 
 		// We have some names (V8 special cases, numeric indices) that are never useful
 		if core.FilterName(name) {
@@ -110,17 +98,17 @@ func (agg *FeatureUsageAggregator) IngestRecord(ctx *core.ExecutionContext, line
 		}
 
 		// Compensate for OOP-polymorphism by normalizing names to their base IDL interface (so we can detect callsite polymorphism)
-		fullName, err = agg.idl.NormalizeMember(rcvr, name)
+		fullName, err := agg.idl.NormalizeMember(rcvr, name)
 		if err != nil {
 			// Fall back to just the name as-is if normalization fails
 			fullName = fmt.Sprintf("%s.%s", rcvr, name)
 		}
 
 		// Stick it in our aggregation map (counting)
-		agg.usage[UsageInfo{ctx.Origin.Origin, ctx.Script, offset, fullName, rune(op)}]++
+		agg.usage[UsageInfo{ctx.Origin.Origin, ctx.Script, rec.Offset, fullName, rune(rec.Op)}]++
 
 		// And track callsite polymorphism (we break feature tuple sets into mono/poly morphic for different aggregation queries)
-		morphKey := callsite{ctx.Script, offset}
+		morphKey := callsite{ctx.Script, rec.Offset}
 		morphMap := agg.morphisms[morphKey]
 		if morphMap == nil {
 			morphMap = make(map[string]bool)
@@ -138,21 +126,34 @@ func (agg *FeatureUsageAggregator) IngestRecord(ctx *core.ExecutionContext, line
 }*/
 
 var scriptBlobFields = [...]string{
+	"logfile_id",
 	"script_hash",
 	"script_code",
 	"sha256sum",
 	"size",
 }
 
-func (agg *FeatureUsageAggregator) dumpBlobs(ln *core.LogInfo, sqlDb *sql.DB) error {
-	blobMap := make(map[core.ScriptHash]string)
+// blobScripts maps each distinct (non-VV8) script body of a log to one script carrying it
+func blobScripts(ln *core.LogInfo) map[core.ScriptHash]*core.ScriptInfo {
+	blobMap := make(map[core.ScriptHash]*core.ScriptInfo)
 	for _, iso := range ln.Isolates {
 		for _, script := range iso.Scripts {
 			if !script.VisibleV8 {
-				blobMap[script.CodeHash] = script.Code
+				blobMap[script.CodeHash] = script
 			}
 		}
 	}
+	return blobMap
+}
+
+func (agg *FeatureUsageAggregator) dumpBlobs(ln *core.LogInfo, sqlDb *sql.DB) error {
+	// (Code is loaded one script at a time below, in case it was spilled to a script store)
+	blobMap := blobScripts(ln)
+
+	logID, err := ln.InsertLogfile(sqlDb)
+	if err != nil {
+		return err
+	}
 
 	txn, err := sqlDb.Begin()
 	if err != nil {
@@ -166,9 +167,14 @@ func (agg *FeatureUsageAggregator) dumpBlobs(ln *core.LogInfo, sqlDb *sql.DB) er
 	}
 
 	log.Printf("blob: %d unique scripts to archive", len(blobMap))
-	for scriptHash, scriptCode := range blobMap {
+	for scriptHash, script := range blobMap {
+		scriptCode, err := script.Source()
+		if err != nil {
+			txn.Rollback()
+			return err
+		}
 		sha256sum := sha256.Sum256([]byte(scriptCode))
-		_, err := stmt.Exec(scriptHash.SHA2[:], scriptCode, sha256sum[:], len(scriptCode))
+		_, err = stmt.Exec(logID, scriptHash.SHA2[:], scriptCode, sha256sum[:], len(scriptCode))
 		if err != nil {
 			txn.Rollback()
 			return err
@@ -213,6 +219,39 @@ var scriptCreationFields = [...]string{
 	"first_origin",
 }
 
+// Table equivalents of feature_usage/poly_feature_usage (featureUsageFields) and script_creation (scriptCreationFields)
+var featureUsageTable, polyFeatureUsageTable = newFeatureUsageTable("feature_usage"), newFeatureUsageTable("poly_feature_usage")
+
+func newFeatureUsageTable(name string) *core.Table {
+	return &core.Table{
+		Name: name,
+		Columns: []core.Column{
+			{Name: "logfile_id", Type: core.StringColumn},
+			{Name: "visit_domain", Type: core.StringColumn, Nullable: true},
+			{Name: "security_origin", Type: core.StringColumn},
+			{Name: "script_hash", Type: core.BytesColumn},
+			{Name: "script_offset", Type: core.Int64Column},
+			{Name: "feature_name", Type: core.StringColumn},
+			{Name: "feature_use", Type: core.StringColumn},
+			{Name: "use_count", Type: core.Int64Column},
+		},
+	}
+}
+
+var scriptCreationTable = &core.Table{
+	Name: "script_creation",
+	Columns: []core.Column{
+		{Name: "logfile_id", Type: core.StringColumn},
+		{Name: "visit_domain", Type: core.StringColumn, Nullable: true},
+		{Name: "script_hash", Type: core.BytesColumn},
+		{Name: "script_url", Type: core.StringColumn, Nullable: true},
+		{Name: "eval_parent_hash", Type: core.BytesColumn, Nullable: true},
+		{Name: "isolate_ptr", Type: core.StringColumn},
+		{Name: "runtime_id", Type: core.Int64Column},
+		{Name: "first_origin", Type: core.StringColumn, Nullable: true},
+	},
+}
+
 type featureTupleRecord struct {
 	securityOrigin string
 	scriptHash     []byte
@@ -558,3 +597,129 @@ func (agg *FeatureUsageAggregator) DumpToStream(ctx *core.AggregationContext, st
 
 	return nil
 }
+
+// DumpToTables writes feature usage (mono/polymorphic callsites) and script creation rows (script blobs are not supported)
+func (agg *FeatureUsageAggregator) DumpToTables(ctx *core.AggregationContext, sink core.TableSink) error {
+	logID := ctx.Ln.ID.String()
+	visitDomain := core.NullableString(ctx.RootDomain)
+
+	for key, count := range agg.usage {
+		// Monomorphic and polymorphic callsites go to separate tables (as in Postgres)
+		var table *core.Table
+		if len(agg.morphisms[callsite{key.Script, key.Offset}]) < 2 {
+			if ctx.Formats["features"] {
+				table = featureUsageTable
+			}
+		} else if ctx.Formats["poly_features"] {
+			table = polyFeatureUsageTable
+		}
+		if table == nil {
+			continue
+		}
+		err := sink.WriteRow(table,
+			logID, visitDomain, key.Origin, key.Script.CodeHash.SHA2[:], key.Offset, key.Name, string(key.Usage), count)
+		if err != nil {
+			return err
+		}
+	}
+
+	if ctx.Formats["scripts"] {
+		records, err := agg.dumpScriptTuples(ctx.Ln)
+		if err != nil {
+			return err
+		}
+		for _, script := range records {
+			var parentHash, firstOrigin interface{}
+			if script.EvaledBy != nil {
+				parentHash = script.EvaledBy.CodeHash.SHA2[:]
+			}
+			if script.FirstOrigin != nil {
+				firstOrigin = script.FirstOrigin.Origin
+			}
+			err = sink.WriteRow(scriptCreationTable,
+				logID, visitDomain, script.CodeHash.SHA2[:], core.NullableString(script.URL), parentHash,
+				script.Isolate.ID, script.ID, firstOrigin)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if ctx.Formats["blobs"] {
+		log.Printf("WARNING: features: script blobs are not written to tables")
+	}
+	return nil
+}
+
+// COPY-file equivalents of what DumpToPostgresql copies into PG
+var (
+	featureUsageCopyTable     = &core.CopyTable{Name: "feature_usage", Fields: featureUsageFields[:]}
+	polyFeatureUsageCopyTable = &core.CopyTable{Name: "poly_feature_usage", Fields: featureUsageFields[:]}
+	scriptCreationCopyTable   = &core.CopyTable{Name: "script_creation", Fields: scriptCreationFields[:]}
+	scriptBlobCopyTable       = &core.CopyTable{Name: "script_blobs", Fields: scriptBlobFields[:]}
+)
+
+// CopyTables lists (in load order) the COPY files written by DumpToCopy
+var CopyTables = []*core.CopyTable{featureUsageCopyTable, polyFeatureUsageCopyTable, scriptCreationCopyTable, scriptBlobCopyTable}
+
+// DumpToCopy writes the rows DumpToPostgresql would insert (visit_domain coming from -rootdomain, not a PG lookup)
+func (agg *FeatureUsageAggregator) DumpToCopy(ctx *core.AggregationContext, sink core.CopySink) error {
+	logID := ctx.Ln.ID.String()
+
+	for key, count := range agg.usage {
+		// Monomorphic and polymorphic callsites go to separate tables (as in Postgres)
+		var table *core.CopyTable
+		if len(agg.morphisms[callsite{key.Script, key.Offset}]) < 2 {
+			if ctx.Formats["features"] {
+				table = featureUsageCopyTable
+			}
+		} else if ctx.Formats["poly_features"] {
+			table = polyFeatureUsageCopyTable
+		}
+		if table == nil {
+			continue
+		}
+		err := sink.CopyRow(table,
+			logID, ctx.RootDomain, key.Origin, key.Script.CodeHash.SHA2[:], key.Offset, key.Name, string(key.Usage), count)
+		if err != nil {
+			return err
+		}
+	}
+
+	if ctx.Formats["scripts"] {
+		records, err := agg.dumpScriptTuples(ctx.Ln)
+		if err != nil {
+			return err
+		}
+		for _, script := range records {
+			var parentHash, firstOrigin interface{}
+			if script.EvaledBy != nil {
+				parentHash = script.EvaledBy.CodeHash.SHA2[:]
+			}
+			if script.FirstOrigin != nil {
+				firstOrigin = script.FirstOrigin.Origin
+			}
+			err = sink.CopyRow(scriptCreationCopyTable,
+				logID, ctx.RootDomain, script.CodeHash.SHA2[:], core.NullableString(script.URL), parentHash,
+				script.Isolate.ID, script.ID, firstOrigin)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if ctx.Formats["blobs"] {
+		for scriptHash, script := range blobScripts(ctx.Ln) {
+			scriptCode, err := script.Source()
+			if err != nil {
+				return err
+			}
+			sha256sum := sha256.Sum256([]byte(scriptCode))
+			err = sink.CopyRow(scriptBlobCopyTable, logID, scriptHash.SHA2[:], scriptCode, sha256sum[:], len(scriptCode))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -6,8 +6,6 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"strconv"
-	"strings"
 
 	"github.com/lib/pq"
 	"github.com/wspr-ncsu/visiblev8/post-processor/core"
@@ -37,37 +35,23 @@ func NewAggregator() (core.Aggregator, error) {
 }
 
 func (agg *flowAggregator) IngestRecord(ctx *core.ExecutionContext, lineNumber int, op byte, fields []string) error {
+	return core.IngestAsRecord(agg, ctx, lineNumber, op, fields)
+}
+
+func (agg *flowAggregator) IngestTypedRecord(ctx *core.ExecutionContext, rec *core.Record) error {
 	if (ctx.Script != nil) && !ctx.Script.VisibleV8 && (ctx.Origin.Origin != "") {
-		offset, err := strconv.Atoi(fields[0])
-		if err != nil {
-			return fmt.Errorf("%d: invalid script offset '%s'", lineNumber, fields[0])
-		}
-		var receiver, member string
-		switch op {
-		case 'g', 's':
-			receiver, _ = core.StripCurlies(fields[1])
-			member, _ = core.StripQuotes(fields[2])
-		case 'n':
-			receiver, _ = core.StripCurlies(fields[1])
-			receiver = strings.TrimPrefix(receiver, "%")
-		case 'c':
-			receiver, _ = core.StripCurlies(fields[2])
-			member, _ = core.StripQuotes(fields[1])
-
-			member = strings.TrimPrefix(member, "%")
+		switch rec.Op {
+		case 'g', 's', 'n', 'c', 'j':
 		default:
-			return fmt.Errorf("%d: invalid mode '%c'; fields: %v", lineNumber, op, fields)
+			return fmt.Errorf("%d: invalid mode '%c'; fields: %v", rec.Line, rec.Op, rec.Fields)
 		}
+		receiver, member := rec.Receiver, rec.Member
 
 		if core.FilterName(member) {
 			// We have some names (V8 special cases, numeric indices) that are never useful
 			return nil
 		}
 
-		if strings.Contains(receiver, ",") {
-			receiver = strings.Split(receiver, ",")[1]
-		}
-
 		var fullName string
 		if member != "" {
 			fullName = fmt.Sprintf("%s.%s", receiver, member)
@@ -82,9 +66,13 @@ func (agg *flowAggregator) IngestRecord(ctx *core.ExecutionContext, lineNumber i
 			agg.scriptList[ctx.Script.ID] = script
 		}
 
-		currentAction := fmt.Sprint(offset) + string(',') + fullName
+		currentAction := fmt.Sprint(rec.Offset) + string(',') + fullName
+		if rec.Op == 'j' {
+			// Tag "j" record API accesses so they can be told apart from regular ones
+			currentAction += ",j"
+		}
 
-		if agg.lastAction == currentAction && op == 'c' {
+		if agg.lastAction == currentAction && rec.Op == 'c' {
 			return nil
 		}
 
@@ -95,6 +83,7 @@ func (agg *flowAggregator) IngestRecord(ctx *core.ExecutionContext, lineNumber i
 }
 
 var scriptFlowFields = [...]string{
+	"logfile_id",
 	"isolate",
 	"visiblev8",
 	"code",
@@ -106,6 +95,10 @@ var scriptFlowFields = [...]string{
 }
 
 func (agg *flowAggregator) DumpToPostgresql(ctx *core.AggregationContext, sqlDb *sql.DB) error {
+	logID, err := ctx.Ln.InsertLogfile(sqlDb)
+	if err != nil {
+		return err
+	}
 
 	txn, err := sqlDb.Begin()
 	if err != nil {
@@ -128,10 +121,17 @@ func (agg *flowAggregator) DumpToPostgresql(ctx *core.AggregationContext, sqlDb
 			evaledById = evaledBy.ID
 		}
 
+		code, err := script.info.Source()
+		if err != nil {
+			txn.Rollback()
+			return err
+		}
+
 		_, err = stmt.Exec(
+			logID,
 			script.info.Isolate.ID,
 			script.info.VisibleV8,
-			script.info.Code,
+			code,
 			script.info.CodeHash.SHA2[:],
 			script.info.URL,
 			evaledById,
@@ -158,6 +158,36 @@ func (agg *flowAggregator) DumpToPostgresql(ctx *core.AggregationContext, sqlDb
 	return nil
 }
 
+// scriptFlowCopyTable is the COPY-file equivalent of script_flow
+var scriptFlowCopyTable = &core.CopyTable{Name: "script_flow", Fields: scriptFlowFields[:]}
+
+// CopyTables lists the COPY files written by DumpToCopy
+var CopyTables = []*core.CopyTable{scriptFlowCopyTable}
+
+// DumpToCopy writes the script_flow rows DumpToPostgresql would insert
+func (agg *flowAggregator) DumpToCopy(ctx *core.AggregationContext, sink core.CopySink) error {
+	logID := ctx.Ln.ID.String()
+	for _, script := range agg.scriptList {
+		evaledById := -1
+		if script.info.EvaledBy != nil {
+			evaledById = script.info.EvaledBy.ID
+		}
+
+		code, err := script.info.Source()
+		if err != nil {
+			return err
+		}
+
+		err = sink.CopyRow(scriptFlowCopyTable,
+			logID, script.info.Isolate.ID, script.info.VisibleV8, code, script.info.CodeHash.SHA2[:], script.info.URL,
+			evaledById, pq.Array(script.APIs), script.info.FirstOrigin.Origin)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (agg *flowAggregator) DumpToStream(ctx *core.AggregationContext, stream io.Writer) error {
 	jstream := json.NewEncoder(stream)
 
@@ -169,11 +199,16 @@ func (agg *flowAggregator) DumpToStream(ctx *core.AggregationContext, stream io.
 			evaledById = evaledBy.ID
 		}
 
+		code, err := script.info.Source()
+		if err != nil {
+			return err
+		}
+
 		jstream.Encode(core.JSONArray{"script_flow", core.JSONObject{
 			"ID":          script.info.ID,
 			"Isolate":     script.info.Isolate.ID,
 			"IsVisibleV8": script.info.VisibleV8,
-			"Code":        script.info.Code,
+			"Code":        code,
 			"URL":         script.info.URL,
 			"IsEvaledBy":  evaledById,
 			"FirstOrigin": script.info.FirstOrigin,
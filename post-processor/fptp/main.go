@@ -82,7 +82,79 @@ func (agg *fptpAggregator) accessEntityPropertyMap(origin string) (*EntityProper
 	return entity, nil
 }
 
+// setRootDomain looks up (once) the entity property of the visited page's domain
+func (agg *fptpAggregator) setRootDomain(rootDomain string) error {
+	if agg.firstPartyProperty == nil {
+
+		rootURL, err := url.Parse(rootDomain)
+
+		if err != nil {
+			return err
+		}
+
+		rootURLOrigin := rootURL.Hostname()
+
+		agg.firstPartyProperty, err = agg.accessEntityPropertyMap(rootURLOrigin)
+		if err != nil {
+			agg.firstPartyProperty = &EntityProperty{
+				DisplayName: rootURLOrigin,
+				Tracking:    0.0,
+			}
+			agg.eMap.EntityPropertyMap[rootURLOrigin] = agg.firstPartyProperty
+		}
+	}
+	return nil
+}
+
+// scriptProperties looks up the entity properties of a script's URL and first origin (stand-ins are made for unknown hosts)
+func (agg *fptpAggregator) scriptProperties(script *Script) (*EntityProperty, *EntityProperty, error) {
+	domain, _ := split(script.info.URL, '/', 3)
+	scriptURL, err := url.Parse(domain)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	domain, _ = split(firstOrigin(script), '/', 3)
+
+	originURL, err := url.Parse(domain)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	scriptURLOrigin := scriptURL.Hostname()
+	originURLOrigin := originURL.Hostname()
+
+	scriptProperty, err := agg.accessEntityPropertyMap(scriptURLOrigin)
+	if err != nil {
+		scriptProperty = &EntityProperty{
+			DisplayName: scriptURLOrigin,
+			Tracking:    0.0,
+		}
+		agg.eMap.EntityPropertyMap[scriptURLOrigin] = scriptProperty
+	}
+	originProperty, err := agg.accessEntityPropertyMap(originURLOrigin)
+	if err != nil {
+		originProperty = &EntityProperty{
+			DisplayName: originURLOrigin,
+			Tracking:    0.0,
+		}
+		agg.eMap.EntityPropertyMap[originURLOrigin] = originProperty
+	}
+	return scriptProperty, originProperty, nil
+}
+
+// firstOrigin gives the origin active when a script was loaded ("" if none was)
+func firstOrigin(script *Script) string {
+	if script.info.FirstOrigin == nil {
+		return ""
+	}
+	return script.info.FirstOrigin.Origin
+}
+
 var firstPartyThirdPartyFields = [...]string{
+	"logfile_id",
 	"sha2",
 	"root_domain",
 	"url",
@@ -104,24 +176,13 @@ func (agg *fptpAggregator) DumpToPostgresql(ctx *core.AggregationContext, sqlDb
 		return err
 	}
 
-	if agg.firstPartyProperty == nil {
-
-		rootURL, err := url.Parse(rootDomain)
-
-		if err != nil {
-			return err
-		}
-
-		rootURLOrigin := rootURL.Hostname()
+	if err = agg.setRootDomain(rootDomain); err != nil {
+		return err
+	}
 
-		agg.firstPartyProperty, err = agg.accessEntityPropertyMap(rootURLOrigin)
-		if err != nil {
-			agg.firstPartyProperty = &EntityProperty{
-				DisplayName: rootURLOrigin,
-				Tracking:    0.0,
-			}
-			agg.eMap.EntityPropertyMap[rootURLOrigin] = agg.firstPartyProperty
-		}
+	logID, err := ctx.Ln.InsertLogfile(sqlDb)
+	if err != nil {
+		return err
 	}
 
 	txn, err := sqlDb.Begin()
@@ -138,47 +199,18 @@ func (agg *fptpAggregator) DumpToPostgresql(ctx *core.AggregationContext, sqlDb
 	log.Printf("firstPartyThirdParty: %d scripts analysed", len(agg.scriptList))
 
 	for _, script := range agg.scriptList {
-		domain, _ := split(script.info.URL, '/', 3)
-		scriptURL, err := url.Parse(domain)
-
+		scriptProperty, originProperty, err := agg.scriptProperties(script)
 		if err != nil {
 			return err
 		}
-
-		domain, _ = split(script.info.FirstOrigin.Origin, '/', 3)
-
-		originURL, err := url.Parse(domain)
-
-		if err != nil {
-			return err
-		}
-
-		scriptURLOrigin := scriptURL.Hostname()
-		originURLOrigin := originURL.Hostname()
-
-		scriptProperty, err := agg.accessEntityPropertyMap(scriptURLOrigin)
-		if err != nil {
-			scriptProperty = &EntityProperty{
-				DisplayName: scriptURLOrigin,
-				Tracking:    0.0,
-			}
-			agg.eMap.EntityPropertyMap[scriptURLOrigin] = scriptProperty
-		}
 		tracking := scriptProperty.Tracking
-		originProperty, err := agg.accessEntityPropertyMap(originURLOrigin)
-		if err != nil {
-			originProperty = &EntityProperty{
-				DisplayName: originURLOrigin,
-				Tracking:    0.0,
-			}
-			agg.eMap.EntityPropertyMap[originURLOrigin] = originProperty
-		}
 
 		_, err = stmt.Exec(
+			logID,
 			script.info.CodeHash.SHA2[:],
 			rootDomain,
 			script.info.URL,
-			script.info.FirstOrigin.Origin,
+			firstOrigin(script),
 			agg.firstPartyProperty.DisplayName,
 			scriptProperty.DisplayName,
 			originProperty.DisplayName,
@@ -257,3 +289,104 @@ func (agg *fptpAggregator) DumpToStream(ctx *core.AggregationContext, stream io.
 
 	return nil
 }
+
+// firstPartyThirdPartyTable is the table equivalent of thirdpartyfirstparty (firstPartyThirdPartyFields)
+var firstPartyThirdPartyTable = &core.Table{
+	Name: "thirdpartyfirstparty",
+	Columns: []core.Column{
+		{Name: "logfile_id", Type: core.StringColumn},
+		{Name: "sha2", Type: core.BytesColumn},
+		{Name: "root_domain", Type: core.StringColumn, Nullable: true},
+		{Name: "url", Type: core.StringColumn},
+		{Name: "first_origin", Type: core.StringColumn},
+		{Name: "property_of_root_domain", Type: core.StringColumn, Nullable: true},
+		{Name: "property_of_first_origin", Type: core.StringColumn},
+		{Name: "property_of_script", Type: core.StringColumn},
+		{Name: "is_script_third_party_with_root_domain", Type: core.BoolColumn, Nullable: true},
+		{Name: "is_script_third_party_with_first_origin", Type: core.BoolColumn},
+		{Name: "script_origin_tracking_value", Type: core.Float64Column},
+	},
+}
+
+// DumpToTables writes a thirdpartyfirstparty row per script (root-domain columns are null without -rootdomain)
+func (agg *fptpAggregator) DumpToTables(ctx *core.AggregationContext, sink core.TableSink) error {
+	if ctx.RootDomain != "" {
+		if err := agg.setRootDomain(ctx.RootDomain); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("firstPartyThirdParty: %d scripts analysed", len(agg.scriptList))
+
+	for _, script := range agg.scriptList {
+		scriptProperty, originProperty, err := agg.scriptProperties(script)
+		if err != nil {
+			return err
+		}
+
+		var rootProperty, rootThirdParty interface{}
+		if agg.firstPartyProperty != nil {
+			rootProperty = agg.firstPartyProperty.DisplayName
+			rootThirdParty = scriptProperty.DisplayName != agg.firstPartyProperty.DisplayName
+		}
+		err = sink.WriteRow(firstPartyThirdPartyTable,
+			ctx.Ln.ID.String(),
+			script.info.CodeHash.SHA2[:],
+			core.NullableString(ctx.RootDomain),
+			script.info.URL,
+			firstOrigin(script),
+			rootProperty,
+			originProperty.DisplayName,
+			scriptProperty.DisplayName,
+			rootThirdParty,
+			scriptProperty.DisplayName != originProperty.DisplayName,
+			scriptProperty.Tracking,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// firstPartyThirdPartyCopyTable is the COPY-file equivalent of thirdpartyfirstparty
+var firstPartyThirdPartyCopyTable = &core.CopyTable{Name: "thirdpartyfirstparty", Fields: firstPartyThirdPartyFields[:]}
+
+// CopyTables lists the COPY files written by DumpToCopy
+var CopyTables = []*core.CopyTable{firstPartyThirdPartyCopyTable}
+
+// DumpToCopy writes the thirdpartyfirstparty rows DumpToPostgresql would insert (the root domain coming from -rootdomain)
+func (agg *fptpAggregator) DumpToCopy(ctx *core.AggregationContext, sink core.CopySink) error {
+	if err := agg.setRootDomain(ctx.RootDomain); err != nil {
+		return err
+	}
+
+	log.Printf("firstPartyThirdParty: %d scripts analysed", len(agg.scriptList))
+
+	for _, script := range agg.scriptList {
+		scriptProperty, originProperty, err := agg.scriptProperties(script)
+		if err != nil {
+			return err
+		}
+
+		err = sink.CopyRow(firstPartyThirdPartyCopyTable,
+			ctx.Ln.ID.String(),
+			script.info.CodeHash.SHA2[:],
+			ctx.RootDomain,
+			script.info.URL,
+			firstOrigin(script),
+			agg.firstPartyProperty.DisplayName,
+			scriptProperty.DisplayName,
+			originProperty.DisplayName,
+			scriptProperty.DisplayName != originProperty.DisplayName,
+			scriptProperty.DisplayName != agg.firstPartyProperty.DisplayName,
+			scriptProperty.Tracking,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
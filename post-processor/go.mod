@@ -2,7 +2,11 @@ module github.com/wspr-ncsu/visiblev8/post-processor
 
 require (
 	github.com/google/uuid v1.6.0
+	github.com/klauspost/compress v1.17.8
 	github.com/lib/pq v1.10.9
+	github.com/mattn/go-sqlite3 v1.14.33
+	github.com/ulikunitz/xz v0.5.12
+	github.com/xitongsys/parquet-go v1.6.2
 	github.com/yaricom/goGraphML v1.4.3
 	go.mongodb.org/mongo-driver v1.15.0
 	golang.org/x/crypto v0.45.0
@@ -11,9 +15,11 @@ require (
 )
 
 require (
+	github.com/apache/arrow/go/arrow v0.0.0-20200730104253-651201b0f516 // indirect
+	github.com/apache/thrift v0.14.2 // indirect
 	github.com/golang/snappy v0.0.4 // indirect
-	github.com/klauspost/compress v1.17.8 // indirect
 	github.com/montanaflynn/stats v0.7.1 // indirect
+	github.com/pierrec/lz4/v4 v4.1.8 // indirect
 	github.com/xdg-go/pbkdf2 v1.0.0 // indirect
 	github.com/xdg-go/scram v1.1.2 // indirect
 	github.com/xdg-go/stringprep v1.0.4 // indirect
@@ -21,6 +27,7 @@ require (
 	golang.org/x/sync v0.18.0 // indirect
 	golang.org/x/sys v0.38.0 // indirect
 	golang.org/x/text v0.31.0 // indirect
+	golang.org/x/xerrors v0.0.0-20191204190536-9bdfabe68543 // indirect
 )
 
 go 1.24.0
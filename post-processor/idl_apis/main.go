@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"log"
 	"strconv"
-	"strings"
 
 	"github.com/lib/pq"
 	"github.com/wspr-ncsu/visiblev8/post-processor/core"
@@ -28,37 +27,23 @@ func NewAggregator() (core.Aggregator, error) {
 }
 
 func (agg *idlApisAggregator) IngestRecord(ctx *core.ExecutionContext, lineNumber int, op byte, fields []string) error {
+	return core.IngestAsRecord(agg, ctx, lineNumber, op, fields)
+}
+
+func (agg *idlApisAggregator) IngestTypedRecord(ctx *core.ExecutionContext, rec *core.Record) error {
 	if (ctx.Script != nil) && !ctx.Script.VisibleV8 && (ctx.Origin.Origin != "") {
-		offset, err := strconv.Atoi(fields[0])
-		if err != nil {
-			return fmt.Errorf("%d: invalid script offset '%s'", lineNumber, fields[0])
-		}
-		var receiver, member string
-		switch op {
-		case 'g', 's':
-			receiver, _ = core.StripCurlies(fields[1])
-			member, _ = core.StripQuotes(fields[2])
-		case 'n':
-			receiver, _ = core.StripCurlies(fields[1])
-			receiver = strings.TrimPrefix(receiver, "%")
-		case 'c':
-			receiver, _ = core.StripCurlies(fields[2])
-			member, _ = core.StripQuotes(fields[1])
-
-			member = strings.TrimPrefix(member, "%")
+		switch rec.Op {
+		case 'g', 's', 'n', 'c', 'j':
 		default:
-			return fmt.Errorf("%d: invalid mode '%c'; fields: %v", lineNumber, op, fields)
+			return fmt.Errorf("%d: invalid mode '%c'; fields: %v", rec.Line, rec.Op, rec.Fields)
 		}
+		receiver, member := rec.Receiver, rec.Member
 
 		if core.FilterName(member) {
 			// We have some names (V8 special cases, numeric indices) that are never useful
 			return nil
 		}
 
-		if strings.Contains(receiver, ",") {
-			receiver = strings.Split(receiver, ",")[1]
-		}
-
 		fullName, err := agg.idlTree.NormalizeMember(receiver, member)
 		if err != nil {
 			if member != "" {
@@ -67,6 +52,11 @@ func (agg *idlApisAggregator) IngestRecord(ctx *core.ExecutionContext, lineNumbe
 				fullName = receiver
 			}
 		}
+		op := rec.Op
+		if op == 'j' {
+			// IDL lookups care about the kind of access ("j" records are flagged in the script list below, though)
+			op = rec.SubOp
+		}
 		if !agg.idlTree.IsAPIInIDLFile(op, receiver, member) {
 			scriptData, ok := agg.APIs[fullName]
 			if !ok {
@@ -75,16 +65,16 @@ func (agg *idlApisAggregator) IngestRecord(ctx *core.ExecutionContext, lineNumbe
 			URL := ``
 			if ctx.Script.URL != "" {
 				URL = ctx.Script.URL
-			} else {
+			} else if ctx.Script.EvaledBy != nil {
 				URL = ctx.Script.EvaledBy.URL
 			}
 			Origin := ``
-			if ctx.Script.FirstOrigin.Origin != "" {
+			if ctx.Script.FirstOrigin != nil && ctx.Script.FirstOrigin.Origin != "" {
 				Origin = ctx.Script.FirstOrigin.Origin
-			} else {
+			} else if ctx.Script.EvaledBy != nil && ctx.Script.EvaledBy.FirstOrigin != nil {
 				Origin = ctx.Script.EvaledBy.FirstOrigin.Origin
 			}
-			scriptData = append(scriptData, fmt.Sprintf("%c %s %s %s", op, strconv.Itoa(offset), URL, Origin))
+			scriptData = append(scriptData, fmt.Sprintf("%c %s %s %s", rec.Op, strconv.Itoa(rec.Offset), URL, Origin))
 			agg.APIs[fullName] = scriptData
 		}
 	}
@@ -152,3 +142,20 @@ func (agg *idlApisAggregator) DumpToPostgresql(ctx *core.AggregationContext, sql
 
 	return nil
 }
+
+// idlApisCopyTable is the COPY-file equivalent of idlapis
+var idlApisCopyTable = &core.CopyTable{Name: "idlapis", Fields: idlApisApiFields[:]}
+
+// CopyTables lists the COPY files written by DumpToCopy
+var CopyTables = []*core.CopyTable{idlApisCopyTable}
+
+// DumpToCopy writes the idlapis rows DumpToPostgresql would insert (root_domain being resolved on loading)
+func (agg *idlApisAggregator) DumpToCopy(ctx *core.AggregationContext, sink core.CopySink) error {
+	logID := ctx.Ln.ID.String()
+	for api, scriptList := range agg.APIs {
+		if err := sink.CopyRow(idlApisCopyTable, api, pq.Array(scriptList), logID, ctx.RootDomain); err != nil {
+			return err
+		}
+	}
+	return nil
+}
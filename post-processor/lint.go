@@ -0,0 +1,179 @@
+package main
+
+// ---------------------------------------------------------------------------
+// log linting ("-lint"): run logs through IngestStream's context state machine (leniently, checking escapes too)
+// and report every anomaly found, as JSON, instead of aggregating
+// ---------------------------------------------------------------------------
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/wspr-ncsu/visiblev8/post-processor/core"
+)
+
+// lintMaxProblems caps the problems listed per log (the per-kind counts stay complete)
+const lintMaxProblems = 1000
+
+// lintProblem is one anomaly found in a log
+type lintProblem struct {
+	Line   int              `json:"line"` // (counted across all segments of the log; 0 if not tied to a line)
+	Kind   core.AnomalyKind `json:"kind"`
+	Detail string           `json:"detail"`
+}
+
+// lintReport is the verdict on one log (cluster of segments)
+type lintReport struct {
+	Log               string                   `json:"log"`
+	Segments          []string                 `json:"segments"`
+	Lines             int                      `json:"lines"`
+	Bytes             int64                    `json:"bytes"`
+	OK                bool                     `json:"ok"`
+	Counts            map[core.AnomalyKind]int `json:"counts"`
+	Problems          []lintProblem            `json:"problems"`
+	ProblemsTruncated bool                     `json:"problems_truncated,omitempty"`
+	Error             string                   `json:"error,omitempty"` // (log could not be read to the end)
+}
+
+// linter tallies verdicts across all logs linted (possibly concurrently)
+type linter struct {
+	allow map[core.AnomalyKind]bool // kinds reported, but not counted as failures
+
+	lock   sync.Mutex
+	logs   int
+	failed int
+}
+
+// parseLintAllow parses a comma-separated list of anomaly kinds to tolerate
+func parseLintAllow(raw string) (map[core.AnomalyKind]bool, error) {
+	allow := make(map[core.AnomalyKind]bool)
+	if raw == "" {
+		return allow, nil
+	}
+	for _, kind := range strings.Split(raw, ",") {
+		if !knownAnomalyKinds[core.AnomalyKind(kind)] {
+			return nil, fmt.Errorf("unknown anomaly kind '%s'", kind)
+		}
+		allow[core.AnomalyKind(kind)] = true
+	}
+	return allow, nil
+}
+
+// knownAnomalyKinds lists every kind of anomaly the linter can report
+var knownAnomalyKinds = map[core.AnomalyKind]bool{
+	core.AnomalyMissingIsolate:  true,
+	core.AnomalyMissingOrigin:   true,
+	core.AnomalyOrphanEval:      true,
+	core.AnomalyUnknownScript:   true,
+	core.AnomalyRedefinedScript: true,
+	core.AnomalyShortRecord:     true,
+	core.AnomalyBadRecord:       true,
+	core.AnomalyUnknownRecord:   true,
+	core.AnomalyTruncatedLine:   true,
+	core.AnomalyBadEscape:       true,
+	core.AnomalySegmentGap:      true,
+}
+
+// lintFailure is returned by invoke when any log failed linting
+type lintFailure struct {
+	failed, total int
+}
+
+func (lf *lintFailure) Error() string {
+	return fmt.Sprintf("lint: %d of %d log(s) have problems", lf.failed, lf.total)
+}
+
+// verdict returns the overall outcome once all logs are linted (nil if all passed)
+func (lt *linter) verdict() error {
+	lt.lock.Lock()
+	defer lt.lock.Unlock()
+	log.Printf("lint: %d of %d log(s) passed\n", lt.logs-lt.failed, lt.logs)
+	if lt.failed > 0 {
+		return &lintFailure{lt.failed, lt.logs}
+	}
+	return nil
+}
+
+// segmentGaps reports missing ranks in a multi-segment log (which should run 0, 1, 2, ... without holes)
+func segmentGaps(inputSegments []logSegment) []*core.AnomalyError {
+	var gaps []*core.AnomalyError
+	if len(inputSegments) < 2 && (len(inputSegments) == 0 || inputSegments[0].rank == 0) {
+		return nil
+	}
+	expected := 0
+	for _, segment := range inputSegments {
+		if segment.rank == expected+1 {
+			gaps = append(gaps, core.NewAnomaly(0, core.AnomalySegmentGap, "missing segment rank %d (before %s)", expected, segment.name))
+		} else if segment.rank > expected {
+			gaps = append(gaps, core.NewAnomaly(0, core.AnomalySegmentGap, "missing segment ranks %d-%d (before %s)", expected, segment.rank-1, segment.name))
+		} else if segment.rank < expected {
+			gaps = append(gaps, core.NewAnomaly(0, core.AnomalySegmentGap, "duplicate segment rank %d (%s)", segment.rank, segment.name))
+		}
+		expected = segment.rank + 1
+	}
+	return gaps
+}
+
+// lintCluster lints one log, writing its JSON report to the pipeline's stdout
+func (p *pipeline) lintCluster(inputName string, inputSegments []logSegment) error {
+	aggCtx := p.base
+	report := lintReport{
+		Log:      inputName,
+		Counts:   make(map[core.AnomalyKind]int),
+		Problems: make([]lintProblem, 0),
+	}
+	for _, segment := range inputSegments {
+		report.Segments = append(report.Segments, segment.name)
+	}
+	note := func(ae *core.AnomalyError) {
+		report.Counts[ae.Kind]++
+		if len(report.Problems) < lintMaxProblems {
+			report.Problems = append(report.Problems, lintProblem{ae.Line, ae.Kind, ae.Detail})
+		} else {
+			report.ProblemsTruncated = true
+		}
+	}
+
+	for _, gap := range segmentGaps(inputSegments) {
+		note(gap)
+	}
+	inputStream, err := p.openInput(&aggCtx, inputName, inputSegments)
+	if err == nil {
+		defer closeInputs(inputStream)
+		ln := core.NewLogInfo(aggCtx.LogOid, aggCtx.RootName, aggCtx.SubmissionID)
+		ln.Lenient = true
+		ln.CheckEscapes = true
+		ln.AnomalyHook = note
+		err = ln.IngestStream(inputStream)
+		report.Lines, report.Bytes = ln.Stats.Lines, ln.Stats.Bytes
+	}
+	if err != nil {
+		report.Error = err.Error()
+	}
+
+	report.OK = (report.Error == "")
+	for kind := range report.Counts {
+		if !p.linter.allow[kind] {
+			report.OK = false
+		}
+	}
+
+	p.linter.lock.Lock()
+	p.linter.logs++
+	if !report.OK {
+		p.linter.failed++
+	}
+	p.linter.lock.Unlock()
+
+	doc, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	p.stdoutLock.Lock()
+	defer p.stdoutLock.Unlock()
+	_, err = fmt.Fprintf(p.stdout, "%s\n", doc)
+	return err
+}
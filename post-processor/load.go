@@ -0,0 +1,55 @@
+package main
+
+// ---------------------------------------------------------------------------
+// COPY-file loading ("-load"): import directories written by "-output copy:DIR" (e.g., on crawlers without
+// PostgreSQL access) into PostgreSQL later, resolving logfile/URL/script/feature references on the way in
+// ---------------------------------------------------------------------------
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/wspr-ncsu/visiblev8/post-processor/causality"
+	"github.com/wspr-ncsu/visiblev8/post-processor/core"
+	"github.com/wspr-ncsu/visiblev8/post-processor/elements"
+	"github.com/wspr-ncsu/visiblev8/post-processor/features"
+	"github.com/wspr-ncsu/visiblev8/post-processor/flow"
+	"github.com/wspr-ncsu/visiblev8/post-processor/fptp"
+	"github.com/wspr-ncsu/visiblev8/post-processor/idl_apis"
+	"github.com/wspr-ncsu/visiblev8/post-processor/mega"
+	"github.com/wspr-ncsu/visiblev8/post-processor/objects"
+	"github.com/wspr-ncsu/visiblev8/post-processor/stats"
+)
+
+// copyTables is the master list of COPY tables that can be loaded (in load order: referenced tables first)
+var copyTables = concatCopyTables(mega.CopyTables, features.CopyTables, causality.CopyTables, elements.CopyTables, fptp.CopyTables,
+	flow.CopyTables, idl_apis.CopyTables, objects.CopyTables, stats.CopyTables)
+
+func concatCopyTables(lists ...[]*core.CopyTable) []*core.CopyTable {
+	var tables []*core.CopyTable
+	for _, list := range lists {
+		tables = append(tables, list...)
+	}
+	return tables
+}
+
+// loadCopyDirs loads each COPY output directory (as its own transaction) into PostgreSQL,
+// replacing logs loaded before (if asked to) instead of skipping them
+func loadCopyDirs(dirs []string, replace bool) error {
+	// We rely on the PGxxx environment variables being set...
+	sqlDb, err := sql.Open("postgres", "sslmode=disable")
+	if err != nil {
+		return err
+	}
+	defer sqlDb.Close()
+	if err = core.CheckSchemaVersion(sqlDb); err != nil {
+		return err
+	}
+
+	for _, dir := range dirs {
+		if err = core.LoadCopyDir(sqlDb, dir, copyTables, replace); err != nil {
+			return fmt.Errorf("-load %s: %w", dir, err)
+		}
+	}
+	return nil
+}
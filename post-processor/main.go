@@ -1,16 +1,24 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
 	"regexp"
+	"runtime/debug"
 	"sort"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/google/uuid"
@@ -26,8 +34,10 @@ import (
 	"github.com/wspr-ncsu/visiblev8/post-processor/idl_apis"
 	"github.com/wspr-ncsu/visiblev8/post-processor/mega"
 	"github.com/wspr-ncsu/visiblev8/post-processor/micro"
+	"github.com/wspr-ncsu/visiblev8/post-processor/objects"
+	"github.com/wspr-ncsu/visiblev8/post-processor/stats"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 // Version is set during build (to the git hash of the compiled code)
@@ -64,6 +74,8 @@ var acceptedOutputFormats = map[string]formatAggregator{
 	"create_element":    {"CreateElement", elements.NewCreateElementAggregator},
 	"ufeatures":         {"MicroFeatureUsage", micro.NewFeatureUsageAggregator},
 	"flow":              {"flow", flow.NewAggregator},
+	"objects":           {"ObjectLifecycle", objects.NewAggregator},
+	"stats":             {"Stats", stats.NewAggregator},
 	"noop":              {"Noop", nullCtor},
 }
 
@@ -88,13 +100,28 @@ func makeAggregators(formats core.FormatSet) ([]core.Aggregator, error) {
 	return aggregators, nil
 }
 
-// vv8LogNamePatter matches VV8 logfile name pattern (3 fields: name-stem, segment-rank, ".log")
-var vv8LogNamePattern = regexp.MustCompile(`(vv8-[^.]+\.)(\d+)(\.log)$`)
+// vv8LogNamePattern matches VV8 logfile name pattern (4 fields: name-stem, segment-rank, ".log", optional compression suffix)
+var vv8LogNamePattern = regexp.MustCompile(`(vv8-[^.]+\.)(\d+)(\.log)(\.gz|\.zst|\.xz)?$`)
+
+// nextSegmentName names the segment file that would follow <name> (same stem, next rank), or "" if <name> is not a segment
+func nextSegmentName(name string) string {
+	match := vv8LogNamePattern.FindStringSubmatchIndex(name)
+	if match == nil || match[8] >= 0 {
+		// (not a segment, or a compressed one, which is not still being written)
+		return ""
+	}
+	rank, err := strconv.Atoi(name[match[4]:match[5]])
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%s%d%s", name[:match[4]], rank+1, name[match[5]:])
+}
 
 // logSegment tracks order/name for a log stream segement file
 type logSegment struct {
-	rank int
-	name string
+	rank    int
+	name    string
+	archive *core.Archive // archive containing the (member) named segment, if any
 }
 
 // inputClusterMap groups/sorts raw input names (files and @oids) into sets of files (for on-disk logs) in a map
@@ -111,35 +138,273 @@ func getInputClusters(args []string) (inputClusterMap, error) {
 			if err != nil {
 				return nil, err
 			}
-			inputs[key] = append(inputs[key], logSegment{rank, val})
+			inputs[key] = append(inputs[key], logSegment{rank: rank, name: val})
 		} else {
 			// "@oid" (no input files) or "-" (stdin)
-			inputs[val] = []logSegment{{0, val}}
+			inputs[val] = []logSegment{{name: val}}
 		}
 	}
-	for _, files := range inputs {
+	for key, files := range inputs {
 		if len(files) > 0 {
 			sort.Slice(files, func(i, j int) bool {
 				return files[i].rank < files[j].rank
 			})
 		}
+		// (e.g., both "vv8-x.1.log" and "vv8-x.1.log.gz", or same-named logs from different directories)
+		for i := 1; i < len(files); i++ {
+			if files[i].rank == files[i-1].rank {
+				return nil, fmt.Errorf("%s: segment %d given twice (%s and %s)", key, files[i].rank, files[i-1].name, files[i].name)
+			}
+		}
 	}
 	return inputs, nil
 }
 
+// isLogMemberName tells whether an archive member looks like a (possibly compressed) log segment
+func isLogMemberName(name string) bool {
+	if kind := core.CompressionBySuffix(name); kind != core.Uncompressed {
+		name = name[:strings.LastIndexByte(name, '.')]
+	}
+	return strings.HasSuffix(name, ".log")
+}
+
+// expandArchiveInputs replaces each archive input with the clusters of log segments it contains
+// (keyed "<archive>/<member dir>/<cluster>", so each cluster's root name still identifies the archive it came from,
+// and same-named logs in different member directories stay separate)
+func expandArchiveInputs(inputs inputClusterMap) (inputClusterMap, error) {
+	expanded := make(inputClusterMap)
+	for key, segments := range inputs {
+		if strings.HasPrefix(key, "@") || !core.IsArchiveName(key) {
+			expanded[key] = segments
+			continue
+		}
+		archive, err := core.OpenArchive(key)
+		if err != nil {
+			return nil, err
+		}
+		dirMembers := make(map[string][]string) // member directory -> log segment members in it
+		var logMembers int
+		for _, member := range archive.Members() {
+			if isLogMemberName(member) {
+				dir := path.Dir(member)
+				dirMembers[dir] = append(dirMembers[dir], member)
+				logMembers++
+			} else {
+				log.Printf("%s: skipping non-log member %s\n", key, member)
+			}
+		}
+		clusters := 0
+		for dir, members := range dirMembers {
+			memberClusters, err := getInputClusters(members)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+			for memberKey, memberSegments := range memberClusters {
+				for i := range memberSegments {
+					memberSegments[i].archive = archive
+				}
+				expanded[key+"/"+path.Join(dir, memberKey)] = memberSegments
+				clusters++
+			}
+		}
+		log.Printf("%s: %d log segment(s) in %d cluster(s)\n", key, logMembers, clusters)
+	}
+	return expanded, nil
+}
+
+// globList is a comma-separated list of file name glob patterns (see filepath.Match)
+type globList []string
+
+func parseGlobList(raw string) (globList, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	globs := strings.Split(raw, ",")
+	for _, glob := range globs {
+		if _, err := filepath.Match(glob, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern '%s': %w", glob, err)
+		}
+	}
+	return globs, nil
+}
+
+// matches tells whether <name> matches any of the patterns
+func (globs globList) matches(name string) bool {
+	for _, glob := range globs {
+		if ok, _ := filepath.Match(glob, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// expandDirectoryInputs replaces each directory input with the log clusters (and archives) found beneath it, recursively;
+// segments are grouped per directory (keyed "<dir>/<cluster>"), so same-named logs in different directories stay separate
+// (<include>, if given, limits the files taken; <exclude> drops matching files and directories)
+func expandDirectoryInputs(inputs inputClusterMap, include, exclude globList) (inputClusterMap, error) {
+	expanded := make(inputClusterMap)
+	for key, segments := range inputs {
+		if key == "-" || strings.HasPrefix(key, "@") {
+			expanded[key] = segments
+			continue
+		}
+		info, err := os.Stat(key)
+		if err != nil || !info.IsDir() {
+			// (missing files are reported when opened)
+			expanded[key] = segments
+			continue
+		}
+
+		dirFiles := make(map[string][]string) // directory -> log segment/archive names found in it
+		var logFiles, archives, excluded, skipped int
+		err = filepath.WalkDir(key, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			name := entry.Name()
+			if entry.IsDir() {
+				if path != key && exclude.matches(name) {
+					return filepath.SkipDir
+				}
+				return nil
+			} else if !entry.Type().IsRegular() {
+				skipped++
+				return nil
+			}
+			if (len(include) > 0 && !include.matches(name)) || exclude.matches(name) {
+				excluded++
+				return nil
+			}
+			if isLogMemberName(name) {
+				logFiles++
+			} else if core.IsArchiveName(name) {
+				archives++
+			} else {
+				skipped++
+				return nil
+			}
+			dir := filepath.Dir(path)
+			dirFiles[dir] = append(dirFiles[dir], name)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		clusters := 0
+		for dir, names := range dirFiles {
+			dirClusters, err := getInputClusters(names)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", dir, err)
+			}
+			for dirKey, dirSegments := range dirClusters {
+				for i := range dirSegments {
+					dirSegments[i].name = filepath.Join(dir, dirSegments[i].name)
+				}
+				expanded[filepath.Join(dir, dirKey)] = dirSegments
+				clusters++
+			}
+		}
+		log.Printf("%s: found %d log segment(s) and %d archive(s) in %d directories (%d input(s)); excluded %d, skipped %d non-log file(s)\n",
+			key, logFiles, archives, len(dirFiles), clusters, excluded, skipped)
+	}
+	return expanded, nil
+}
+
+// regroupMongoInputs clusters "@oid" inputs whose GridFS file names are segments of the same multi-part log
+// (keyed like on-disk clusters, but with a leading "@"); OIDs with other names are left alone
+//
+// Segments of one log must agree on their upload metadata, and each segment may be given only once; otherwise,
+// the OIDs (e.g., same-named logs uploaded by different crawls) cannot be told apart, and are refused.
+func regroupMongoInputs(inputs inputClusterMap, db *mongo.Database) (inputClusterMap, error) {
+	regrouped := make(inputClusterMap)
+	metadata := make(map[string]string) // group key -> upload metadata (as extended JSON) of its first OID
+	for key, segments := range inputs {
+		if !strings.HasPrefix(key, "@") {
+			regrouped[key] = segments
+			continue
+		}
+		oid, err := primitive.ObjectIDFromHex(key[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid oid '%s'", key[1:])
+		}
+		file, err := core.GridFSLogFile(db, oid)
+		if err != nil {
+			return nil, err
+		}
+		fields := vv8LogNamePattern.FindStringSubmatch(file.Name)
+		if len(fields) == 0 {
+			regrouped[key] = segments
+			continue
+		}
+		rank, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, err
+		}
+		groupKey := "@" + fields[1] + "0.log"
+		var meta string
+		if file.Metadata != nil {
+			meta = file.Metadata.String()
+		}
+		if first, ok := metadata[groupKey]; !ok {
+			metadata[groupKey] = meta
+		} else if first != meta {
+			return nil, fmt.Errorf("%s: OIDs %s and %s have different upload metadata (%s vs. %s); process them separately",
+				groupKey, regrouped[groupKey][0].name[1:], key[1:], first, meta)
+		}
+		regrouped[groupKey] = append(regrouped[groupKey], logSegment{rank: rank, name: key})
+	}
+	for groupKey, segments := range regrouped {
+		sort.Slice(segments, func(i, j int) bool {
+			return segments[i].rank < segments[j].rank
+		})
+		for i := 1; i < len(segments); i++ {
+			if segments[i].rank == segments[i-1].rank {
+				return nil, fmt.Errorf("%s: segment %d given twice (%s and %s)",
+					groupKey, segments[i].rank, segments[i-1].name, segments[i].name)
+			}
+		}
+	}
+	return regrouped, nil
+}
+
 // ---------------------------------------------------------------------------
 // Main entry point
 // ---------------------------------------------------------------------------
 
-// invoke triggers the post-processor logic itself, controlled by the command-line arguments given in <args> (i.e., os.Args[1:])
+// invoke triggers the post-processor logic itself, controlled by the command-line arguments given in <args> (i.e., os.Args[1:]),
+// sending stream output to <stdout>
 // (if topLevel is TRUE, then invoke can launch a webhook-server triggers further invokes from HTTP POSTs)
-func invoke(args []string, topLevel bool) error {
+func invoke(args []string, stdout io.Writer, topLevel bool) error {
 	var aggCtx core.AggregationContext
 	var aggPasses string
 	var outputFormat string
 	var SubmissionID string
 	var rootDomain string
 	var annotate, showVersion bool
+	var jobs int
+	var spillScripts bool
+	var scriptStoreDir string
+	var serveAddr string
+	var serveCfg serveConfig
+	var worker workerConfig
+	var runAsWorker bool
+	var lint bool
+	var lintAllow string
+	var redactRules, redactOut string
+	var slice, convert bool
+	var convertOut string
+	var parquetDir string
+	var copyDir string
+	var load bool
+	var replace, skipExisting bool
+	var migrate string
+	var sqliteDb *sql.DB
+	var sliceOrigin, sliceURL, sliceLines string
+	sliceFilter := core.NewSliceFilter()
+	var includeGlobs, excludeGlobs string
+	var follow bool
+	var followIdle, snapshotEvery time.Duration
 
 	flags := flag.NewFlagSet("vv8PostProcessor", flag.ContinueOnError)
 	flags.BoolVar(&showVersion, "version", false, "show version (Git commit hash) and quit")
@@ -147,37 +412,127 @@ func invoke(args []string, topLevel bool) error {
 	flags.StringVar(&rootDomain, "rootdomain", "", "manually specify a root domain to associate with logfiles (used for getting the URL that is being visited)")
 	flags.BoolVar(&annotate, "annotate", false, "skip aggregating and dump JSON-annotated log lines to stdout (script/offset context, if any)")
 	flags.StringVar(&aggPasses, "aggs", "noop", "one or more ('+'-delimited) aggregation passes to perform")
-	flags.StringVar(&outputFormat, "output", "stdout", "send data to `dest`; options: 'stdout', 'postgresql'")
+	flags.StringVar(&outputFormat, "output", "stdout", "send data to `dest`; options: 'stdout', 'postgresql', 'parquet', 'sqlite:FILE' (Mfeatures only; created if needed, and added to on later runs), 'copy:DIR' (PostgreSQL COPY files, for -load)")
+	flags.StringVar(&parquetDir, "parquet-dir", "", "with -output parquet, write one Parquet file per table into `dir` (created if needed; existing files are never overwritten)")
 	flags.StringVar(&aggCtx.RootName, "log-root", "", "manually specify root `name` for logfile")
+	flags.IntVar(&jobs, "jobs", 1, "process up to `N` independent log clusters concurrently")
+	flags.BoolVar(&spillScripts, "spill-scripts", false, "keep script bodies in a (temporary) on-disk store instead of RAM, loading them only when an output needs them")
+	flags.StringVar(&scriptStoreDir, "script-store", "", "like -spill-scripts, but use (and keep) the content-addressed store in `dir`")
+	flags.StringVar(&includeGlobs, "include", "", "when walking directory inputs, only take files whose names match one of these (comma-separated) glob `patterns`")
+	flags.StringVar(&excludeGlobs, "exclude", "", "when walking directory inputs, ignore files and subdirectories whose names match one of these (comma-separated) glob `patterns`")
+	flags.BoolVar(&follow, "follow", false, "tail a live log (and its later segments) as it is written, dumping incremental stream-output snapshots")
+	flags.DurationVar(&followIdle, "follow-idle", 30*time.Second, "with -follow, stop after `duration` without new data (SIGINT/SIGTERM stop it, too)")
+	flags.DurationVar(&snapshotEvery, "snapshot-every", 10*time.Second, "with -follow, dump a snapshot every `duration`")
+	flags.StringVar(&serveAddr, "serve", "", "run an HTTP server on `addr` (e.g., ':8080') accepting post-processing jobs, instead of processing inputs given here")
+	flags.StringVar(&serveCfg.root, "serve-root", "", "with -serve, allow jobs to name input paths (relative to `dir`); otherwise only uploads and @OIDs are accepted")
+	flags.IntVar(&serveCfg.limit, "serve-limit", 4, "with -serve, run at most `N` jobs at a time")
+	flags.Int64Var(&serveCfg.maxUpload, "serve-max-upload", 4<<30, "with -serve, refuse uploads larger than `bytes`")
+	flags.DurationVar(&serveCfg.keep, "serve-keep", time.Hour, "with -serve, forget finished jobs (and their results) after `duration`")
+	flags.BoolVar(&runAsWorker, "worker", false, "run as a batch worker, claiming jobs from the PostgreSQL postprocess_jobs table (instead of processing inputs given here)")
+	flags.StringVar(&worker.id, "worker-id", defaultWorkerID(), "with -worker, the `name` recorded on claimed jobs")
+	flags.DurationVar(&worker.poll, "worker-poll", 5*time.Second, "with -worker, wait `duration` between polls of an empty queue")
+	flags.DurationVar(&worker.staleAfter, "worker-stale", 5*time.Minute, "with -worker, requeue jobs whose worker has not heartbeated in `duration`")
+	flags.DurationVar(&worker.backoff, "worker-backoff", 30*time.Second, "with -worker, delay the first retry of a failed job by `duration` (doubling for each further attempt)")
+	flags.DurationVar(&worker.maxBackoff, "worker-max-backoff", time.Hour, "with -worker, cap retry delays at `duration`")
+	flags.BoolVar(&worker.drain, "worker-drain", false, "with -worker, exit once no job is claimable (instead of polling forever)")
+	flags.BoolVar(&lint, "lint", false, "skip aggregating and report (as JSON, one object per log) every problem found in the logs; exits with status 2 if any log has problems")
+	flags.StringVar(&lintAllow, "lint-allow", "", "with -lint, report but do not fail on these (comma-separated) anomaly `kinds`")
+	flags.StringVar(&redactRules, "redact", "", "skip aggregating and write redacted copies of the logs (still valid VV8 logs), applying the JSON rules in `file`")
+	flags.StringVar(&redactOut, "redact-out", "", "with -redact, write one redacted log per input log into `dir` (instead of a single log to stdout)")
+	flags.BoolVar(&convert, "convert", false, "skip aggregating and re-encode the logs in a compact binary format that is much faster to process again (any later run reads it like the original)")
+	flags.StringVar(&convertOut, "convert-out", "", "with -convert, write one binary log per input log into `dir` (instead of a single log to stdout)")
+	flags.BoolVar(&slice, "slice", false, "skip aggregating and write (to stdout) a smaller, self-consistent log of just the trace records matching all -slice-* filters given")
+	flags.StringVar(&sliceOrigin, "slice-origin", "", "with -slice, keep records whose active origin matches `regexp`")
+	flags.StringVar(&sliceFilter.Isolate, "slice-isolate", "", "with -slice, keep records from the isolate with this `pointer` (e.g., '0x1234abcd')")
+	flags.IntVar(&sliceFilter.ScriptID, "slice-script", -1, "with -slice, keep records of the script with this `ID`")
+	flags.StringVar(&sliceURL, "slice-url", "", "with -slice, keep records of scripts (or their eval descendants) whose URL matches `regexp`")
+	flags.StringVar(&sliceFilter.Hash, "slice-hash", "", "with -slice, keep records of the script whose SHA2-256 hash starts with these `hex` digits")
+	flags.StringVar(&sliceLines, "slice-lines", "", "with -slice, keep records from this (1-based, inclusive) `first-last` line range (either end may be omitted)")
+	flags.StringVar(&migrate, "migrate", "", "manage the PostgreSQL schema instead of processing logs: `command` 'up' applies pending migrations, 'status' lists them")
+	flags.BoolVar(&load, "load", false, "skip aggregating and load directories of COPY files (written by -output copy:DIR) into PostgreSQL, instead of processing logs")
+	flags.BoolVar(&replace, "replace", false, "with -output postgresql (or -load), first delete any earlier import of each log (every row it left, in one transaction)")
+	flags.BoolVar(&skipExisting, "skip-existing", false, "with -output postgresql, skip logs imported before (for resuming batch runs; imports cut short are replaced)")
+	flags.BoolVar(&aggCtx.Lenient, "lenient", false, "tolerate (and count) malformed or truncated log data instead of failing on the first bad line")
 	flags.Usage = func() {
-		fmt.Fprintf(flags.Output(), "Usage: %s: [FLAGS] (-|FILENAME|@OID) [(-|FILENAME|@OID)...]\n", os.Args[0])
+		fmt.Fprintf(flags.Output(), "Usage: %s: [FLAGS] (-|FILENAME|ARCHIVE|DIRECTORY|@OID) [(-|FILENAME|ARCHIVE|DIRECTORY|@OID)...]\n", os.Args[0])
 		flags.PrintDefaults()
 		fmt.Fprintf(flags.Output(), "\nPasses Available:\n")
 		for passName := range acceptedOutputFormats {
 			fmt.Fprintf(flags.Output(), "\t%s\n", passName)
 		}
 	}
-	flags.Parse(args)
+	err := flags.Parse(args)
+	if err == flag.ErrHelp {
+		return nil
+	} else if err != nil {
+		return err
+	}
 	if showVersion {
-		fmt.Println(Version)
+		fmt.Fprintln(stdout, Version)
 		return nil
 	}
 
+	if serveAddr != "" {
+		if !topLevel {
+			return fmt.Errorf("-serve is only available at top level")
+		} else if serveCfg.limit < 1 {
+			return fmt.Errorf("invalid -serve-limit value %d (must be at least 1)", serveCfg.limit)
+		} else if serveCfg.maxUpload < 1 || serveCfg.keep <= 0 {
+			return fmt.Errorf("-serve-max-upload and -serve-keep must be positive")
+		}
+		return serve(serveAddr, serveCfg)
+	}
+
+	if runAsWorker {
+		if !topLevel {
+			return fmt.Errorf("-worker is only available at top level")
+		} else if worker.staleAfter <= 0 || worker.poll <= 0 {
+			return fmt.Errorf("-worker-stale and -worker-poll must be positive")
+		}
+		return runWorker(worker)
+	}
+
+	if migrate != "" {
+		if !topLevel {
+			return fmt.Errorf("-migrate is only available at top level")
+		}
+		return runMigrate(migrate, stdout)
+	}
+
 	if flags.NArg() < 1 {
 		flags.Usage()
 		return nil
 	}
 
+	if replace && skipExisting {
+		return fmt.Errorf("-replace and -skip-existing are mutually exclusive")
+	}
+
+	if load {
+		if !topLevel {
+			return fmt.Errorf("-load is only available at top level")
+		}
+		return loadCopyDirs(flags.Args(), replace)
+	}
+
 	if SubmissionID != "" {
-		aggCtx.SubmissionID = uuid.MustParse(SubmissionID)
+		id, err := uuid.Parse(SubmissionID)
+		if err != nil {
+			return fmt.Errorf("invalid -submissionid '%s': %w", SubmissionID, err)
+		}
+		aggCtx.SubmissionID = id
 	}
 
 	if rootDomain != "" {
 		aggCtx.RootDomain = rootDomain
 	}
 
+	if jobs < 1 {
+		return fmt.Errorf("invalid -jobs value %d (must be at least 1)", jobs)
+	}
+
 	// Parse outputs (actualy, passes) from our sole positional argument
-	if !annotate {
+	if !annotate && !lint && redactRules == "" && !slice && !convert {
 		outputs := strings.Split(aggPasses, "+")
 		aggCtx.Formats = make(core.FormatSet)
 		for _, o := range outputs {
@@ -197,111 +552,233 @@ func invoke(args []string, topLevel bool) error {
 		return fmt.Errorf("unable to parse input array %q", err)
 	}
 
-	// Process each cluster in turn
-	for inputName, inputSegments := range inputClusters {
-
-		// Set up input stream (stdin if "-", Mongo vv8log OID if "@...", filename otherwise)
-		var inputStream io.Reader
-		if strings.HasPrefix(inputName, "@") {
-			// Do we need to connect to Mongo, or was that already done?
-			if aggCtx.MongoDb == nil {
-				conn, err := core.DialMongo()
-				if err != nil {
-					log.Fatal(err)
-				}
-				log.Printf("Connected to Mongo @ %s\n", conn)
-				aggCtx.MongoDb = conn.Client.Database("admin")
-			}
+	include, err := parseGlobList(includeGlobs)
+	if err != nil {
+		return fmt.Errorf("-include: %w", err)
+	}
+	exclude, err := parseGlobList(excludeGlobs)
+	if err != nil {
+		return fmt.Errorf("-exclude: %w", err)
+	}
+	inputClusters, err = expandDirectoryInputs(inputClusters, include, exclude)
+	if err != nil {
+		return err
+	}
+	inputClusters, err = expandArchiveInputs(inputClusters)
+	if err != nil {
+		return err
+	}
 
-			// Parse OID
-			oidHex := inputName[1:]
-			aggCtx.LogOid, err = primitive.ObjectIDFromHex(oidHex)
-			if err != nil {
-				return fmt.Errorf("invalid oid '%s'", oidHex)
-			}
-			log.Printf("Reading vv8log OID %s\n", oidHex)
-			bucket, err := gridfs.NewBucket(aggCtx.MongoDb)
+	// Connect to shared back-ends once, up front (every worker shares the same pooled handles)
+	for inputName := range inputClusters {
+		if strings.HasPrefix(inputName, "@") && aggCtx.MongoDb == nil {
+			conn, err := core.DialMongo()
 			if err != nil {
 				return err
 			}
-			stream, err := bucket.OpenDownloadStream(aggCtx.LogOid)
-			if err != nil {
-				return err
-			}
-
-			// Set to match the Mongo data if it's missing
-			aggCtx.RootName = stream.GetFile().Name
-		} else if inputName == "-" {
-			// Stdin (TODO support -archive, so long as the user provides a root-file name, too)
-			inputStream = os.Stdin
-			log.Println("Reading from stdin...")
-		} else if len(inputSegments) > 0 {
-			// Plain file input (assumed uncompressed, but possibly multi-segment)
-			segmentStreams := make([]io.Reader, len(inputSegments))
-			for i, segment := range inputSegments {
-				log.Printf("Opening %s...\n", segment.name)
-				file, err := os.Open(segment.name)
-				if err != nil {
-					log.Fatal(err)
-				}
-				segmentStreams[i] = core.NewClosingReader(file)
-			}
-			inputStream = io.MultiReader(segmentStreams...)
-			aggCtx.RootName = inputName
-		} else {
-			return fmt.Errorf("something is very wrong--where are the file names?")
+			// (invoke() also runs once per server/worker job, so the client must not outlive it)
+			defer conn.Client.Disconnect(context.Background())
+			log.Printf("Connected to Mongo @ %s\n", conn)
+			aggCtx.MongoDb = conn.Client.Database(core.GetLogDatabaseName())
 		}
-
-		// Handle output setup (with a special case for "dump" mode)
-		var outputDriver core.DumpDriver
-		if annotate {
-			err := core.AnnotateStream(inputStream, &aggCtx)
+	}
+	if aggCtx.MongoDb != nil {
+		inputClusters, err = regroupMongoInputs(inputClusters, aggCtx.MongoDb)
+		if err != nil {
+			return err
+		}
+	}
+	if !annotate && outputFormat == "postgresql" {
+		// We rely on the PGxxx environment variables being set...
+		aggCtx.SQLDb, err = sql.Open("postgres", "sslmode=disable")
+		if err != nil {
+			return err
+		}
+		// (each worker may hold one pinned import-session connection plus one more from the pool at a time)
+		aggCtx.SQLDb.SetMaxOpenConns(2 * jobs)
+		aggCtx.SQLDb.SetMaxIdleConns(jobs)
+		defer aggCtx.SQLDb.Close() // (closed when this invocation, whether main() or a server/worker job, returns)
+		if err = core.CheckSchemaVersion(aggCtx.SQLDb); err != nil {
+			return err
+		}
+	} else if strings.HasPrefix(outputFormat, "sqlite:") {
+		if !annotate {
+			sqliteDb, err = core.OpenSQLite(strings.TrimPrefix(outputFormat, "sqlite:"))
 			if err != nil {
 				return err
 			}
-			return nil
-		} else if outputFormat == "postgresql" {
-			// Do we need to connect to PG? (we rely on the PGxxx environment variables being set...)
-			if aggCtx.SQLDb == nil {
-				aggCtx.SQLDb, err = sql.Open("postgres", "sslmode=disable")
-				if err != nil {
-					return err
-				}
-				defer aggCtx.SQLDb.Close() // lifetime tied to main()
-			}
-			outputDriver = core.NewPostgresqlDumpDriver(aggCtx.SQLDb)
-		} else if outputFormat == "stdout" {
-			outputDriver = core.NewStreamDumpDriver(os.Stdout)
-		} else {
-			return fmt.Errorf("unsupported output format '%s'", outputFormat)
+			defer sqliteDb.Close()
+		}
+		outputFormat = "sqlite"
+	} else if outputFormat == "parquet" {
+		if parquetDir == "" {
+			return fmt.Errorf("-output parquet needs -parquet-dir")
+		}
+	} else if strings.HasPrefix(outputFormat, "copy:") {
+		copyDir = strings.TrimPrefix(outputFormat, "copy:")
+		if copyDir == "" {
+			return fmt.Errorf("-output copy:DIR needs a directory")
 		}
+		outputFormat = "copy"
 
-		// FINALLY build the aggregator array, post-processes that sucker, and feed the results into the output driver
+		// (passes without COPY tables would otherwise be dropped from the output, log by log)
 		aggregators, err := makeAggregators(aggCtx.Formats)
 		if err != nil {
 			return err
 		}
+		for _, agg := range aggregators {
+			if _, ok := agg.(core.CopyDumper); !ok {
+				return fmt.Errorf("-output copy: %T does not support COPY dumping", agg)
+			}
+		}
+	} else if outputFormat != "postgresql" && outputFormat != "stdout" {
+		return fmt.Errorf("unsupported output format '%s'", outputFormat)
+	}
+	if (replace || skipExisting) && outputFormat != "postgresql" {
+		return fmt.Errorf("-replace and -skip-existing need -output postgresql (or -load)")
+	}
 
-		aggCtx.Ln = core.NewLogInfo(aggCtx.LogOid, aggCtx.RootName, aggCtx.SubmissionID)
-		err = aggCtx.Ln.IngestStream(inputStream, aggregators...)
+	if (spillScripts || scriptStoreDir != "") && !annotate {
+		aggCtx.ScriptStore, err = core.NewScriptStore(scriptStoreDir)
 		if err != nil {
 			return err
 		}
+		defer aggCtx.ScriptStore.Close()
+	}
 
-		for _, agg := range aggregators {
-			log.Printf("Started dumping for aggregator...\n")
-			err = outputDriver(agg, &aggCtx)
+	if annotate && jobs > 1 {
+		log.Printf("-annotate writes raw lines straight to stdout; ignoring -jobs %d", jobs)
+		jobs = 1
+	}
+	existing := ""
+	if replace {
+		existing = "replace"
+	} else if skipExisting {
+		existing = "skip"
+	}
+	pipe := &pipeline{
+		base:          aggCtx,
+		outputFormat:  outputFormat,
+		sqlite:        sqliteDb,
+		existing:      existing,
+		annotate:      annotate,
+		stdout:        stdout,
+		buffered:      jobs > 1,
+		follow:        follow,
+		followIdle:    followIdle,
+		snapshotEvery: snapshotEvery,
+	}
+	if lint {
+		allow, err := parseLintAllow(lintAllow)
+		if err != nil {
+			return fmt.Errorf("-lint-allow: %w", err)
+		}
+		pipe.linter = &linter{allow: allow}
+		err = pipe.runAll(inputClusters, jobs)
+		if err != nil {
+			return err
+		}
+		return pipe.linter.verdict()
+	}
+	if convert {
+		if convertOut == "" && len(inputClusters) != 1 {
+			return fmt.Errorf("-convert without -convert-out takes exactly one log (got %d)", len(inputClusters))
+		}
+		pipe.convert, pipe.convertOut = true, convertOut
+		return pipe.runAll(inputClusters, jobs)
+	}
+	if slice {
+		if sliceOrigin != "" {
+			sliceFilter.Origin, err = regexp.Compile(sliceOrigin)
 			if err != nil {
-				return err
+				return fmt.Errorf("-slice-origin: %w", err)
+			}
+		}
+		if sliceURL != "" {
+			sliceFilter.URL, err = regexp.Compile(sliceURL)
+			if err != nil {
+				return fmt.Errorf("-slice-url: %w", err)
+			}
+		}
+		if sliceLines != "" {
+			err = sliceFilter.ParseLineRange(sliceLines)
+			if err != nil {
+				return fmt.Errorf("-slice-lines: %w", err)
+			}
+		}
+		if len(inputClusters) != 1 {
+			return fmt.Errorf("-slice takes exactly one log (got %d)", len(inputClusters))
+		}
+		pipe.slicer = sliceFilter
+		return pipe.runAll(inputClusters, jobs)
+	}
+	if redactRules != "" {
+		pipe.redactor, err = newRedactor(redactRules, redactOut)
+		if err != nil {
+			return fmt.Errorf("-redact: %w", err)
+		}
+		if redactOut == "" && len(inputClusters) != 1 {
+			return fmt.Errorf("-redact without -redact-out takes exactly one log (got %d)", len(inputClusters))
+		}
+		return pipe.runAll(inputClusters, jobs)
+	}
+	if follow {
+		if len(inputClusters) != 1 {
+			return fmt.Errorf("-follow takes exactly one log (got %d)", len(inputClusters))
+		}
+		for inputName, inputSegments := range inputClusters {
+			if inputName == "-" || strings.HasPrefix(inputName, "@") || inputSegments[0].archive != nil {
+				return fmt.Errorf("-follow needs an on-disk log file (not '%s')", inputName)
 			}
 		}
 
-		// debugging hack--perform 1 second delay on no-op (null aggregator)
-		if len(aggregators) == 0 {
-			time.Sleep(time.Second)
+		// Stop following (but still finish up) on the first SIGINT/SIGTERM
+		stop := make(chan struct{})
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(signals)
+		go func() {
+			<-signals
+			close(stop)
+		}()
+		pipe.stop = stop
+	}
+	if outputFormat == "parquet" && !annotate {
+		if err = os.MkdirAll(parquetDir, 0755); err != nil {
+			return err
+		}
+		sink := core.NewParquetSink(parquetDir)
+		pipe.tables = sink
+		err = pipe.runAll(inputClusters, jobs)
+		if closeErr := sink.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	}
+	if outputFormat == "copy" && !annotate {
+		if err = os.MkdirAll(copyDir, 0755); err != nil {
+			return err
 		}
+		sink := core.NewCopyDirSink(copyDir)
+		pipe.copies = sink
+		err = pipe.runAll(inputClusters, jobs)
+		if closeErr := sink.Close(); err == nil {
+			err = closeErr
+		}
+		return err
 	}
-	return nil
+	return pipe.runAll(inputClusters, jobs)
+}
+
+// invokeJob runs a nested invoke() for a server/worker job, turning a panic into the job's error (so one bad job
+// fails on its own instead of taking the whole process down)
+func invokeJob(args []string, stdout io.Writer) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v\n%s", r, debug.Stack())
+		}
+	}()
+	return invoke(args, stdout, false)
 }
 
 func init() {
@@ -309,8 +786,12 @@ func init() {
 }
 
 func main() {
-	err := invoke(os.Args[1:], true)
-	if err != nil {
+	err := invoke(os.Args[1:], os.Stdout, true)
+	var lf *lintFailure
+	if errors.As(err, &lf) {
+		log.Println(err)
+		os.Exit(2)
+	} else if err != nil {
 		log.Fatal(err)
 	}
 }
@@ -0,0 +1,190 @@
+package mega
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/wspr-ncsu/visiblev8/post-processor/core"
+)
+
+// COPY files can't carry PG's FK IDs (which only exist once loaded), so instances and usages name what they refer to
+// by natural key (script hash triple, instance hash, feature name hash, URL hash) instead; loading resolves them.
+
+var scriptCopyTable = &core.CopyTable{
+	Name:   "mega_scripts",
+	Fields: scriptHashImportFields[:],
+	Load: `
+LOCK TABLE mega_scripts IN SHARE ROW EXCLUSIVE MODE;
+INSERT INTO mega_scripts (sha2, sha3, size)
+	SELECT ish.sha2, ish.sha3, ish.size
+	FROM import_mega_scripts AS ish
+ON CONFLICT DO NOTHING;
+`,
+}
+
+var featureCopyTable = &core.CopyTable{
+	Name:   "mega_features",
+	Fields: featureImportFields[:],
+	Load: `
+LOCK TABLE mega_features IN SHARE ROW EXCLUSIVE MODE;
+INSERT INTO mega_features (
+		sha256, full_name, receiver_name, member_name,
+		idl_base_receiver, idl_member_role)
+	SELECT
+		imf.sha256, imf.full_name, imf.receiver_name, imf.member_name,
+		imf.idl_base_receiver, imf.idl_member_role
+	FROM import_mega_features AS imf
+ON CONFLICT DO NOTHING;
+`,
+}
+
+var instanceCopyFields = [...]string{
+	"instance_hash",
+	"logfile_id",
+	"script_sha2",
+	"script_sha3",
+	"script_size",
+	"isolate_ptr",
+	"runtime_id",
+	"origin_url_sha256",
+	"script_url_sha256",
+	"eval_parent_hash",
+}
+
+var instanceCopyTable = &core.CopyTable{
+	Name:   "mega_instances",
+	Fields: instanceCopyFields[:],
+	Schema: `instance_hash BYTEA, logfile_id TEXT, script_sha2 BYTEA, script_sha3 BYTEA, script_size INT,
+		isolate_ptr TEXT, runtime_id INT, origin_url_sha256 BYTEA, script_url_sha256 BYTEA, eval_parent_hash BYTEA`,
+	Load: `
+INSERT INTO mega_instances(
+		instance_hash, logfile_id, script_id, isolate_ptr, runtime_id,
+		origin_url_id, script_url_id, eval_parent_hash)
+	SELECT
+		ii.instance_hash, lf.id, ms.id, ii.isolate_ptr, ii.runtime_id,
+		ouu.id, suu.id, ii.eval_parent_hash
+	FROM import_mega_instances AS ii
+		INNER JOIN logfile AS lf ON (lf.uuid = ii.logfile_id)
+		INNER JOIN mega_scripts AS ms ON (ms.sha2 = ii.script_sha2 AND ms.sha3 = ii.script_sha3 AND ms.size = ii.script_size)
+		LEFT JOIN urls AS ouu ON (ouu.sha256 = ii.origin_url_sha256)
+		LEFT JOIN urls AS suu ON (suu.sha256 = ii.script_url_sha256)
+ON CONFLICT DO NOTHING;
+`,
+}
+
+var usageCopyFields = [...]string{
+	"instance_hash",
+	"feature_sha256",
+	"origin_url_sha256",
+	"usage_offset",
+	"usage_mode",
+	"usage_count",
+}
+
+var usageCopyTable = &core.CopyTable{
+	Name:   "mega_usages",
+	Fields: usageCopyFields[:],
+	Schema: `instance_hash BYTEA, feature_sha256 BYTEA, origin_url_sha256 BYTEA,
+		usage_offset INT, usage_mode CHAR(1), usage_count INT`,
+	Load: `
+INSERT INTO mega_usages (
+		instance_id, feature_id, origin_url_id,
+		usage_offset, usage_mode, usage_count)
+	SELECT
+		mi.id, mf.id, ou.id,
+		imu.usage_offset, imu.usage_mode, imu.usage_count
+	FROM import_mega_usages AS imu
+		INNER JOIN mega_instances AS mi ON (mi.instance_hash = imu.instance_hash)
+		INNER JOIN mega_features AS mf ON (mf.sha256 = imu.feature_sha256)
+		LEFT JOIN urls AS ou ON (ou.sha256 = imu.origin_url_sha256)
+ON CONFLICT DO NOTHING;
+`,
+}
+
+// CopyTables lists (in load order: referenced tables first) the COPY files written by DumpToCopy
+var CopyTables = []*core.CopyTable{core.URLCopyTable, scriptCopyTable, featureCopyTable, instanceCopyTable, usageCopyTable}
+
+// DumpToCopy writes the same scripts/instances/features/usages (and URLs) DumpToPostgresql would import
+func (agg *usageAggregator) DumpToCopy(ctx *core.AggregationContext, sink core.CopySink) error {
+	ln := ctx.Ln
+	logID := ln.ID.String()
+	ub := core.NewURLBakery()
+
+	hashes := make(map[core.ScriptHash]bool)
+	for _, iso := range ln.Isolates {
+		for _, script := range iso.Scripts {
+			if !hashes[script.CodeHash] {
+				hashes[script.CodeHash] = true
+				err := sink.CopyRow(scriptCopyTable, script.CodeHash.SHA2[:], script.CodeHash.SHA3[:], script.CodeHash.Length)
+				if err != nil {
+					return fmt.Errorf("megaFeatures.DumpToCopy/scriptHashes: %w", err)
+				}
+			}
+		}
+	}
+
+	for _, iso := range ln.Isolates {
+		for _, script := range iso.Scripts {
+			instaHash, err := hashInstance(ln, script)
+			if err != nil {
+				return err
+			}
+			var evalParentHash interface{}
+			if script.EvaledBy != nil {
+				parentHash, err := hashInstance(ln, script.EvaledBy)
+				if err != nil {
+					return err
+				}
+				evalParentHash = parentHash[:]
+			}
+			var originURLHash, scriptURLHash interface{}
+			if script.FirstOrigin != nil && script.FirstOrigin.Origin != "" {
+				temp := ub.URLToHash(script.FirstOrigin.Origin)
+				originURLHash = temp[:]
+			}
+			if script.URL != "" {
+				temp := ub.URLToHash(script.URL)
+				scriptURLHash = temp[:]
+			}
+			err = sink.CopyRow(instanceCopyTable,
+				instaHash[:], logID, script.CodeHash.SHA2[:], script.CodeHash.SHA3[:], script.CodeHash.Length,
+				script.Isolate.ID, script.ID, originURLHash, scriptURLHash, evalParentHash)
+			if err != nil {
+				return fmt.Errorf("megaFeatures.DumpToCopy/scriptInstances: %w", err)
+			}
+		}
+	}
+
+	for _, feature := range agg.features {
+		featureHash := sha256.Sum256([]byte(feature.fullName))
+		err := sink.CopyRow(featureCopyTable,
+			featureHash[:],
+			feature.fullName,
+			core.NullableString(feature.receiverName),
+			core.NullableString(feature.memberName),
+			core.NullableString(feature.idlInfo.BaseInterface),
+			core.NullableRune(feature.idlInfo.MemberRole))
+		if err != nil {
+			return fmt.Errorf("megaFeatures.DumpToCopy/distinctFeatures: %w", err)
+		}
+	}
+
+	for usage, count := range agg.usageCounts {
+		instaHash, err := hashInstance(ln, usage.script)
+		if err != nil {
+			return err
+		}
+		featureHash := sha256.Sum256([]byte(usage.feature.fullName))
+		originURLHash := ub.URLToHash(usage.origin)
+		err = sink.CopyRow(usageCopyTable,
+			instaHash[:], featureHash[:], originURLHash[:], usage.offset, core.NullableRune(usage.mode), count)
+		if err != nil {
+			return fmt.Errorf("megaFeatures.DumpToCopy/usageCounts: %w", err)
+		}
+	}
+
+	if err := ub.CopyBakedURLs(sink); err != nil {
+		return fmt.Errorf("megaFeatures.DumpToCopy/urls: %w", err)
+	}
+	return nil
+}
@@ -5,8 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"strconv"
-	"strings"
+	"sort"
 
 	"github.com/wspr-ncsu/visiblev8/post-processor/core"
 )
@@ -25,7 +24,7 @@ type Usage struct {
 	origin  string           // under what SOP origin URL (execution context)
 	feature *Feature         // was what feature used
 	offset  int              // at what callsite (offset in bytes within script text)
-	mode    rune             // in what way (g = get, s = set, c = call, n = new)
+	mode    rune             // in what way (g = get, s = set, c = call, n = new, j = "j" record API access)
 }
 
 // usageAggregator tracks all scripts/instances/features/usages recorded in a VV8 logfile
@@ -33,6 +32,21 @@ type usageAggregator struct {
 	idlTree     core.IDLTree        // IDL database
 	features    map[string]*Feature // lookup map of distinct features seen
 	usageCounts map[Usage]int       // counter of each distinct usage tuple we see
+
+	// Stream-output IDs, assigned once (so repeated dumps, e.g., snapshots while following a log, agree)
+	streamIDs streamIDs
+}
+
+// streamIDs numbers the records of DumpToStream, in order of first appearance
+type streamIDs struct {
+	scripts   map[core.ScriptHash]int
+	instances map[*core.ScriptInfo]int
+	features  map[*Feature]int
+	usages    map[Usage]int
+
+	instanceOrder []*core.ScriptInfo
+	featureOrder  []*Feature
+	usageOrder    []Usage
 }
 
 // NewAggregator creates a megaFeatures (Mfeatures) aggregator for scripts/instances/features/usages
@@ -45,34 +59,30 @@ func NewAggregator() (core.Aggregator, error) {
 		idlTree:     idlTree,
 		features:    make(map[string]*Feature),
 		usageCounts: make(map[Usage]int),
+		streamIDs: streamIDs{
+			scripts:   make(map[core.ScriptHash]int),
+			instances: make(map[*core.ScriptInfo]int),
+			features:  make(map[*Feature]int),
+			usages:    make(map[Usage]int),
+		},
 	}, nil
 }
 
-// IngestRecord does nothing for MicroScriptsAggregators (since we care only about the scripts processed by the core framework)
+// IngestRecord feeds a raw trace record through the typed-record path
 func (agg *usageAggregator) IngestRecord(ctx *core.ExecutionContext, lineNumber int, op byte, fields []string) error {
+	return core.IngestAsRecord(agg, ctx, lineNumber, op, fields)
+}
+
+// IngestTypedRecord counts feature usage (by script instance, origin, callsite, and mode) for each trace record
+func (agg *usageAggregator) IngestTypedRecord(ctx *core.ExecutionContext, rec *core.Record) error {
 	// Only in a valid script/execution context...
 	if (ctx.Script != nil) && !ctx.Script.VisibleV8 && (ctx.Origin.Origin != "") {
-		// Raw field parsing/handling (offset, receiver/member, filtering, full-name)
-		offset, err := strconv.Atoi(fields[0])
-		if err != nil {
-			return fmt.Errorf("%d: invalid script offset '%s'", lineNumber, fields[0])
-		}
-		var receiver, member string
-		switch op {
-		case 'g', 's':
-			receiver, _ = core.StripCurlies(fields[1])
-			member, _ = core.StripQuotes(fields[2])
-		case 'n':
-			receiver, _ = core.StripCurlies(fields[1])
-			receiver = strings.TrimPrefix(receiver, "%")
-		case 'c':
-			receiver, _ = core.StripCurlies(fields[2])
-			member, _ = core.StripQuotes(fields[1])
-
-			member = strings.TrimPrefix(member, "%")
+		switch rec.Op {
+		case 'g', 's', 'n', 'c', 'j':
 		default:
-			return fmt.Errorf("%d: invalid mode '%c'; fields: %v", lineNumber, op, fields)
+			return fmt.Errorf("%d: invalid mode '%c'; fields: %v", rec.Line, rec.Op, rec.Fields)
 		}
+		receiver, member := rec.Receiver, rec.Member
 
 		if core.FilterName(member) {
 			// We have some names (V8 special cases, numeric indices) that are never useful
@@ -88,14 +98,6 @@ func (agg *usageAggregator) IngestRecord(ctx *core.ExecutionContext, lineNumber
 			}
 		}
 
-		if strings.Contains(fullName, ",") {
-			fullName = strings.Split(fullName, ",")[1]
-		}
-
-		if strings.Contains(receiver, ",") {
-			receiver = strings.Split(receiver, ",")[1]
-		}
-
 		// Feature-map lookup/population (with IDL lookup)
 		feature, ok := agg.features[fullName]
 		if !ok {
@@ -116,68 +118,125 @@ func (agg *usageAggregator) IngestRecord(ctx *core.ExecutionContext, lineNumber
 			script:  ctx.Script,
 			origin:  ctx.Origin.Origin,
 			feature: feature,
-			offset:  offset,
-			mode:    rune(op),
+			offset:  rec.Offset,
+			mode:    rune(rec.Op),
 		}
 		agg.usageCounts[usage]++
 	}
 	return nil
 }
 
-// DumpToStream sends feature/script/blob data to stdout for inspection
-func (agg *usageAggregator) DumpToStream(ctx *core.AggregationContext, stream io.Writer) error {
-	jstream := json.NewEncoder(stream)
-
-	scriptHashID := 0
-	scriptInstanceID := 0
-	scriptHashes := make(map[core.ScriptHash]int)
-	scriptInstances := make(map[*core.ScriptInfo]int)
-	for _, iso := range ctx.Ln.Isolates {
-		for _, script := range iso.Scripts {
-			scriptHashID++
-			scriptHashes[script.CodeHash] = scriptHashID
-			scriptInstanceID++
-			scriptInstances[script] = scriptInstanceID
+// assign numbers the scripts/instances/features/usages not numbered by an earlier dump
+// (new ones in a fixed order: isolates by pointer, scripts by runtime ID, features by name, usages by their parts)
+func (ids *streamIDs) assign(ln *core.LogInfo, agg *usageAggregator) {
+	isolates := make([]string, 0, len(ln.Isolates))
+	for isoID := range ln.Isolates {
+		isolates = append(isolates, isoID)
+	}
+	sort.Strings(isolates)
+	for _, isoID := range isolates {
+		iso := ln.Isolates[isoID]
+		scriptIDs := make([]int, 0, len(iso.Scripts))
+		for scriptID := range iso.Scripts {
+			scriptIDs = append(scriptIDs, scriptID)
+		}
+		sort.Ints(scriptIDs)
+		for _, scriptID := range scriptIDs {
+			script := iso.Scripts[scriptID]
+			if _, ok := ids.instances[script]; ok {
+				continue
+			}
+			if _, ok := ids.scripts[script.CodeHash]; !ok {
+				ids.scripts[script.CodeHash] = len(ids.scripts) + 1
+			}
+			ids.instanceOrder = append(ids.instanceOrder, script)
+			ids.instances[script] = len(ids.instanceOrder)
 		}
 	}
 
-	featureID := 0
-	featureRecords := make(map[*Feature]int)
+	var newFeatures []*Feature
 	for _, feature := range agg.features {
-		featureID++
-		featureRecords[feature] = featureID
+		if _, ok := ids.features[feature]; !ok {
+			newFeatures = append(newFeatures, feature)
+		}
+	}
+	sort.Slice(newFeatures, func(i, j int) bool { return newFeatures[i].fullName < newFeatures[j].fullName })
+	for _, feature := range newFeatures {
+		ids.featureOrder = append(ids.featureOrder, feature)
+		ids.features[feature] = len(ids.featureOrder)
 	}
 
-	for hash, id := range scriptHashes {
+	var newUsages []Usage
+	for usage := range agg.usageCounts {
+		if _, ok := ids.usages[usage]; !ok {
+			newUsages = append(newUsages, usage)
+		}
+	}
+	sort.Slice(newUsages, func(i, j int) bool {
+		a, b := newUsages[i], newUsages[j]
+		if ia, ib := ids.instances[a.script], ids.instances[b.script]; ia != ib {
+			return ia < ib
+		} else if fa, fb := ids.features[a.feature], ids.features[b.feature]; fa != fb {
+			return fa < fb
+		} else if a.offset != b.offset {
+			return a.offset < b.offset
+		} else if a.mode != b.mode {
+			return a.mode < b.mode
+		}
+		return a.origin < b.origin
+	})
+	for _, usage := range newUsages {
+		ids.usageOrder = append(ids.usageOrder, usage)
+		ids.usages[usage] = len(ids.usageOrder)
+	}
+}
+
+// DumpToStream sends feature/script/blob data to stdout for inspection
+//
+// Record IDs stay the same across repeated dumps (e.g., snapshots while following a log); instances carry a
+// logfile_id only once the log is fully ingested (i.e., its content-derived ID exists).
+func (agg *usageAggregator) DumpToStream(ctx *core.AggregationContext, stream io.Writer) error {
+	jstream := json.NewEncoder(stream)
+	ids := &agg.streamIDs
+	ids.assign(ctx.Ln, agg)
+
+	scriptsDone := make(map[core.ScriptHash]bool)
+	for _, script := range ids.instanceOrder {
+		hash := script.CodeHash
+		if scriptsDone[hash] {
+			continue
+		}
+		scriptsDone[hash] = true
 		jstream.Encode(core.JSONArray{"mega_script", core.JSONObject{
-			"id":   id,
+			"id":   ids.scripts[hash],
 			"sha2": hex.EncodeToString(hash.SHA2[:]),
 			"sha3": hex.EncodeToString(hash.SHA3[:]),
 			"size": hash.Length,
 		}})
 	}
 
-	for script, id := range scriptInstances {
+	for _, script := range ids.instanceOrder {
 		var evalParentID int
 		if script.EvaledBy != nil {
-			evalParentID = scriptInstances[script.EvaledBy]
+			evalParentID = ids.instances[script.EvaledBy]
 		}
 
-		jstream.Encode(core.JSONArray{"mega_instance", core.JSONObject{
-			"id":             id,
-			"script_id":      scriptHashes[script.CodeHash],
-			"logfile_id":     ctx.Ln.ID.String(),
+		instance := core.JSONObject{
+			"id":             ids.instances[script],
+			"script_id":      ids.scripts[script.CodeHash],
 			"isolate_ptr":    script.Isolate.ID,
 			"runtime_id":     script.ID,
 			"first_origin":   script.FirstOrigin,
 			"load_url":       script.URL,
 			"eval_parent_id": evalParentID,
-		}})
+			"logfile_id":     ctx.Ln.ID.String(),
+		}
+		jstream.Encode(core.JSONArray{"mega_instance", instance})
 	}
 
-	for feature, id := range featureRecords {
+	for _, feature := range ids.featureOrder {
 		jstream.Encode(core.JSONArray{"mega_feature", core.JSONObject{
-			"id":                id,
+			"id":                ids.features[feature],
 			"full_name":         feature.fullName,
 			"receiver_name":     feature.receiverName,
 			"member_name":       feature.memberName,
@@ -186,16 +245,14 @@ func (agg *usageAggregator) DumpToStream(ctx *core.AggregationContext, stream io
 		}})
 	}
 
-	usageID := 0
-	for usage, count := range agg.usageCounts {
-		usageID++
+	for _, usage := range ids.usageOrder {
 		jstream.Encode(core.JSONArray{"mega_usage", core.JSONObject{
-			"id":          usageID,
-			"instance_id": scriptInstances[usage.script],
-			"feature_id":  featureRecords[usage.feature],
+			"id":          ids.usages[usage],
+			"instance_id": ids.instances[usage.script],
+			"feature_id":  ids.features[usage.feature],
 			"offset":      usage.offset,
 			"mode":        fmt.Sprintf("%c", usage.mode),
-			"count":       count,
+			"count":       agg.usageCounts[usage],
 		}})
 	}
 
@@ -39,23 +39,30 @@ func (agg *usageAggregator) DumpToPostgresql(ctx *core.AggregationContext, sqlDb
 		return fmt.Errorf("megaFeatures.DumpToMongresql/logFile: %w", err)
 	}
 
+	// Every step below round-trips through per-session temp import tables, so pin one pooled connection for all of them
+	conn, err := sqlDb.Conn(context.Background())
+	if err != nil {
+		return fmt.Errorf("megaFeatures.DumpToMongresql/conn: %w", err)
+	}
+	defer conn.Close()
+
 	// Step 1: import the new script-body-hashes and build a hash->ID mapping for subsequent phases
-	if err = pctx.sqlDumpScriptHashes(sqlDb, ctx.Ln); err != nil {
+	if err = pctx.sqlDumpScriptHashes(conn, ctx.Ln); err != nil {
 		return fmt.Errorf("megaFeatures.DumpToMongresql/scriptHashes: %w", err)
 	}
 
 	// Step 2: import all loaded-instances of the scripts and build a scriptInfo->ID mapping for subsequent phases
-	if err = pctx.sqlDumpScriptInstances(sqlDb, ctx.Ln); err != nil {
+	if err = pctx.sqlDumpScriptInstances(conn, ctx.Ln); err != nil {
 		return fmt.Errorf("megaFeatures.DumpToMongresql/scriptInstances: %w", err)
 	}
 
 	// Step 3: import the new distinct feature names (and metadata) and build a name->ID mapping for subsequent phases
-	if err = pctx.sqlDumpDistinctFeatures(sqlDb, agg); err != nil {
+	if err = pctx.sqlDumpDistinctFeatures(conn, agg); err != nil {
 		return fmt.Errorf("megaFeatures.DumpToMongresql/distinctFeatures: %w", err)
 	}
 
 	// Step 4: import the aggregated usage counts (referencing features and instances/scripts)
-	if err = pctx.sqlDumpUsageCounts(sqlDb, agg); err != nil {
+	if err = pctx.sqlDumpUsageCounts(conn, agg); err != nil {
 		return fmt.Errorf("megaFeatures.DumpToMongresql/usageCounts: %w", err)
 	}
 	log.Printf("Mfeatures.DumpToMongresql: done.")
@@ -68,7 +75,7 @@ var scriptHashImportFields = [...]string{
 	"size",
 }
 
-func (pctx *postgresqlContext) sqlDumpScriptHashes(sqlDb *sql.DB, ln *core.LogInfo) error {
+func (pctx *postgresqlContext) sqlDumpScriptHashes(sqlDb core.SQLSession, ln *core.LogInfo) error {
 	// Step 1a: compute/bulk-insert the set of distinct script [hashes] loaded into an import table
 	//---------------------------------------------------------------------------------------------
 	log.Printf("Mfeatures.sqlDumpScriptHashes: creating temp table 'import_scripts'...")
@@ -77,7 +84,7 @@ func (pctx *postgresqlContext) sqlDumpScriptHashes(sqlDb *sql.DB, ln *core.LogIn
 	}
 	defer func() {
 		log.Printf("Mfeature.sqlDumpScriptHashes: dropping temp table 'import_scripts'...")
-		_, err := sqlDb.Exec("DROP TABLE import_scripts;")
+		_, err := sqlDb.ExecContext(context.Background(), "DROP TABLE import_scripts;")
 		if err != nil {
 			log.Printf("Mfeatures.sqlDumpScriptHashes: failed to drop `import_scripts` temp table (%v)\n", err)
 		}
@@ -165,7 +172,7 @@ ON CONFLICT DO NOTHING;
 
 	// Step 1c: lookup permanent IDs of all scripts in the import table before dropping (retain the mapping)
 	//------------------------------------------------------------------------------------------------------
-	lookupRows, err := sqlDb.Query(`
+	lookupRows, err := sqlDb.QueryContext(context.Background(), `
 		SELECT id, sha2, sha3, size
 		FROM mega_scripts AS ms
 		INNER JOIN import_scripts AS ish USING (sha2, sha3, size)
@@ -173,6 +180,7 @@ ON CONFLICT DO NOTHING;
 	if err != nil {
 		return err
 	}
+	defer lookupRows.Close()
 	for lookupRows.Next() {
 		var sid int
 		var shash core.ScriptHash
@@ -225,7 +233,7 @@ var instanceImportFields = [...]string{
 	"eval_parent_hash",
 }
 
-func (pctx *postgresqlContext) sqlDumpScriptInstances(sqlDb *sql.DB, ln *core.LogInfo) error {
+func (pctx *postgresqlContext) sqlDumpScriptInstances(sqlDb core.SQLSession, ln *core.LogInfo) error {
 	// Step 2a: bulk-insert into import table (generating instance-hashes along the way)
 	//----------------------------------------------------------------------------------
 	log.Printf("Mfeatures.sqlDumpScriptInstances: creating temp table 'import_instances'...")
@@ -234,7 +242,7 @@ func (pctx *postgresqlContext) sqlDumpScriptInstances(sqlDb *sql.DB, ln *core.Lo
 	}
 	defer func() {
 		log.Printf("Mfeature.sqlDumpScriptInstances: dropping temp table 'import_instances'...")
-		_, err := sqlDb.Exec("DROP TABLE import_instances;")
+		_, err := sqlDb.ExecContext(context.Background(), "DROP TABLE import_instances;")
 		if err != nil {
 			log.Printf("Mfeatures.sqlDumpScriptInstances: failed to drop `import_instances` temp table (%v)\n", err)
 		}
@@ -313,7 +321,7 @@ func (pctx *postgresqlContext) sqlDumpScriptInstances(sqlDb *sql.DB, ln *core.Lo
 	// Step 2b: copy-insert import data into permanent table (upsert)
 	//---------------------------------------------------------------------------------
 	log.Printf("Mfeatures.sqlDumpScriptInstances: copy-upserting into permanent table...")
-	copyResult, err := sqlDb.Exec(`
+	copyResult, err := sqlDb.ExecContext(context.Background(), `
 INSERT INTO mega_instances(
 		instance_hash, logfile_id, script_id, isolate_ptr, runtime_id,
 		origin_url_id, script_url_id, eval_parent_hash)
@@ -336,7 +344,7 @@ ON CONFLICT DO NOTHING
 
 	// Step 2c: lookup permanent IDs of all instances in the import table before dropping (retain the mapping)
 	//--------------------------------------------------------------------------------------------------------
-	lookupRows, err := sqlDb.Query(`
+	lookupRows, err := sqlDb.QueryContext(context.Background(), `
 SELECT id, instance_hash
 FROM mega_instances AS mi
 	INNER JOIN import_instances AS ii USING (instance_hash)
@@ -344,6 +352,7 @@ FROM mega_instances AS mi
 	if err != nil {
 		return err
 	}
+	defer lookupRows.Close()
 	if pctx.instanceMap == nil {
 		pctx.instanceMap = make(instanceMetaMap)
 	}
@@ -374,7 +383,7 @@ var featureImportFields = [...]string{
 	"idl_member_role",
 }
 
-func (pctx *postgresqlContext) sqlDumpDistinctFeatures(sqlDb *sql.DB, agg *usageAggregator) error {
+func (pctx *postgresqlContext) sqlDumpDistinctFeatures(sqlDb core.SQLSession, agg *usageAggregator) error {
 	// Step 3a: bulk-insert the set of distinct feature [names] observed
 	//------------------------------------------------------------------
 	log.Printf("Mfeatures.sqlDumpDistinctFeatures: creating temp table 'import_features'...")
@@ -383,7 +392,7 @@ func (pctx *postgresqlContext) sqlDumpDistinctFeatures(sqlDb *sql.DB, agg *usage
 	}
 	defer func() {
 		log.Printf("Mfeature.sqlDumpDistinctFeatures: dropping temp table 'import_features'...")
-		_, err := sqlDb.Exec("DROP TABLE import_features;")
+		_, err := sqlDb.ExecContext(context.Background(), "DROP TABLE import_features;")
 		if err != nil {
 			log.Printf("Mfeatures.sqlDumpDistinctFeatures: failed to drop `import_features` temp table (%v)\n", err)
 		}
@@ -471,7 +480,7 @@ ON CONFLICT DO NOTHING;
 
 	// Step 3c: lookup permanent IDs of all features in the import table before dropping (retain the mapping)
 	//-------------------------------------------------------------------------------------------------------
-	lookupRows, err := sqlDb.Query(`
+	lookupRows, err := sqlDb.QueryContext(context.Background(), `
 SELECT mf.id, mf.full_name
 FROM mega_features AS mf
 	INNER JOIN import_features AS imf USING (full_name)
@@ -479,6 +488,7 @@ FROM mega_features AS mf
 	if err != nil {
 		return err
 	}
+	defer lookupRows.Close()
 	if pctx.featureMap == nil {
 		pctx.featureMap = make(featureNameMap)
 	}
@@ -502,7 +512,7 @@ var usageImportFields = [...]string{
 	"usage_count",
 }
 
-func (pctx *postgresqlContext) sqlDumpUsageCounts(sqlDb *sql.DB, agg *usageAggregator) error {
+func (pctx *postgresqlContext) sqlDumpUsageCounts(sqlDb core.SQLSession, agg *usageAggregator) error {
 	// Step 4a. Insert raw tuples (with URL hashes) into temp import table
 	log.Printf("Mfeatures.sqlDumpUsageCounts: creating temp table 'import_usages'...")
 	if err := core.CreateImportTable(sqlDb, "mega_usages_import_schema", "import_usages"); err != nil {
@@ -510,7 +520,7 @@ func (pctx *postgresqlContext) sqlDumpUsageCounts(sqlDb *sql.DB, agg *usageAggre
 	}
 	defer func() {
 		log.Printf("Mfeature.sqlDumpUsageCounts: dropping temp table 'import_usages'...")
-		_, err := sqlDb.Exec("DROP TABLE import_usages;")
+		_, err := sqlDb.ExecContext(context.Background(), "DROP TABLE import_usages;")
 		if err != nil {
 			log.Printf("Mfeatures.sqlDumpUsageCounts: failed to drop `import_usages` temp table (%v)\n", err)
 		}
@@ -559,7 +569,7 @@ func (pctx *postgresqlContext) sqlDumpUsageCounts(sqlDb *sql.DB, agg *usageAggre
 	// Step 4b: copy-insert into the permanent usage table (upsert; dropping dups)
 	//------------------------------------------------------------------------------
 	log.Printf("Mfeatures.sqlDumpDistinctUsages: copy-upserting into permanent table...")
-	copyResult, err := sqlDb.Exec(`
+	copyResult, err := sqlDb.ExecContext(context.Background(), `
 INSERT INTO mega_usages (
 		instance_id, feature_id, origin_url_id,
 		usage_offset, usage_mode, usage_count)
@@ -0,0 +1,379 @@
+package mega
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"fmt"
+	"log"
+
+	"github.com/wspr-ncsu/visiblev8/post-processor/core"
+)
+
+// DumpToSQLite runs the same 4-step import as DumpToPostgresql against a SQLite database (all within one transaction)
+func (agg *usageAggregator) DumpToSQLite(ctx *core.AggregationContext, tx *sql.Tx) error {
+	var err error
+	var pctx postgresqlContext // (same FK-ID mappings, just SQLite IDs)
+
+	// Step 0: Make sure the current log file is inserted (and get its ID)
+	pctx.logfileID, err = ctx.Ln.InsertLogfile(tx)
+	if err != nil {
+		return fmt.Errorf("megaFeatures.DumpToSQLite/logFile: %w", err)
+	}
+
+	// Step 1: import the new script-body-hashes and build a hash->ID mapping for subsequent phases
+	if err = pctx.sqliteDumpScriptHashes(tx, ctx.Ln); err != nil {
+		return fmt.Errorf("megaFeatures.DumpToSQLite/scriptHashes: %w", err)
+	}
+
+	// Step 2: import all loaded-instances of the scripts and build a scriptInfo->ID mapping for subsequent phases
+	if err = pctx.sqliteDumpScriptInstances(tx, ctx.Ln); err != nil {
+		return fmt.Errorf("megaFeatures.DumpToSQLite/scriptInstances: %w", err)
+	}
+
+	// Step 3: import the new distinct feature names (and metadata) and build a name->ID mapping for subsequent phases
+	if err = pctx.sqliteDumpDistinctFeatures(tx, agg); err != nil {
+		return fmt.Errorf("megaFeatures.DumpToSQLite/distinctFeatures: %w", err)
+	}
+
+	// Step 4: import the aggregated usage counts (referencing features and instances/scripts)
+	if err = pctx.sqliteDumpUsageCounts(tx, agg); err != nil {
+		return fmt.Errorf("megaFeatures.DumpToSQLite/usageCounts: %w", err)
+	}
+	log.Printf("Mfeatures.DumpToSQLite: done.")
+	return nil
+}
+
+func (pctx *postgresqlContext) sqliteDumpScriptHashes(tx *sql.Tx, ln *core.LogInfo) error {
+	// Step 1a: compute/bulk-insert the set of distinct script [hashes] loaded into an import table
+	if err := core.CreateSQLiteImportTable(tx, "mega_scripts_import_schema", "import_scripts"); err != nil {
+		return err
+	}
+	defer core.DropSQLiteImportTable(tx, "Mfeatures.sqliteDumpScriptHashes", "import_scripts")
+
+	pctx.hashMap = make(scriptHashIDMap)
+	var hashes []core.ScriptHash
+	for _, iso := range ln.Isolates {
+		for _, script := range iso.Scripts {
+			if _, ok := pctx.hashMap[script.CodeHash]; !ok {
+				pctx.hashMap[script.CodeHash] = 0 // placeholder: later to be replaced by SQLite FK ID
+				hashes = append(hashes, script.CodeHash)
+			}
+		}
+	}
+	next := 0
+	importRows, err := core.BulkInsertRowsSQLite(
+		tx, "MFeatures.sqliteDumpScriptHashes", "import_scripts",
+		scriptHashImportFields[:],
+		func() ([]interface{}, error) {
+			if next == len(hashes) {
+				return nil, nil // end-of-stream
+			}
+			shash := hashes[next]
+			next++
+			return []interface{}{shash.SHA2[:], shash.SHA3[:], shash.Length}, nil
+		})
+	if err != nil {
+		return err
+	}
+
+	// Step 1b: copy-insert into the permanent script-body table (upsert; dropping dups)
+	copyResult, err := tx.Exec(`
+INSERT INTO mega_scripts (sha2, sha3, size)
+	SELECT ish.sha2, ish.sha3, ish.size
+	FROM import_scripts AS ish
+	WHERE true
+ON CONFLICT DO NOTHING;
+`)
+	if err != nil {
+		return err
+	}
+	insertRows, err := copyResult.RowsAffected()
+	if err != nil {
+		return err
+	}
+	log.Printf("Mfeatures.sqliteDumpScriptHashes: inserted %d (out of %d) import rows\n", insertRows, importRows)
+
+	// Step 1c: lookup permanent IDs of all scripts in the import table before dropping (retain the mapping)
+	lookupRows, err := tx.Query(`
+		SELECT id, sha2, sha3, size
+		FROM mega_scripts AS ms
+		INNER JOIN import_scripts AS ish USING (sha2, sha3, size)
+	`)
+	if err != nil {
+		return err
+	}
+	defer lookupRows.Close()
+	for lookupRows.Next() {
+		var sid int
+		var shash core.ScriptHash
+		var sha2Slice, sha3Slice []byte
+		if err = lookupRows.Scan(&sid, &sha2Slice, &sha3Slice, &shash.Length); err != nil {
+			return err
+		}
+		copy(shash.SHA2[:], sha2Slice)
+		copy(shash.SHA3[:], sha3Slice)
+		pctx.hashMap[shash] = sid
+	}
+	return lookupRows.Err()
+}
+
+func (pctx *postgresqlContext) sqliteDumpScriptInstances(tx *sql.Tx, ln *core.LogInfo) error {
+	// Step 2a: bulk-insert into import table (generating instance-hashes along the way)
+	if err := core.CreateSQLiteImportTable(tx, "mega_instances_import_schema", "import_instances"); err != nil {
+		return err
+	}
+	defer core.DropSQLiteImportTable(tx, "Mfeatures.sqliteDumpScriptInstances", "import_instances")
+
+	var scripts []*core.ScriptInfo
+	for _, iso := range ln.Isolates {
+		for _, script := range iso.Scripts {
+			scripts = append(scripts, script)
+		}
+	}
+
+	ub := core.NewURLBakery()
+	rimap := make(reverseInstanceMetaMap)
+	next := 0
+	importRows, err := core.BulkInsertRowsSQLite(
+		tx, "MFeatures.sqliteDumpScriptInstances", "import_instances",
+		instanceImportFields[:],
+		func() ([]interface{}, error) {
+			if next == len(scripts) {
+				return nil, nil // end-of-stream
+			}
+			script := scripts[next]
+			next++
+			instaHash, err := hashInstance(ln, script)
+			if err != nil {
+				return nil, err
+			}
+			var evalParentHash interface{}
+			if script.EvaledBy != nil {
+				temp, err := hashInstance(ln, script.EvaledBy)
+				if err != nil {
+					return nil, err
+				}
+				evalParentHash = temp[:]
+			}
+
+			var originURLHash, scriptURLHash interface{}
+			if script.FirstOrigin != nil && script.FirstOrigin.Origin != "" {
+				temp := ub.URLToHash(script.FirstOrigin.Origin)
+				originURLHash = temp[:]
+			}
+			if script.URL != "" {
+				temp := ub.URLToHash(script.URL)
+				scriptURLHash = temp[:]
+			}
+			rimap[instaHash] = script
+
+			return []interface{}{
+				instaHash[:],
+				pctx.logfileID,
+				pctx.hashMap[script.CodeHash],
+				script.Isolate.ID,
+				script.ID,
+				originURLHash,
+				scriptURLHash,
+				evalParentHash,
+			}, nil
+		})
+	if err != nil {
+		return err
+	}
+
+	// Step 2a[ii]: bulk-insert the processed URLs we used (if any)
+	if err = ub.InsertBakedURLsSQLite(tx); err != nil {
+		return err
+	}
+
+	// Step 2b: copy-insert import data into permanent table (upsert)
+	copyResult, err := tx.Exec(`
+INSERT INTO mega_instances(
+		instance_hash, logfile_id, script_id, isolate_ptr, runtime_id,
+		origin_url_id, script_url_id, eval_parent_hash)
+	SELECT
+		ii.instance_hash, ii.logfile_id, ii.script_id, ii.isolate_ptr, ii.runtime_id,
+		ouu.id, suu.id, ii.eval_parent_hash
+	FROM import_instances AS ii
+		LEFT JOIN urls AS ouu ON (ouu.sha256 = ii.origin_url_sha256)
+		LEFT JOIN urls AS suu ON (suu.sha256 = ii.script_url_sha256)
+	WHERE true
+ON CONFLICT DO NOTHING
+`)
+	if err != nil {
+		return err
+	}
+	insertRows, err := copyResult.RowsAffected()
+	if err != nil {
+		return err
+	}
+	log.Printf("Mfeatures.sqliteDumpScriptInstances: inserted %d (out of %d) import rows\n", insertRows, importRows)
+
+	// Step 2c: lookup permanent IDs of all instances in the import table before dropping (retain the mapping)
+	lookupRows, err := tx.Query(`
+SELECT id, instance_hash
+FROM mega_instances AS mi
+	INNER JOIN import_instances AS ii USING (instance_hash)
+`)
+	if err != nil {
+		return err
+	}
+	defer lookupRows.Close()
+	pctx.instanceMap = make(instanceMetaMap)
+	for lookupRows.Next() {
+		var instanceID int
+		var hashSlice []byte
+		if err = lookupRows.Scan(&instanceID, &hashSlice); err != nil {
+			return err
+		}
+		var hashArray reverseInstanceKey
+		copy(hashArray[:], hashSlice)
+		pctx.instanceMap[rimap[hashArray]] = instanceID
+	}
+	return lookupRows.Err()
+}
+
+func (pctx *postgresqlContext) sqliteDumpDistinctFeatures(tx *sql.Tx, agg *usageAggregator) error {
+	// Step 3a: bulk-insert the set of distinct feature [names] observed
+	if err := core.CreateSQLiteImportTable(tx, "mega_features_import_schema", "import_features"); err != nil {
+		return err
+	}
+	defer core.DropSQLiteImportTable(tx, "Mfeatures.sqliteDumpDistinctFeatures", "import_features")
+
+	features := make([]*Feature, 0, len(agg.features))
+	for _, feature := range agg.features {
+		features = append(features, feature)
+	}
+	next := 0
+	importRows, err := core.BulkInsertRowsSQLite(
+		tx, "MFeatures.sqliteDumpDistinctFeatures", "import_features",
+		featureImportFields[:],
+		func() ([]interface{}, error) {
+			if next == len(features) {
+				return nil, nil // end-of-stream
+			}
+			feature := features[next]
+			next++
+			featureHash := sha256.Sum256([]byte(feature.fullName))
+			return []interface{}{
+				featureHash[:],
+				feature.fullName,
+				core.NullableString(feature.receiverName),
+				core.NullableString(feature.memberName),
+				core.NullableString(feature.idlInfo.BaseInterface),
+				core.NullableRune(feature.idlInfo.MemberRole),
+			}, nil
+		})
+	if err != nil {
+		return err
+	}
+
+	// Step 3b: copy-insert into the permanent feature table (upsert; dropping dups)
+	copyResult, err := tx.Exec(`
+INSERT INTO mega_features (
+		sha256, full_name, receiver_name, member_name,
+		idl_base_receiver, idl_member_role)
+	SELECT
+		imf.sha256, imf.full_name, imf.receiver_name, imf.member_name,
+		imf.idl_base_receiver, imf.idl_member_role
+	FROM import_features AS imf
+	WHERE true
+ON CONFLICT DO NOTHING;
+`)
+	if err != nil {
+		return err
+	}
+	insertRows, err := copyResult.RowsAffected()
+	if err != nil {
+		return err
+	}
+	log.Printf("Mfeatures.sqliteDumpDistinctFeatures: inserted %d (out of %d) import rows\n", insertRows, importRows)
+
+	// Step 3c: lookup permanent IDs of all features in the import table before dropping (retain the mapping)
+	lookupRows, err := tx.Query(`
+SELECT mf.id, mf.full_name
+FROM mega_features AS mf
+	INNER JOIN import_features AS imf USING (full_name)
+`)
+	if err != nil {
+		return err
+	}
+	defer lookupRows.Close()
+	pctx.featureMap = make(featureNameMap)
+	for lookupRows.Next() {
+		var fid int
+		var name string
+		if err = lookupRows.Scan(&fid, &name); err != nil {
+			return err
+		}
+		pctx.featureMap[name] = fid
+	}
+	return lookupRows.Err()
+}
+
+func (pctx *postgresqlContext) sqliteDumpUsageCounts(tx *sql.Tx, agg *usageAggregator) error {
+	// Step 4a. Insert raw tuples (with URL hashes) into temp import table
+	if err := core.CreateSQLiteImportTable(tx, "mega_usages_import_schema", "import_usages"); err != nil {
+		return err
+	}
+	defer core.DropSQLiteImportTable(tx, "Mfeatures.sqliteDumpUsageCounts", "import_usages")
+
+	usages := make([]Usage, 0, len(agg.usageCounts))
+	for usage := range agg.usageCounts {
+		usages = append(usages, usage)
+	}
+	ub := core.NewURLBakery()
+	next := 0
+	importRows, err := core.BulkInsertRowsSQLite(
+		tx, "MFeatures.sqliteDumpUsageCounts", "import_usages",
+		usageImportFields[:],
+		func() ([]interface{}, error) {
+			if next == len(usages) {
+				return nil, nil // end-of-stream
+			}
+			usage := usages[next]
+			next++
+			originURLHash := ub.URLToHash(usage.origin)
+			return []interface{}{
+				pctx.instanceMap[usage.script],
+				pctx.featureMap[usage.feature.fullName],
+				originURLHash[:],
+				usage.offset,
+				core.NullableRune(usage.mode),
+				agg.usageCounts[usage],
+			}, nil
+		})
+	if err != nil {
+		return err
+	}
+
+	// Step 4a[ii]: bulk-insert the processed URLs we used (if any)
+	if err = ub.InsertBakedURLsSQLite(tx); err != nil {
+		return err
+	}
+
+	// Step 4b: copy-insert into the permanent usage table (upsert; dropping dups)
+	copyResult, err := tx.Exec(`
+INSERT INTO mega_usages (
+		instance_id, feature_id, origin_url_id,
+		usage_offset, usage_mode, usage_count)
+	SELECT
+		instance_id, feature_id, ou.id,
+		usage_offset, usage_mode, usage_count
+	FROM import_usages AS imu
+		LEFT JOIN urls AS ou ON (ou.sha256 = imu.origin_url_sha256)
+	WHERE true
+ON CONFLICT DO NOTHING;
+`)
+	if err != nil {
+		return err
+	}
+	insertRows, err := copyResult.RowsAffected()
+	if err != nil {
+		return err
+	}
+	log.Printf("Mfeatures.sqliteDumpUsageCounts: inserted %d (out of %d) import rows\n", insertRows, importRows)
+
+	return nil
+}
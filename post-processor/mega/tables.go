@@ -0,0 +1,83 @@
+package mega
+
+import (
+	"fmt"
+
+	"github.com/wspr-ncsu/visiblev8/post-processor/core"
+)
+
+// Denormalized (no cross-log FK IDs) tables; script instances are keyed by (logfile_id, isolate_ptr, runtime_id)
+
+var instanceTable = &core.Table{
+	Name: "mega_instances",
+	Columns: []core.Column{
+		{Name: "logfile_id", Type: core.StringColumn},
+		{Name: "isolate_ptr", Type: core.StringColumn},
+		{Name: "runtime_id", Type: core.Int64Column},
+		{Name: "sha2", Type: core.BytesColumn},
+		{Name: "sha3", Type: core.BytesColumn},
+		{Name: "size", Type: core.Int64Column},
+		{Name: "first_origin", Type: core.StringColumn, Nullable: true},
+		{Name: "load_url", Type: core.StringColumn, Nullable: true},
+		{Name: "eval_parent_runtime_id", Type: core.Int64Column, Nullable: true},
+	},
+}
+
+var usageTable = &core.Table{
+	Name: "mega_usage",
+	Columns: []core.Column{
+		{Name: "logfile_id", Type: core.StringColumn},
+		{Name: "isolate_ptr", Type: core.StringColumn},
+		{Name: "runtime_id", Type: core.Int64Column},
+		{Name: "sha2", Type: core.BytesColumn},
+		{Name: "origin", Type: core.StringColumn},
+		{Name: "full_name", Type: core.StringColumn},
+		{Name: "receiver_name", Type: core.StringColumn},
+		{Name: "member_name", Type: core.StringColumn},
+		{Name: "idl_base_receiver", Type: core.StringColumn, Nullable: true},
+		{Name: "idl_member_role", Type: core.StringColumn, Nullable: true},
+		{Name: "usage_offset", Type: core.Int64Column},
+		{Name: "usage_mode", Type: core.StringColumn},
+		{Name: "usage_count", Type: core.Int64Column},
+	},
+}
+
+// DumpToTables writes every script instance and usage tuple as rows of mega_instances/mega_usage
+func (agg *usageAggregator) DumpToTables(ctx *core.AggregationContext, sink core.TableSink) error {
+	logID := ctx.Ln.ID.String()
+
+	for _, iso := range ctx.Ln.Isolates {
+		for _, script := range iso.Scripts {
+			var firstOrigin, evalParentID interface{}
+			if script.FirstOrigin != nil {
+				firstOrigin = script.FirstOrigin.Origin
+			}
+			if script.EvaledBy != nil {
+				evalParentID = script.EvaledBy.ID
+			}
+			err := sink.WriteRow(instanceTable,
+				logID, iso.ID, script.ID,
+				script.CodeHash.SHA2[:], script.CodeHash.SHA3[:], script.CodeHash.Length,
+				firstOrigin, core.NullableString(script.URL), evalParentID)
+			if err != nil {
+				return fmt.Errorf("megaFeatures.DumpToTables/instances: %w", err)
+			}
+		}
+	}
+
+	for usage, count := range agg.usageCounts {
+		var memberRole interface{}
+		if usage.feature.idlInfo.MemberRole != 0 {
+			memberRole = fmt.Sprintf("%c", usage.feature.idlInfo.MemberRole)
+		}
+		err := sink.WriteRow(usageTable,
+			logID, usage.script.Isolate.ID, usage.script.ID, usage.script.CodeHash.SHA2[:], usage.origin,
+			usage.feature.fullName, usage.feature.receiverName, usage.feature.memberName,
+			core.NullableString(usage.feature.idlInfo.BaseInterface), memberRole,
+			usage.offset, fmt.Sprintf("%c", usage.mode), count)
+		if err != nil {
+			return fmt.Errorf("megaFeatures.DumpToTables/usage: %w", err)
+		}
+	}
+	return nil
+}
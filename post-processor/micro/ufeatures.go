@@ -3,9 +3,9 @@ package micro
 import (
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"io"
 	"log"
-	"strings"
 
 	"github.com/wspr-ncsu/visiblev8/post-processor/core"
 )
@@ -33,41 +33,41 @@ func NewFeatureUsageAggregator() (core.Aggregator, error) {
 
 // IngestRecord extracts minimal script API usage stats from each callsite record
 func (agg *FeatureUsageAggregator) IngestRecord(ctx *core.ExecutionContext, lineNumber int, op byte, fields []string) error {
+	return core.IngestAsRecord(agg, ctx, lineNumber, op, fields)
+}
+
+// IngestTypedRecord extracts minimal script API usage stats from a parsed callsite record
+func (agg *FeatureUsageAggregator) IngestTypedRecord(ctx *core.ExecutionContext, rec *core.Record) error {
 	if (ctx.Script != nil) && !ctx.Script.VisibleV8 && (ctx.Origin.Origin != "") {
-		var rcvr, name, fullName string
-		switch op {
-		case 'g':
-			rcvr, _ = core.StripCurlies(fields[1])
-			name, _ = core.StripQuotes(fields[2])
-		case 'c':
-			rcvr, _ = core.StripCurlies(fields[2])
-			name, _ = core.StripQuotes(fields[1])
-			// Eliminate "native" prefix indicator from function names
-			name = strings.TrimPrefix(name, "%")
-		case 's':
-			rcvr, _ = core.StripCurlies(fields[1])
-			name, _ = core.StripQuotes(fields[2])
+		switch rec.Op {
+		case 'g', 'c', 's':
+		case 'j':
+			if rec.SubOp == 'n' {
+				return nil // (see below)
+			}
 		case 'n':
 			// Radical experiment: ignore all "new" records!
 			return nil
 		default:
 			// Oops--what was this?
-			log.Printf("%d: wat? %c: %v", lineNumber, op, fields)
+			log.Printf("%d: wat? %c: %v", rec.Line, rec.Op, rec.Fields)
 			return nil
 		}
 
 		// We have some names (V8 special cases, numeric indices) that are never useful
-		if core.FilterName(name) {
+		if core.FilterName(rec.Member) {
 			return nil
 		}
 
-		if strings.Contains(rcvr, ",") {
-			rcvr = strings.Split(rcvr, ",")[1]
-		}
-
 		// Compensate for OOP-polymorphism by normalizing names to their base IDL interface
-		fullName, err := agg.idl.NormalizeMember(rcvr, name)
-		if err != nil {
+		fullName, err := agg.idl.NormalizeMember(rec.Receiver, rec.Member)
+		if rec.Op == 'j' {
+			// 'j' accesses are kept apart (and often name non-IDL objects, so use the raw name as a fallback)
+			if err != nil {
+				fullName = fmt.Sprintf("%s.%s", rec.Receiver, rec.Member)
+			}
+			fullName = "j:" + fullName
+		} else if err != nil {
 			return err
 		}
 
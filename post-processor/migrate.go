@@ -0,0 +1,54 @@
+package main
+
+// ---------------------------------------------------------------------------
+// schema management ("-migrate up|status"): apply/list the PostgreSQL schema migrations built into the binary
+// ---------------------------------------------------------------------------
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/wspr-ncsu/visiblev8/post-processor/core"
+)
+
+// runMigrate runs a -migrate command against the PostgreSQL database
+func runMigrate(command string, stdout io.Writer) error {
+	if command != "up" && command != "status" {
+		return fmt.Errorf("unknown -migrate command '%s' (want 'up' or 'status')", command)
+	}
+
+	// We rely on the PGxxx environment variables being set...
+	sqlDb, err := sql.Open("postgres", "sslmode=disable")
+	if err != nil {
+		return err
+	}
+	defer sqlDb.Close()
+
+	if command == "up" {
+		applied, err := core.MigrateUp(sqlDb)
+		if err != nil {
+			return err
+		}
+		log.Printf("migrate: %d migrations applied; schema is at version %d\n", applied, core.SchemaVersion())
+		return nil
+	}
+
+	statuses, err := core.GetMigrationStatus(sqlDb)
+	if err != nil {
+		return err
+	}
+	for _, ms := range statuses {
+		state := "pending"
+		if ms.AppliedAt != nil {
+			state = "applied " + ms.AppliedAt.Format(time.RFC3339)
+		}
+		if ms.Unknown {
+			state += " (unknown to this build)"
+		}
+		fmt.Fprintf(stdout, "%04d  %-24s  %s\n", ms.Version, ms.Name, state)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wspr-ncsu/visiblev8/post-processor/core"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// testMongoDb gives a scratch database on the MongoDB named by MONGODB_HOST (etc.), dropped once the test ends
+func testMongoDb(t *testing.T) *mongo.Database {
+	if os.Getenv("MONGODB_HOST") == "" {
+		t.Skip("MONGODB_HOST not set")
+	}
+	conn, err := core.DialMongo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := conn.Client.Database(fmt.Sprintf("vv8pp_test_%d", time.Now().UnixNano()))
+	t.Cleanup(func() {
+		db.Drop(context.Background())
+		conn.Client.Disconnect(context.Background())
+	})
+	return db
+}
+
+// uploadLog stores a vv8log blob in GridFS, giving its "@oid" input name
+func uploadLog(t *testing.T, db *mongo.Database, name string, metadata interface{}) string {
+	bucket, err := gridfs.NewBucket(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := options.GridFSUpload()
+	if metadata != nil {
+		opts.SetMetadata(metadata)
+	}
+	oid, err := bucket.UploadFromStream(name, bytes.NewReader([]byte("~0x1\n")), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "@" + oid.Hex()
+}
+
+func TestRegroupMongoInputs(t *testing.T) {
+	db := testMongoDb(t)
+	crawl1 := bson.D{{Key: "crawl", Value: 1}}
+	crawl2 := bson.D{{Key: "crawl", Value: 2}}
+	seg0 := uploadLog(t, db, "vv8-1234-1-1-0x1.0.log", crawl1)
+	seg1 := uploadLog(t, db, "vv8-1234-1-1-0x1.1.log.gz", crawl1)
+	seg1Again := uploadLog(t, db, "vv8-1234-1-1-0x1.1.log", crawl1)
+	otherCrawl := uploadLog(t, db, "vv8-1234-1-1-0x1.2.log", crawl2)
+	odd := uploadLog(t, db, "not-a-vv8-log.txt", nil)
+
+	inputs := func(names ...string) inputClusterMap {
+		clusters, err := getInputClusters(names)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return clusters
+	}
+
+	got, err := regroupMongoInputs(inputs(seg1, odd, seg0, "vv8-9-1-1-0x2.0.log"), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := inputClusterMap{
+		"@vv8-1234-1-1-0x1.0.log": {{rank: 0, name: seg0}, {rank: 1, name: seg1}},
+		odd:                       {{name: odd}},
+		"vv8-9-1-1-0x2.0.log":     {{rank: 0, name: "vv8-9-1-1-0x2.0.log"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("regroupMongoInputs = %+v, want %+v", got, want)
+	}
+
+	for name, names := range map[string][]string{
+		"given twice":        {seg0, seg1, seg1Again},
+		"different metadata": {seg0, seg1, otherCrawl},
+	} {
+		if _, err = regroupMongoInputs(inputs(names...), db); err == nil || !strings.Contains(err.Error(), "@vv8-1234-1-1-0x1.0.log") {
+			t.Errorf("%s: regroupMongoInputs error = %v, want one naming the log", name, err)
+		}
+	}
+}
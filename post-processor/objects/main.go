@@ -0,0 +1,278 @@
+package objects
+
+// ---------------------------------------------------------------------------
+// aggregator tracking object identity/lifecycle via the receiver IDs ({id,Ctor})
+// logged by the trace-apis-object patchsets
+// ---------------------------------------------------------------------------
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+
+	"github.com/lib/pq"
+	"github.com/wspr-ncsu/visiblev8/post-processor/core"
+)
+
+// objectKey identifies an object: IDs are only unique within their isolate
+type objectKey struct {
+	isolate  string
+	objectID string
+}
+
+// trackedObject accumulates everything seen about one object
+type trackedObject struct {
+	ctor      string                     // constructor name (as first seen)
+	firstLine int                        // log line on which the object first appeared
+	scripts   []*core.ScriptInfo         // scripts that touched it (in order of first touch)
+	seenBy    map[*core.ScriptInfo]bool  // (dedup set for the above)
+	origins   []string                   // execution-context origins it was touched from (in order of first touch)
+	apis      map[string]map[string]bool // origin -> set of APIs ("Ctor.member") used from that origin
+	members   []string                   // members used, in order (consecutive repeats collapsed)
+}
+
+type objectAggregator struct {
+	objects map[objectKey]*trackedObject
+	order   []objectKey // keys in order of first appearance (for stable output)
+}
+
+// NewAggregator creates an object-lifecycle aggregator
+func NewAggregator() (core.Aggregator, error) {
+	return &objectAggregator{
+		objects: make(map[objectKey]*trackedObject),
+	}, nil
+}
+
+func (agg *objectAggregator) IngestRecord(ctx *core.ExecutionContext, lineNumber int, op byte, fields []string) error {
+	return core.IngestAsRecord(agg, ctx, lineNumber, op, fields)
+}
+
+// IngestTypedRecord attributes each access to a logged object ID to that object
+func (agg *objectAggregator) IngestTypedRecord(ctx *core.ExecutionContext, rec *core.Record) error {
+	if rec.ObjectID == "" {
+		// Nothing to track (older patchsets, or a receiver that is not an object)
+		return nil
+	}
+	if (ctx.Script != nil) && !ctx.Script.VisibleV8 && (ctx.Origin.Origin != "") {
+		key := objectKey{ctx.Script.Isolate.ID, rec.ObjectID}
+		obj, ok := agg.objects[key]
+		if !ok {
+			obj = &trackedObject{
+				ctor:      rec.Receiver,
+				firstLine: rec.Line,
+				seenBy:    make(map[*core.ScriptInfo]bool),
+				apis:      make(map[string]map[string]bool),
+			}
+			agg.objects[key] = obj
+			agg.order = append(agg.order, key)
+		}
+
+		if !obj.seenBy[ctx.Script] {
+			obj.seenBy[ctx.Script] = true
+			obj.scripts = append(obj.scripts, ctx.Script)
+		}
+
+		origin := ctx.Origin.Origin
+		originAPIs, ok := obj.apis[origin]
+		if !ok {
+			originAPIs = make(map[string]bool)
+			obj.apis[origin] = originAPIs
+			obj.origins = append(obj.origins, origin)
+		}
+
+		if rec.Member != "" && !core.FilterName(rec.Member) {
+			originAPIs[fmt.Sprintf("%s.%s", rec.Receiver, rec.Member)] = true
+			if n := len(obj.members); n == 0 || obj.members[n-1] != rec.Member {
+				obj.members = append(obj.members, rec.Member)
+			}
+		}
+	}
+	return nil
+}
+
+func (agg *objectAggregator) DumpToStream(ctx *core.AggregationContext, stream io.Writer) error {
+	jstream := json.NewEncoder(stream)
+
+	for _, key := range agg.order {
+		obj := agg.objects[key]
+
+		scriptHashes := make([]string, len(obj.scripts))
+		for i, script := range obj.scripts {
+			scriptHashes[i] = hex.EncodeToString(script.CodeHash.SHA2[:])
+		}
+
+		jstream.Encode(core.JSONArray{"object", core.JSONObject{
+			"isolate":       key.isolate,
+			"object_id":     key.objectID,
+			"ctor":          obj.ctor,
+			"first_line":    obj.firstLine,
+			"script_hashes": scriptHashes,
+			"origins":       obj.origins,
+			"members":       obj.members,
+		}})
+	}
+
+	return nil
+}
+
+var multiOriginObjFields = [...]string{
+	"logfile_id",
+	"objectid",
+	"origins",
+	"num_of_origins",
+	"urls",
+}
+
+var multiOriginAPINameFields = [...]string{
+	"logfile_id",
+	"objectid",
+	"origin",
+	"api_name",
+}
+
+// DumpToPostgresql records objects touched from more than one origin (and which APIs each origin used on them)
+func (agg *objectAggregator) DumpToPostgresql(ctx *core.AggregationContext, sqlDb *sql.DB) error {
+	logID, err := ctx.Ln.InsertLogfile(sqlDb)
+	if err != nil {
+		return err
+	}
+
+	txn, err := sqlDb.Begin()
+	if err != nil {
+		return err
+	}
+
+	objStmt, err := txn.Prepare(pq.CopyIn("multi_origin_obj", multiOriginObjFields[:]...))
+	if err != nil {
+		txn.Rollback()
+		return err
+	}
+
+	objRows, apiRows := agg.multiOriginRows()
+	for _, row := range objRows {
+		_, err = objStmt.Exec(logID, row.objectID, pq.Array(row.origins), len(row.origins), pq.Array(row.urls))
+		if err != nil {
+			txn.Rollback()
+			return err
+		}
+	}
+
+	_, err = objStmt.Exec()
+	if err != nil {
+		txn.Rollback()
+		return err
+	}
+	err = objStmt.Close()
+	if err != nil {
+		txn.Rollback()
+		return err
+	}
+
+	apiStmt, err := txn.Prepare(pq.CopyIn("multi_origin_api_names", multiOriginAPINameFields[:]...))
+	if err != nil {
+		txn.Rollback()
+		return err
+	}
+	for _, row := range apiRows {
+		_, err = apiStmt.Exec(logID, row.objectID, row.origin, row.apiName)
+		if err != nil {
+			txn.Rollback()
+			return err
+		}
+	}
+	_, err = apiStmt.Exec()
+	if err != nil {
+		txn.Rollback()
+		return err
+	}
+	err = apiStmt.Close()
+	if err != nil {
+		txn.Rollback()
+		return err
+	}
+
+	err = txn.Commit()
+	if err != nil {
+		return err
+	}
+	log.Printf("objects: %d of %d objects touched from multiple origins\n", len(objRows), len(agg.objects))
+
+	return nil
+}
+
+// multiOriginObjRow is a multi_origin_obj row (sans logfile_id)
+type multiOriginObjRow struct {
+	objectID int
+	origins  []string
+	urls     []string
+}
+
+// multiOriginAPINameRow is a multi_origin_api_names row (sans logfile_id)
+type multiOriginAPINameRow struct {
+	objectID int
+	origin   string
+	apiName  string
+}
+
+// multiOriginRows gives the rows recording objects touched from more than one origin
+func (agg *objectAggregator) multiOriginRows() ([]multiOriginObjRow, []multiOriginAPINameRow) {
+	objRows := make([]multiOriginObjRow, 0)
+	apiRows := make([]multiOriginAPINameRow, 0)
+	for _, key := range agg.order {
+		obj := agg.objects[key]
+		if len(obj.origins) < 2 {
+			continue
+		}
+		objectID, err := strconv.Atoi(key.objectID)
+		if err != nil {
+			log.Printf("objects: skipping object ID '%s' (not an int): %v\n", key.objectID, err)
+			continue
+		}
+
+		urls := make([]string, 0, len(obj.scripts))
+		for _, script := range obj.scripts {
+			if script.URL != "" {
+				urls = append(urls, script.URL)
+			}
+		}
+		objRows = append(objRows, multiOriginObjRow{objectID, obj.origins, urls})
+
+		for _, origin := range obj.origins {
+			for apiName := range obj.apis[origin] {
+				apiRows = append(apiRows, multiOriginAPINameRow{objectID, origin, apiName})
+			}
+		}
+	}
+	return objRows, apiRows
+}
+
+// COPY-file equivalents of multi_origin_obj and multi_origin_api_names
+var (
+	multiOriginObjCopyTable     = &core.CopyTable{Name: "multi_origin_obj", Fields: multiOriginObjFields[:]}
+	multiOriginAPINameCopyTable = &core.CopyTable{Name: "multi_origin_api_names", Fields: multiOriginAPINameFields[:]}
+)
+
+// CopyTables lists the COPY files written by DumpToCopy
+var CopyTables = []*core.CopyTable{multiOriginObjCopyTable, multiOriginAPINameCopyTable}
+
+// DumpToCopy writes the rows DumpToPostgresql would insert
+func (agg *objectAggregator) DumpToCopy(ctx *core.AggregationContext, sink core.CopySink) error {
+	logID := ctx.Ln.ID.String()
+	objRows, apiRows := agg.multiOriginRows()
+	for _, row := range objRows {
+		err := sink.CopyRow(multiOriginObjCopyTable, logID, row.objectID, pq.Array(row.origins), len(row.origins), pq.Array(row.urls))
+		if err != nil {
+			return err
+		}
+	}
+	for _, row := range apiRows {
+		if err := sink.CopyRow(multiOriginAPINameCopyTable, logID, row.objectID, row.origin, row.apiName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,470 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wspr-ncsu/visiblev8/post-processor/core"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// pipeline carries the per-invocation configuration shared by every log cluster processed (possibly concurrently)
+type pipeline struct {
+	base         core.AggregationContext // template context (formats, submission ID, shared DB handles, ...) copied per cluster
+	outputFormat string                  // 'stdout', 'postgresql', 'parquet', 'sqlite', or 'copy'
+	tables       core.TableSink          // where table rows go (with 'parquet' output)
+	copies       core.CopySink           // where COPY rows go (with 'copy' output)
+	sqlite       *sql.DB                 // SQLite database (with 'sqlite' output)
+	existing     string                  // what to do with logs already in PostgreSQL: 'replace', 'skip', or "" (add their rows again)
+	annotate     bool                    // dump annotated lines instead of aggregating?
+
+	stdout     io.Writer  // where stream output ultimately goes
+	stdoutLock sync.Mutex // serializes whole-cluster flushes to stdout
+	buffered   bool       // buffer each cluster's stream output and flush it in one piece (needed once clusters run concurrently)
+
+	follow        bool            // tail the input log (and its later segments) as Chrome writes it?
+	followIdle    time.Duration   // stop following after this long without new data
+	snapshotEvery time.Duration   // interval between incremental stream-output snapshots while following
+	stop          <-chan struct{} // closed (e.g., on SIGINT) to stop following
+
+	linter     *linter           // if set, lint each cluster (reporting anomalies as JSON) instead of aggregating
+	redactor   *redactor         // if set, write a redacted copy of each cluster instead of aggregating
+	convert    bool              // write each cluster re-encoded as a binary log instead of aggregating?
+	convertOut string            // where to write binary logs ("" means stdout)
+	slicer     *core.SliceFilter // if set, write the matching slice of the (sole) cluster to stdout instead of aggregating
+}
+
+// runAll processes every input cluster using up to <jobs> workers, returning the first error encountered (if any)
+func (p *pipeline) runAll(inputClusters inputClusterMap, jobs int) error {
+	type clusterJob struct {
+		name     string
+		segments []logSegment
+	}
+	jobChan := make(chan clusterJob)
+
+	var firstErr error
+	var errLock sync.Mutex
+	failed := func() bool {
+		errLock.Lock()
+		defer errLock.Unlock()
+		return firstErr != nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobChan {
+				if err := p.process(job.name, job.segments); err != nil {
+					log.Printf("%s: %v", job.name, err)
+					errLock.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					errLock.Unlock()
+				}
+			}
+		}()
+	}
+
+	// Stop handing out new work after the first failure (in-flight clusters still finish)
+	for inputName, inputSegments := range inputClusters {
+		if failed() {
+			break
+		}
+		jobChan <- clusterJob{inputName, inputSegments}
+	}
+	close(jobChan)
+	wg.Wait()
+
+	return firstErr
+}
+
+// openInput sets up the input stream for one cluster (stdin if "-", Mongo vv8log OID if "@...", filename(s) otherwise)
+func (p *pipeline) openInput(aggCtx *core.AggregationContext, inputName string, inputSegments []logSegment) (io.Reader, error) {
+	if len(inputSegments) > 0 && inputSegments[0].archive != nil {
+		// Members of an archive (possibly compressed, possibly multi-segment)
+		archive := inputSegments[0].archive
+		members := make([]string, len(inputSegments))
+		for i, segment := range inputSegments {
+			members[i] = segment.name
+		}
+		log.Printf("Reading %s from %s...\n", strings.Join(members, ", "), archive.Name)
+		aggCtx.RootName = inputName
+		return core.NewClosingReader(archive.OpenMembers(members)), nil
+	} else if strings.HasPrefix(inputName, "@") {
+		// GridFS vv8log(s), by OID (possibly compressed, possibly several segments of one log)
+		segmentStreams := make([]io.Reader, len(inputSegments))
+		var firstName string
+		for i, segment := range inputSegments {
+			oidHex := segment.name[1:]
+			oid, err := primitive.ObjectIDFromHex(oidHex)
+			if err != nil {
+				return nil, fmt.Errorf("invalid oid '%s'", oidHex)
+			}
+			log.Printf("Reading vv8log OID %s\n", oidHex)
+			stream, name, err := core.OpenGridFSLog(aggCtx.MongoDb, oid)
+			if err != nil {
+				closeInputs(segmentStreams[:i]...)
+				return nil, err
+			}
+			if i == 0 {
+				aggCtx.LogOid = oid
+				firstName = name
+			}
+			segmentStreams[i] = core.NewClosingReader(stream)
+		}
+
+		// Set to match the Mongo data if it's missing
+		if aggCtx.RootName == "" {
+			if inputName != inputSegments[0].name {
+				aggCtx.RootName = inputName[1:] // (regrouped multi-part log)
+			} else {
+				aggCtx.RootName = firstName
+			}
+		}
+		return newSegmentsReader(segmentStreams), nil
+	} else if inputName == "-" {
+		// Stdin (archives must be named files, since zip needs random access)
+		log.Println("Reading from stdin...")
+		return core.NewDecompressingReader(os.Stdin)
+	} else if p.follow && len(inputSegments) > 0 {
+		// Live file input (starting with the lowest-ranked segment given, moving on to higher ranks as they appear)
+		log.Printf("Following %s...\n", inputSegments[0].name)
+		follower, err := core.NewFollowReader(inputSegments[0].name)
+		if err != nil {
+			return nil, err
+		}
+		follower.Successor = nextSegmentName
+		follower.Stop = p.stop
+		follower.IdleTimeout = p.followIdle
+		aggCtx.RootName = inputName
+		return follower, nil
+	} else if len(inputSegments) > 0 {
+		// Plain file input (possibly compressed, possibly multi-segment, possibly a mix of both)
+		segmentStreams := make([]io.Reader, len(inputSegments))
+		for i, segment := range inputSegments {
+			log.Printf("Opening %s...\n", segment.name)
+			file, err := core.OpenLogSegment(segment.name)
+			if err != nil {
+				closeInputs(segmentStreams[:i]...)
+				return nil, err
+			}
+			segmentStreams[i] = core.NewClosingReader(file)
+		}
+		aggCtx.RootName = inputName
+		return newSegmentsReader(segmentStreams), nil
+	}
+	return nil, fmt.Errorf("something is very wrong--where are the file names?")
+}
+
+// segmentsReader reads a log's segment streams back to back (like io.MultiReader), closing every one of them on Close
+// (even those not read to the end, e.g., when an aggregator error cuts ingestion short)
+type segmentsReader struct {
+	io.Reader
+	segments []io.Reader
+}
+
+func newSegmentsReader(segments []io.Reader) *segmentsReader {
+	return &segmentsReader{
+		Reader:   io.MultiReader(segments...),
+		segments: segments,
+	}
+}
+
+func (sr *segmentsReader) Close() error {
+	closeInputs(sr.segments...)
+	return nil
+}
+
+// closeInputs releases input streams (from openInput) that are not read to the end, e.g., a FollowReader (whose current
+// segment stays open) or segments abandoned after an error; streams that close themselves at EOF are closed again harmlessly
+func closeInputs(streams ...io.Reader) {
+	for _, stream := range streams {
+		if closer, ok := stream.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+}
+
+// createClusterOutput creates a new file in <dir> for a per-cluster output (e.g., a redacted copy), named after the
+// cluster's base name (with the extension <ext> instead of any ".log", if given); existing files are never overwritten
+func createClusterOutput(dir string, inputName string, ext string) (*os.File, string, error) {
+	name := filepath.Base(inputName)
+	if inputName == "-" {
+		name = "stdin.log"
+	} else if strings.HasPrefix(name, "@") {
+		name = name[1:] + ".log"
+	}
+	if ext != "" {
+		name = strings.TrimSuffix(name, ".log") + ext
+	}
+	outName := filepath.Join(dir, name)
+	file, err := os.OpenFile(outName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	return file, outName, err
+}
+
+// process runs a single input cluster through ingestion, aggregation, and output (with its own context and aggregators)
+func (p *pipeline) process(inputName string, inputSegments []logSegment) error {
+	if p.linter != nil {
+		return p.lintCluster(inputName, inputSegments)
+	} else if p.redactor != nil {
+		return p.redactCluster(inputName, inputSegments)
+	} else if p.convert {
+		return p.convertCluster(inputName, inputSegments)
+	}
+	if p.outputFormat == "postgresql" && p.existing == "skip" && inputName != "-" && !p.follow {
+		// (cheap check first, so a resumed batch run does not ingest every log it already did just to skip it)
+		skip, err := p.importedBefore(inputName, inputSegments)
+		if err != nil || skip {
+			return err
+		}
+	}
+	aggCtx := p.base
+
+	inputStream, err := p.openInput(&aggCtx, inputName, inputSegments)
+	if err != nil {
+		return err
+	}
+	defer closeInputs(inputStream)
+	if !p.follow {
+		var header *core.BinaryLogHeader
+		inputStream, header, err = core.PeekBinaryLog(inputStream)
+		if err != nil {
+			return err
+		} else if header != nil {
+			if p.annotate || p.slicer != nil {
+				return fmt.Errorf("%s: binary logs cannot be annotated or sliced (use the original text log)", inputName)
+			} else if header.RootName != "" {
+				aggCtx.RootName = header.RootName // (so output matches that of the original text log)
+			}
+		}
+	}
+
+	// Handle output setup (with a special case for "dump" mode)
+	if p.annotate {
+		p.stdoutLock.Lock()
+		defer p.stdoutLock.Unlock()
+		return core.AnnotateStream(inputStream, p.stdout, &aggCtx)
+	} else if p.slicer != nil {
+		p.stdoutLock.Lock()
+		defer p.stdoutLock.Unlock()
+		return core.SliceStream(inputStream, p.stdout, &aggCtx, p.slicer)
+	}
+
+	// Stream output goes straight through when running serially, or into a per-cluster buffer flushed all at once
+	var stdout io.Writer = p.stdout
+	var stdoutBuffer *bytes.Buffer
+	if p.buffered {
+		stdoutBuffer = new(bytes.Buffer)
+		stdout = stdoutBuffer
+	}
+
+	var outputDriver core.DumpDriver
+	if p.outputFormat == "postgresql" {
+		outputDriver = core.NewPostgresqlDumpDriver(aggCtx.SQLDb)
+	} else if p.outputFormat == "stdout" {
+		outputDriver = core.NewStreamDumpDriver(stdout)
+	} else if p.outputFormat == "parquet" {
+		outputDriver = core.NewTableDumpDriver(p.tables)
+	} else if p.outputFormat == "sqlite" {
+		outputDriver = core.NewSQLiteDumpDriver(p.sqlite)
+	} else if p.outputFormat == "copy" {
+		outputDriver = core.NewCopyDumpDriver(p.copies)
+	} else {
+		return fmt.Errorf("unsupported output format '%s'", p.outputFormat)
+	}
+
+	// FINALLY build the aggregator array, post-processes that sucker, and feed the results into the output driver
+	aggregators, err := makeAggregators(aggCtx.Formats)
+	if err != nil {
+		return err
+	}
+
+	aggCtx.Ln = core.NewLogInfo(aggCtx.LogOid, aggCtx.RootName, aggCtx.SubmissionID)
+	aggCtx.Ln.Lenient = aggCtx.Lenient
+	aggCtx.Ln.ScriptStore = aggCtx.ScriptStore
+	aggCtx.Ln.DeriveID = p.outputFormat != "stdout" // (every other output keeps the log ID)
+
+	// While following a live log, periodically dump snapshots; stream output then only carries lines not emitted before
+	// (each snapshot goes straight to stdout, as a whole, instead of into a buffer only flushed once the log ends)
+	var dedup *dedupWriter
+	var dumpSnapshot func() error
+	if follower, ok := inputStream.(*core.FollowReader); ok && p.outputFormat == "stdout" {
+		stdoutBuffer = nil
+		dedup = newDedupWriter(p.stdout)
+		outputDriver = core.NewStreamDumpDriver(dedup)
+		dumpSnapshot = func() error {
+			p.stdoutLock.Lock()
+			defer p.stdoutLock.Unlock()
+			for _, agg := range aggregators {
+				if err := outputDriver(agg, &aggCtx); err != nil {
+					return err
+				}
+			}
+			return dedup.Flush()
+		}
+		follower.CheckpointEvery = p.snapshotEvery
+		follower.Checkpoint = func() error {
+			log.Printf("Dumping snapshot...\n")
+			return dumpSnapshot()
+		}
+	}
+	err = aggCtx.Ln.IngestStream(inputStream, aggregators...)
+	if err != nil {
+		return err
+	}
+	if p.outputFormat == "postgresql" {
+		proceed, err := p.handleExisting(aggCtx.SQLDb, aggCtx.Ln)
+		if err != nil || !proceed {
+			return err
+		}
+	}
+
+	if dumpSnapshot != nil {
+		log.Printf("Dumping final snapshot...\n")
+		if err = dumpSnapshot(); err != nil {
+			return err
+		}
+	} else {
+		for _, agg := range aggregators {
+			log.Printf("Started dumping for aggregator...\n")
+			err = outputDriver(agg, &aggCtx)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if p.outputFormat == "postgresql" {
+		if err = aggCtx.Ln.FinishLogfile(aggCtx.SQLDb); err != nil {
+			return err
+		}
+	}
+
+	if stdoutBuffer != nil {
+		p.stdoutLock.Lock()
+		_, err = stdoutBuffer.WriteTo(p.stdout)
+		p.stdoutLock.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+
+	// debugging hack--perform 1 second delay on no-op (null aggregator)
+	if len(aggregators) == 0 {
+		time.Sleep(time.Second)
+	}
+	return nil
+}
+
+// importedBefore reads a (re-readable) cluster once just to derive its log ID, reporting whether the log's import
+// into PostgreSQL already finished (imports cut short are left for handleExisting to replace, once ingested)
+func (p *pipeline) importedBefore(inputName string, inputSegments []logSegment) (bool, error) {
+	aggCtx := p.base
+	inputStream, err := p.openInput(&aggCtx, inputName, inputSegments)
+	if err != nil {
+		return false, err
+	}
+	defer closeInputs(inputStream)
+	inputStream, header, err := core.PeekBinaryLog(inputStream)
+	if err != nil {
+		return false, err
+	} else if header != nil && header.RootName != "" {
+		aggCtx.RootName = header.RootName
+	}
+	id, err := core.PeekLogID(inputStream, aggCtx.RootName, aggCtx.Lenient)
+	if err != nil {
+		return false, err
+	}
+	_, finished, err := core.LogfileState(aggCtx.SQLDb, id)
+	if err == nil && finished {
+		log.Printf("%s: already in PostgreSQL (log %s); skipping\n", aggCtx.RootName, id)
+	}
+	return finished, err
+}
+
+// handleExisting applies the -replace/-skip-existing policy to an (ingested) log's earlier import into PostgreSQL, if any,
+// reporting whether to go on and dump the log
+func (p *pipeline) handleExisting(sqlDb *sql.DB, ln *core.LogInfo) (bool, error) {
+	present, finished, err := core.LogfileState(sqlDb, ln.ID)
+	if err != nil || !present {
+		return true, err
+	}
+	switch {
+	case p.existing == "skip" && finished:
+		log.Printf("%s: already in PostgreSQL (log %s); skipping\n", ln.RootName, ln.ID)
+		return false, nil
+	case p.existing == "skip":
+		log.Printf("%s: earlier import (log %s) was cut short; replacing it\n", ln.RootName, ln.ID)
+	case p.existing == "replace":
+		log.Printf("%s: replacing earlier import (log %s)\n", ln.RootName, ln.ID)
+	default:
+		log.Printf("WARNING: %s: already in PostgreSQL (log %s); adding its rows again (see -replace and -skip-existing)\n", ln.RootName, ln.ID)
+		return true, nil
+	}
+	return true, core.ResetLogfile(sqlDb, ln.ID)
+}
+
+// dedupWriter passes each line written through to <out> only the first time it is seen
+// (so repeated snapshots of line-oriented output carry only new or changed records)
+type dedupWriter struct {
+	out     io.Writer
+	seen    map[uint64]bool // FNV-1a hashes of lines already written
+	partial []byte          // trailing partial line, held until complete (or flushed)
+}
+
+func newDedupWriter(out io.Writer) *dedupWriter {
+	return &dedupWriter{
+		out:  out,
+		seen: make(map[uint64]bool),
+	}
+}
+
+func (dw *dedupWriter) Write(p []byte) (int, error) {
+	dw.partial = append(dw.partial, p...)
+	for {
+		i := bytes.IndexByte(dw.partial, '\n')
+		if i < 0 {
+			break
+		}
+		err := dw.emit(dw.partial[:i+1])
+		if err != nil {
+			return 0, err
+		}
+		dw.partial = dw.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+func (dw *dedupWriter) emit(line []byte) error {
+	hash := fnv.New64a()
+	hash.Write(line)
+	key := hash.Sum64()
+	if dw.seen[key] {
+		return nil
+	}
+	dw.seen[key] = true
+	_, err := dw.out.Write(line)
+	return err
+}
+
+// Flush writes out any held partial line
+func (dw *dedupWriter) Flush() error {
+	if len(dw.partial) == 0 {
+		return nil
+	}
+	err := dw.emit(dw.partial)
+	dw.partial = nil
+	return err
+}
@@ -0,0 +1,280 @@
+package main
+
+// ---------------------------------------------------------------------------
+// log redaction ("-redact"): rewrite logs (still in VV8 format) with sensitive strings masked/hashed/replaced,
+// leaving offsets, isolates, script IDs, and record structure intact
+// ---------------------------------------------------------------------------
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/wspr-ncsu/visiblev8/post-processor/core"
+)
+
+// redactRule rewrites the parts of a logged string matching Pattern (or the whole string, if there is no Pattern)
+type redactRule struct {
+	Pattern string `json:"pattern"` // regular expression (RE2 syntax)
+	Action  string `json:"action"`  // "mask" (length-preserving '*'s), "hash" (keyed HMAC-SHA256), or "replace" (with With)
+	With    string `json:"with"`    // replacement text ("replace" only; may use $1-style group references)
+
+	re *regexp.Regexp
+}
+
+// redactConfig is the JSON rules file given to -redact
+type redactConfig struct {
+	Key         string       `json:"key"`          // HMAC key for "hash" rules (same key, same hashes--so redacted logs can still be joined)
+	Rules       []redactRule `json:"rules"`        // applied in order to every string argument/value (and script/origin URL)
+	ScrubSource bool         `json:"scrub_source"` // mask script source (length-preserving, so offsets stay valid)
+	ScrubQuery  bool         `json:"scrub_query"`  // drop URL query strings/fragments (in script/origin URLs and string values alike)
+}
+
+// redactor applies a redactConfig to log records
+type redactor struct {
+	config redactConfig
+	outDir string // where to write redacted logs ("" means stdout)
+}
+
+// puppeteerSuffix marks puppeteer-injected scripts (kept through source scrubbing, as it decides VisibleV8-ness)
+const puppeteerSuffix = "//# sourceURL=__puppeteer_evaluation_script__\n)"
+
+// urlQueryPattern finds the query/fragment part of URLs embedded in strings
+var urlQueryPattern = regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9+.-]*://[^\s?#"']*)[?#][^\s"']*`)
+
+// newRedactor loads and compiles a rules file
+func newRedactor(rulesFile, outDir string) (*redactor, error) {
+	data, err := os.ReadFile(rulesFile)
+	if err != nil {
+		return nil, err
+	}
+	rd := &redactor{outDir: outDir}
+	err = json.Unmarshal(data, &rd.config)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", rulesFile, err)
+	}
+	for i := range rd.config.Rules {
+		rule := &rd.config.Rules[i]
+		switch rule.Action {
+		case "mask", "replace":
+		case "hash":
+			if rd.config.Key == "" {
+				return nil, fmt.Errorf("%s: rule %d: 'hash' rules need a 'key'", rulesFile, i)
+			}
+		default:
+			return nil, fmt.Errorf("%s: rule %d: unknown action '%s' (wanted 'mask', 'hash', or 'replace')", rulesFile, i, rule.Action)
+		}
+		if rule.Pattern != "" {
+			rule.re, err = regexp.Compile(rule.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("%s: rule %d: %w", rulesFile, i, err)
+			}
+		}
+	}
+	return rd, nil
+}
+
+// mask replaces every character with '*' (keeping the UTF-16 length, as JS would measure it)
+func mask(s string, with rune) string {
+	var sb strings.Builder
+	for _, r := range s {
+		if r > 0xffff {
+			sb.WriteRune(with)
+		}
+		sb.WriteRune(with)
+	}
+	return sb.String()
+}
+
+// redactString applies every rule (and query scrubbing) to a logged string's contents
+func (rd *redactor) redactString(s string) string {
+	for _, rule := range rd.config.Rules {
+		apply := func(match string) string {
+			switch rule.Action {
+			case "mask":
+				return mask(match, '*')
+			case "hash":
+				mac := hmac.New(sha256.New, []byte(rd.config.Key))
+				mac.Write([]byte(match))
+				return "h:" + hex.EncodeToString(mac.Sum(nil))[:16]
+			}
+			if rule.re != nil {
+				return rule.re.ReplaceAllString(match, rule.With)
+			}
+			return rule.With
+		}
+		if rule.re == nil {
+			s = apply(s)
+		} else {
+			s = rule.re.ReplaceAllStringFunc(s, apply)
+		}
+	}
+	if rd.config.ScrubQuery {
+		s = urlQueryPattern.ReplaceAllString(s, "$1")
+	}
+	return s
+}
+
+// scrubSource masks script source, keeping whitespace (so offsets and line structure stay valid) and the puppeteer marker
+func scrubSource(code string) string {
+	body, marker := code, ""
+	if strings.HasSuffix(code, puppeteerSuffix) {
+		body, marker = code[:len(code)-len(puppeteerSuffix)], puppeteerSuffix
+	}
+	var sb strings.Builder
+	for _, r := range body {
+		switch {
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			sb.WriteRune(r)
+		case r > 0xffff:
+			sb.WriteString("xx")
+		default:
+			sb.WriteByte('x')
+		}
+	}
+	return sb.String() + marker
+}
+
+// valueFields gives the indexes of the (possibly string) JS value fields of a trace record
+func valueFields(rec *core.Record, numFields int) (first, last int) {
+	switch rec.Op {
+	case 'c', 's':
+		return 3, numFields // (arguments, or the value set)
+	case 'n':
+		return 2, numFields // (arguments)
+	case 'j':
+		switch rec.SubOp {
+		case 'g', 's', 'c':
+			return 4, numFields // (value read/set, or result and arguments)
+		case 'n':
+			return 3, numFields // (arguments)
+		}
+	}
+	return 0, 0
+}
+
+// redactLine rewrites one log line (without its newline); fields are re-encoded only if redaction changed them
+func (rd *redactor) redactLine(lineNumber int, line []byte) []byte {
+	if len(line) == 0 {
+		return line
+	}
+	code := line[0]
+	fields := core.SplitFields(line[1:])
+	raw := core.SplitRawFields(line[1:])
+	if len(raw) != len(fields) {
+		// (odd escapes we cannot line up with their decoded fields; re-encode everything)
+		raw = make([]string, len(fields))
+		for i, field := range fields {
+			raw[i] = core.EncodeField(field)
+		}
+	}
+
+	changed := false
+	rewrite := func(i int, value string) {
+		if value != fields[i] {
+			raw[i] = core.EncodeField(value)
+			changed = true
+		}
+	}
+	redactValue := func(i int) {
+		if v := core.ParseValue(fields[i]); v.Kind == core.StringValue {
+			v.Text = rd.redactString(v.Text)
+			rewrite(i, core.FormatValue(v))
+		}
+	}
+
+	switch code {
+	case '@':
+		if len(fields) >= 1 {
+			redactValue(0) // (a quoted origin URL, unless "?")
+		}
+	case '$':
+		if len(fields) >= 3 {
+			redactValue(1) // (a quoted URL, unless an eval-parent ID)
+			if rd.config.ScrubSource {
+				rewrite(2, scrubSource(fields[2]))
+			}
+		}
+	case 'c', 'n', 's', 'j':
+		rec, err := core.ParseRecord(lineNumber, code, fields)
+		if err == nil {
+			first, last := valueFields(rec, len(fields))
+			for i := first; i < last; i++ {
+				redactValue(i)
+			}
+		}
+	}
+
+	if !changed {
+		return line
+	}
+	var out bytes.Buffer
+	out.WriteByte(code)
+	out.WriteString(strings.Join(raw, ":"))
+	return out.Bytes()
+}
+
+// redactStream copies a log from <in> to <out>, redacting as it goes (a missing final newline stays missing)
+func (rd *redactor) redactStream(in io.Reader, out io.Writer) error {
+	reader := bufio.NewReaderSize(in, 1024*1024)
+	writer := bufio.NewWriter(out)
+	lineNumber := 0
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			lineNumber++
+			newline := line[len(line)-1] == '\n'
+			if newline {
+				line = line[:len(line)-1]
+			}
+			if _, werr := writer.Write(rd.redactLine(lineNumber, line)); werr != nil {
+				return werr
+			}
+			if newline {
+				writer.WriteByte('\n')
+			}
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+// redactCluster writes a redacted copy of one log (all segments concatenated) to the output directory (or stdout)
+func (p *pipeline) redactCluster(inputName string, inputSegments []logSegment) error {
+	aggCtx := p.base
+	inputStream, err := p.openInput(&aggCtx, inputName, inputSegments)
+	if err != nil {
+		return err
+	}
+	defer closeInputs(inputStream)
+
+	if p.redactor.outDir == "" {
+		p.stdoutLock.Lock()
+		defer p.stdoutLock.Unlock()
+		return p.redactor.redactStream(inputStream, p.stdout)
+	}
+
+	file, outName, err := createClusterOutput(p.redactor.outDir, inputName, "")
+	if err != nil {
+		return err
+	}
+	log.Printf("Redacting %s into %s...\n", inputName, outName)
+	err = p.redactor.redactStream(inputStream, file)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
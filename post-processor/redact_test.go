@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/wspr-ncsu/visiblev8/post-processor/core"
+)
+
+// structureAggregator notes the shape of every trace record: where it is, which script/origin context it ran in,
+// and every field redaction must leave alone
+type structureAggregator struct {
+	records []string
+}
+
+func (agg *structureAggregator) IngestRecord(ctx *core.ExecutionContext, lineNumber int, op byte, fields []string) error {
+	var script string
+	if ctx.Script != nil {
+		script = fmt.Sprintf("%s/%d", ctx.Script.Isolate.ID, ctx.Script.ID)
+	}
+	kept := fields
+	if rec, err := core.ParseRecord(lineNumber, op, fields); err == nil {
+		if first, _ := valueFields(rec, len(fields)); first > 0 {
+			kept = fields[:first]
+		}
+	}
+	agg.records = append(agg.records, fmt.Sprintf("%d %c %s origin=%v fields=%d %q", lineNumber, op, script, ctx.Origin != nil, len(fields), kept))
+	return nil
+}
+
+// ingestStructure ingests a log strictly, giving the shape of its records (and the LogInfo left behind)
+func ingestStructure(t *testing.T, data []byte) ([]string, *core.LogInfo) {
+	agg := new(structureAggregator)
+	ln := core.NewLogInfo([12]byte{}, "vv8-test.0.log", [16]byte{})
+	if err := ln.IngestStream(bytes.NewReader(data), agg); err != nil {
+		t.Fatal(err)
+	}
+	return agg.records, ln
+}
+
+func TestRedactKeepsStructure(t *testing.T) {
+	rules := filepath.Join(t.TempDir(), "rules.json")
+	err := os.WriteFile(rules, []byte(`{
+		"key": "test key",
+		"rules": [
+			{"pattern": "secret[0-9]+", "action": "hash"},
+			{"pattern": "[a-z]+@[a-z.]+", "action": "mask"},
+			{"pattern": "tok=[^&]*", "action": "replace", "with": "tok=X"}
+		],
+		"scrub_source": true,
+		"scrub_query": true
+	}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rd, err := newRedactor(rules, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logs := map[string][]byte{ // (":" in fields is escaped, as VV8 writes it)
+		"origins": []byte("~0x1\n" +
+			"@\"https\\://a.test/?session=secret1\"\n" +
+			"$1:\"https\\://a.test/app.js?tok=secret2\":var x = \\\"\\u00e9\\\";\\x0a\n" +
+			"!1\n" +
+			"c5:%fetch:{Window}:\"https\\://api.test/?tok=secret3\":\"me@mail.test\"\n" +
+			"s9:{Window}:\"name\":\"secret4\"\n" +
+			"@\"https\\://b.test/#secret5\"\n" +
+			"g7:{Window}:\"location\"\n"),
+	}
+	paths, err := filepath.Glob("../tests/logs/*/*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		if logs[path], err = os.ReadFile(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, original := range logs {
+		t.Run(name, func(t *testing.T) {
+			var redacted bytes.Buffer
+			if err := rd.redactStream(bytes.NewReader(original), &redacted); err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(redacted.String(), "secret") || strings.Contains(redacted.String(), "me@mail") {
+				t.Errorf("redacted log still holds secrets:\n%s", redacted.String())
+			}
+			wantRecords, wantLn := ingestStructure(t, original)
+			gotRecords, gotLn := ingestStructure(t, redacted.Bytes())
+			if !reflect.DeepEqual(gotRecords, wantRecords) {
+				t.Errorf("record structure changed:\nredacted: %q\noriginal: %q", gotRecords, wantRecords)
+			}
+			if gotLn.Stats.Lines != wantLn.Stats.Lines || gotLn.Stats.Records != wantLn.Stats.Records {
+				t.Errorf("redacted log has %d lines (%v by code), original %d (%v)",
+					gotLn.Stats.Lines, gotLn.Stats.Records, wantLn.Stats.Lines, wantLn.Stats.Records)
+			}
+		})
+	}
+}
@@ -0,0 +1,365 @@
+package main
+
+// ---------------------------------------------------------------------------
+// HTTP job server ("-serve"): each job is a nested invoke() over uploaded logs, paths, and/or @OIDs
+// ---------------------------------------------------------------------------
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// jobStatus tracks a job through its life
+type jobStatus string
+
+const (
+	jobQueued  jobStatus = "queued"
+	jobRunning jobStatus = "running"
+	jobDone    jobStatus = "done"
+	jobFailed  jobStatus = "failed"
+)
+
+// serveJob is one post-processing request (and, once finished, its outcome)
+type serveJob struct {
+	ID       string     `json:"id"`
+	Status   jobStatus  `json:"status"`
+	Aggs     string     `json:"aggs"`
+	Output   string     `json:"output"`
+	Inputs   []string   `json:"inputs"`
+	Error    string     `json:"error,omitempty"`
+	Created  time.Time  `json:"created"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+
+	args      []string // full invoke() argument list
+	uploadDir string   // temp directory holding uploaded log data (if any)
+	result    []byte   // stream output (for 'stdout' jobs)
+}
+
+// jobRequest is the JSON form of a job submission (naming inputs already on the server)
+type jobRequest struct {
+	Aggs         string   `json:"aggs"`
+	Output       string   `json:"output"`
+	SubmissionID string   `json:"submissionid"`
+	Inputs       []string `json:"inputs"`
+}
+
+// validate checks (and defaults) the job parameters
+func (req *jobRequest) validate() error {
+	if req.Aggs == "" {
+		return fmt.Errorf("no aggs given")
+	}
+	if req.Output == "" {
+		req.Output = "stdout"
+	} else if req.Output != "stdout" && req.Output != "postgresql" {
+		// (other outputs write files wherever they are told to)
+		return fmt.Errorf("unsupported output '%s' (want 'stdout' or 'postgresql')", req.Output)
+	}
+	if req.SubmissionID != "" {
+		if _, err := uuid.Parse(req.SubmissionID); err != nil {
+			return fmt.Errorf("invalid submissionid '%s'", req.SubmissionID)
+		}
+	}
+	return nil
+}
+
+// serveConfig tunes a job server
+type serveConfig struct {
+	root      string        // directory input paths are resolved against ("" means path inputs are refused)
+	limit     int           // jobs run at a time
+	maxUpload int64         // largest request body accepted (bytes)
+	keep      time.Duration // how long finished jobs (and their results) are kept
+}
+
+// maxJobRequestSize bounds JSON job requests (which only name inputs)
+const maxJobRequestSize = 1 << 20
+
+// jobServer queues and runs jobs, at most <limit> at a time
+type jobServer struct {
+	serveConfig
+	slots chan struct{} // concurrency-limiting semaphore
+
+	lock sync.Mutex
+	jobs map[string]*serveJob
+}
+
+// oidPattern matches a vv8log "@OID" input
+var oidPattern = regexp.MustCompile(`^@[0-9a-fA-F]{24}$`)
+
+// uploadNamePattern restricts the file names given to uploads (which decide how they are decompressed/unpacked/grouped)
+var uploadNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// serve runs the job server on <addr> until it fails
+func serve(addr string, cfg serveConfig) error {
+	if cfg.root != "" {
+		var err error
+		cfg.root, err = filepath.Abs(cfg.root)
+		if err == nil {
+			cfg.root, err = filepath.EvalSymlinks(cfg.root) // (so resolved inputs can be checked against it)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	srv := &jobServer{
+		serveConfig: cfg,
+		slots:       make(chan struct{}, cfg.limit),
+		jobs:        make(map[string]*serveJob),
+	}
+	go srv.expireJobs()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /jobs", srv.handleSubmit)
+	mux.HandleFunc("GET /jobs/{id}", srv.handleStatus)
+	mux.HandleFunc("GET /jobs/{id}/result", srv.handleResult)
+
+	log.Printf("Serving post-processing jobs on %s (at most %d at a time)\n", addr, cfg.limit)
+	return http.ListenAndServe(addr, mux)
+}
+
+// httpError reports a failed request as a JSON error object
+func httpError(w http.ResponseWriter, code int, format string, args ...interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf(format, args...)})
+}
+
+// handleSubmit queues a job: either a JSON jobRequest, or raw log data (plain, compressed, or an archive) in the body,
+// with parameters (aggs, output, submissionid, and name--the upload's file name) in the query string
+func (srv *jobServer) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	var req jobRequest
+	job := &serveJob{
+		ID:      uuid.New().String(),
+		Status:  jobQueued,
+		Created: time.Now(),
+	}
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxJobRequestSize)).Decode(&req)
+		if err != nil {
+			httpError(w, http.StatusBadRequest, "invalid job request: %v", err)
+			return
+		}
+		if err = req.validate(); err != nil {
+			httpError(w, http.StatusBadRequest, "%v", err)
+			return
+		}
+		if len(req.Inputs) == 0 {
+			httpError(w, http.StatusBadRequest, "no inputs given")
+			return
+		}
+		for _, input := range req.Inputs {
+			resolved, err := srv.resolveInput(input)
+			if err != nil {
+				httpError(w, http.StatusBadRequest, "%v", err)
+				return
+			}
+			job.Inputs = append(job.Inputs, resolved)
+		}
+	} else {
+		query := r.URL.Query()
+		req.Aggs = query.Get("aggs")
+		req.Output = query.Get("output")
+		req.SubmissionID = query.Get("submissionid")
+		if err := req.validate(); err != nil {
+			httpError(w, http.StatusBadRequest, "%v", err)
+			return
+		}
+		name := query.Get("name")
+		if name == "" {
+			name = "upload.log"
+		} else if !uploadNamePattern.MatchString(name) || strings.HasPrefix(name, ".") {
+			httpError(w, http.StatusBadRequest, "invalid upload name '%s'", name)
+			return
+		}
+
+		dir, err := os.MkdirTemp("", "vv8-upload-")
+		if err != nil {
+			httpError(w, http.StatusInternalServerError, "%v", err)
+			return
+		}
+		job.uploadDir = dir
+		path := filepath.Join(dir, name)
+		err = saveUpload(path, http.MaxBytesReader(w, r.Body, srv.maxUpload))
+		var tooBig *http.MaxBytesError
+		if errors.As(err, &tooBig) {
+			os.RemoveAll(dir)
+			httpError(w, http.StatusRequestEntityTooLarge, "upload larger than %d bytes", tooBig.Limit)
+			return
+		} else if err != nil {
+			os.RemoveAll(dir)
+			httpError(w, http.StatusInternalServerError, "saving upload: %v", err)
+			return
+		}
+		job.Inputs = []string{path}
+	}
+
+	job.Aggs, job.Output = req.Aggs, req.Output
+	job.args = []string{"-aggs", req.Aggs, "-output", req.Output}
+	if req.SubmissionID != "" {
+		job.args = append(job.args, "-submissionid", req.SubmissionID)
+	}
+	job.args = append(job.args, "--")
+	job.args = append(job.args, job.Inputs...)
+
+	srv.lock.Lock()
+	srv.jobs[job.ID] = job
+	srv.lock.Unlock()
+	go srv.run(job)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/jobs/"+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	srv.writeStatus(w, job)
+}
+
+// resolveInput vets a named job input: @OIDs pass through, paths must stay within the server's root directory
+// (even once symlinks are followed; names are kept, since they decide grouping and decompression, and directory walks skip symlinks)
+func (srv *jobServer) resolveInput(input string) (string, error) {
+	if strings.HasPrefix(input, "@") {
+		if !oidPattern.MatchString(input) {
+			return "", fmt.Errorf("invalid OID input '%s'", input)
+		}
+		return input, nil
+	}
+	if srv.root == "" {
+		return "", fmt.Errorf("path inputs are not accepted by this server (no -serve-root)")
+	}
+	if input == "" || input == "-" {
+		return "", fmt.Errorf("invalid path input '%s'", input)
+	}
+	path := filepath.Join(srv.root, filepath.Clean("/"+input))
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", fmt.Errorf("path input '%s' not found", input)
+	}
+	if rel, err := filepath.Rel(srv.root, resolved); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("path input '%s' leads outside the server's root directory", input)
+	}
+	return path, nil
+}
+
+// saveUpload copies an uploaded request body to <path>
+func saveUpload(path string, body io.Reader) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, body)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// run waits for a free slot, then runs the job to completion
+func (srv *jobServer) run(job *serveJob) {
+	srv.slots <- struct{}{}
+	defer func() { <-srv.slots }()
+	if job.uploadDir != "" {
+		defer os.RemoveAll(job.uploadDir)
+	}
+
+	srv.lock.Lock()
+	job.Status = jobRunning
+	started := time.Now()
+	job.Started = &started
+	srv.lock.Unlock()
+	log.Printf("job %s: starting %q\n", job.ID, job.args)
+
+	var result bytes.Buffer
+	err := invokeJob(job.args, &result)
+
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+	finished := time.Now()
+	job.Finished = &finished
+	job.result = result.Bytes()
+	if err != nil {
+		job.Status = jobFailed
+		job.Error = err.Error()
+		log.Printf("job %s: failed: %v\n", job.ID, err)
+	} else {
+		job.Status = jobDone
+		log.Printf("job %s: done\n", job.ID)
+	}
+}
+
+// expireJobs forgets finished jobs (and their results) once they are older than <keep>, checking every so often
+func (srv *jobServer) expireJobs() {
+	interval := srv.keep / 10
+	if interval < time.Second {
+		interval = time.Second
+	}
+	for range time.Tick(interval) {
+		srv.lock.Lock()
+		for id, job := range srv.jobs {
+			if job.Finished != nil && time.Since(*job.Finished) > srv.keep {
+				delete(srv.jobs, id)
+			}
+		}
+		srv.lock.Unlock()
+	}
+}
+
+// lookup finds a job by the {id} in the request path (reporting 404 if there is none)
+func (srv *jobServer) lookup(w http.ResponseWriter, r *http.Request) *serveJob {
+	srv.lock.Lock()
+	job, ok := srv.jobs[r.PathValue("id")]
+	srv.lock.Unlock()
+	if !ok {
+		httpError(w, http.StatusNotFound, "no such job '%s'", r.PathValue("id"))
+		return nil
+	}
+	return job
+}
+
+// writeStatus sends a job's status document
+func (srv *jobServer) writeStatus(w io.Writer, job *serveJob) {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+	json.NewEncoder(w).Encode(job)
+}
+
+// handleStatus reports a job's status
+func (srv *jobServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	job := srv.lookup(w, r)
+	if job == nil {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	srv.writeStatus(w, job)
+}
+
+// handleResult returns a finished job's stream output (aggregator JSON lines, for 'stdout' jobs)
+func (srv *jobServer) handleResult(w http.ResponseWriter, r *http.Request) {
+	job := srv.lookup(w, r)
+	if job == nil {
+		return
+	}
+
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+	switch job.Status {
+	case jobQueued, jobRunning:
+		httpError(w, http.StatusConflict, "job %s is still %s", job.ID, job.Status)
+	case jobFailed:
+		httpError(w, http.StatusInternalServerError, "job %s failed: %s", job.ID, job.Error)
+	default:
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Write(job.result)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveInput(t *testing.T) {
+	root, outside := t.TempDir(), t.TempDir()
+	for _, dir := range []string{filepath.Join(root, "logs"), filepath.Join(outside, "logs")} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "vv8-1.0.log"), []byte("~0x1\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"inside.log":    filepath.Join(root, "logs", "vv8-1.0.log"),
+		"outside.log":   filepath.Join(outside, "logs", "vv8-1.0.log"),
+		"outside-dir":   filepath.Join(outside, "logs"),
+		"logs/up":       "..",
+		"logs/escape":   "../..",
+		"logs/dangling": filepath.Join(root, "missing"),
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	resolvedRoot, err := filepath.EvalSymlinks(root) // (as serve does with -serve-root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &jobServer{serveConfig: serveConfig{root: resolvedRoot}}
+
+	for input, ok := range map[string]bool{
+		"logs/vv8-1.0.log":                      true,
+		"/logs/vv8-1.0.log":                     true,
+		"../../logs/vv8-1.0.log":                true, // (confined lexically to the root first)
+		"inside.log":                            true,
+		"logs/up/logs":                          true,
+		"outside.log":                           false,
+		"outside-dir/vv8-1.0.log":               false,
+		"logs/escape":                           false,
+		"logs/escape/" + filepath.Base(outside): false,
+		"logs/dangling":                         false,
+		"missing.log":                           false,
+	} {
+		path, err := srv.resolveInput(input)
+		if ok && (err != nil || path != filepath.Join(srv.root, filepath.Clean("/"+input))) {
+			t.Errorf("resolveInput(%q) = %q, %v; want it inside the root", input, path, err)
+		} else if !ok && err == nil {
+			t.Errorf("resolveInput(%q) = %q; want an error", input, path)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package stats
+
+// ---------------------------------------------------------------------------
+// aggregator summarizing a log: record counts, isolates, scripts (by kind), origins, and the busiest scripts
+// ---------------------------------------------------------------------------
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"sort"
+
+	"github.com/wspr-ncsu/visiblev8/post-processor/core"
+)
+
+// topScriptCount is how many of the busiest scripts (by trace-record count) to report
+const topScriptCount = 10
+
+type statsAggregator struct {
+	scriptRecords map[*core.ScriptInfo]int // trace records attributed to each script
+	origins       map[string]bool          // distinct security origins active during trace records
+}
+
+// NewAggregator creates a log-statistics aggregator
+func NewAggregator() (core.Aggregator, error) {
+	return &statsAggregator{
+		scriptRecords: make(map[*core.ScriptInfo]int),
+		origins:       make(map[string]bool),
+	}, nil
+}
+
+// IngestRecord counts each trace record against its active script/origin (record type totals come from ctx.Ln.Stats)
+func (agg *statsAggregator) IngestRecord(ctx *core.ExecutionContext, lineNumber int, op byte, fields []string) error {
+	if ctx.Script != nil {
+		agg.scriptRecords[ctx.Script]++
+	}
+	if ctx.Origin != nil && ctx.Origin.Origin != "" {
+		agg.origins[ctx.Origin.Origin] = true
+	}
+	return nil
+}
+
+// scriptSummary describes one of the busiest scripts
+type scriptSummary struct {
+	Isolate    string `json:"isolate"`
+	ScriptID   int    `json:"script_id"`
+	ScriptHash string `json:"script_hash"`
+	URL        string `json:"url,omitempty"`
+	Eval       bool   `json:"eval"`
+	Records    int    `json:"records"`
+}
+
+// logSummary is the whole statistical picture of a log
+type logSummary struct {
+	Lines             int             `json:"lines"`
+	Bytes             int64           `json:"bytes"`
+	Records           map[string]int  `json:"records"`
+	Isolates          int             `json:"isolates"`
+	Scripts           int             `json:"scripts"`
+	URLScripts        int             `json:"url_scripts"`
+	EvalScripts       int             `json:"eval_scripts"`
+	VisibleV8Scripts  int             `json:"visiblev8_scripts"`
+	ScriptBytes       int64           `json:"script_bytes"`
+	Origins           int             `json:"origins"`
+	ScriptsPerIsolate map[string]int  `json:"scripts_per_isolate"`
+	TopScripts        []scriptSummary `json:"top_scripts"`
+}
+
+// summarize combines the log's isolate/script model with our per-record counters
+func (agg *statsAggregator) summarize(ln *core.LogInfo) *logSummary {
+	summary := &logSummary{
+		Lines:             ln.Stats.Lines,
+		Bytes:             ln.Stats.Bytes,
+		Records:           make(map[string]int),
+		Isolates:          len(ln.Isolates),
+		ScriptsPerIsolate: make(map[string]int),
+		TopScripts:        make([]scriptSummary, 0, topScriptCount),
+	}
+	for code, count := range ln.Stats.Records {
+		if count > 0 {
+			summary.Records[string(rune(code))] = count
+		}
+	}
+
+	origins := make(map[string]bool, len(agg.origins))
+	for origin := range agg.origins {
+		origins[origin] = true
+	}
+	for _, iso := range ln.Isolates {
+		summary.ScriptsPerIsolate[iso.ID] = 0
+		for _, script := range iso.Scripts {
+			if script.Placeholder {
+				continue
+			}
+			summary.ScriptsPerIsolate[iso.ID]++
+			summary.Scripts++
+			if script.EvaledBy != nil {
+				summary.EvalScripts++
+			} else {
+				summary.URLScripts++
+			}
+			if script.VisibleV8 {
+				summary.VisibleV8Scripts++
+			}
+			summary.ScriptBytes += int64(script.CodeHash.Length)
+			if script.FirstOrigin != nil && script.FirstOrigin.Origin != "" {
+				origins[script.FirstOrigin.Origin] = true
+			}
+		}
+	}
+	summary.Origins = len(origins)
+
+	busiest := make([]*core.ScriptInfo, 0, len(agg.scriptRecords))
+	for script := range agg.scriptRecords {
+		busiest = append(busiest, script)
+	}
+	sort.Slice(busiest, func(i, j int) bool {
+		ci, cj := agg.scriptRecords[busiest[i]], agg.scriptRecords[busiest[j]]
+		if ci != cj {
+			return ci > cj
+		} else if busiest[i].Isolate.ID != busiest[j].Isolate.ID {
+			return busiest[i].Isolate.ID < busiest[j].Isolate.ID
+		}
+		return busiest[i].ID < busiest[j].ID
+	})
+	if len(busiest) > topScriptCount {
+		busiest = busiest[:topScriptCount]
+	}
+	for _, script := range busiest {
+		summary.TopScripts = append(summary.TopScripts, scriptSummary{
+			Isolate:    script.Isolate.ID,
+			ScriptID:   script.ID,
+			ScriptHash: hex.EncodeToString(script.CodeHash.SHA2[:]),
+			URL:        script.URL,
+			Eval:       script.EvaledBy != nil,
+			Records:    agg.scriptRecords[script],
+		})
+	}
+
+	return summary
+}
+
+func (agg *statsAggregator) DumpToStream(ctx *core.AggregationContext, stream io.Writer) error {
+	return json.NewEncoder(stream).Encode(core.JSONArray{"stats", agg.summarize(ctx.Ln)})
+}
+
+// logStatsFields are the log_stats columns written (in order)
+var logStatsFields = [...]string{
+	"logfile_id",
+	"records",
+	"isolates",
+	"scripts",
+	"url_scripts",
+	"eval_scripts",
+	"visiblev8_scripts",
+	"script_bytes",
+	"origins",
+	"scripts_per_isolate",
+	"top_scripts",
+}
+
+// statsRow gives a log's log_stats row, with the given logfile ID
+func (agg *statsAggregator) statsRow(ctx *core.AggregationContext, logID interface{}) ([]interface{}, error) {
+	summary := agg.summarize(ctx.Ln)
+	records, err := json.Marshal(summary.Records)
+	if err != nil {
+		return nil, err
+	}
+	perIsolate, err := json.Marshal(summary.ScriptsPerIsolate)
+	if err != nil {
+		return nil, err
+	}
+	topScripts, err := json.Marshal(summary.TopScripts)
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{
+		logID, string(records), summary.Isolates, summary.Scripts, summary.URLScripts, summary.EvalScripts,
+		summary.VisibleV8Scripts, summary.ScriptBytes, summary.Origins, string(perIsolate), string(topScripts),
+	}, nil
+}
+
+func (agg *statsAggregator) DumpToPostgresql(ctx *core.AggregationContext, sqlDb *sql.DB) error {
+	logID, err := ctx.Ln.InsertLogfile(sqlDb)
+	if err != nil {
+		return err
+	}
+
+	row, err := agg.statsRow(ctx, logID)
+	if err != nil {
+		return err
+	}
+	_, err = sqlDb.Exec(`INSERT INTO log_stats
+	(logfile_id, records, isolates, scripts, url_scripts, eval_scripts, visiblev8_scripts, script_bytes, origins, scripts_per_isolate, top_scripts)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`, row...)
+	return err
+}
+
+// logStatsCopyTable is the COPY-file equivalent of log_stats
+var logStatsCopyTable = &core.CopyTable{Name: "log_stats", Fields: logStatsFields[:]}
+
+// CopyTables lists the COPY files written by DumpToCopy
+var CopyTables = []*core.CopyTable{logStatsCopyTable}
+
+// DumpToCopy writes the log_stats row DumpToPostgresql would insert
+func (agg *statsAggregator) DumpToCopy(ctx *core.AggregationContext, sink core.CopySink) error {
+	row, err := agg.statsRow(ctx, ctx.Ln.ID.String())
+	if err != nil {
+		return err
+	}
+	return sink.CopyRow(logStatsCopyTable, row...)
+}
@@ -0,0 +1,223 @@
+package main
+
+// ---------------------------------------------------------------------------
+// batch worker ("-worker"): claims jobs from the postprocess_jobs queue table and runs them into PostgreSQL
+// ---------------------------------------------------------------------------
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wspr-ncsu/visiblev8/post-processor/core"
+)
+
+// workerConfig tunes a queue worker
+type workerConfig struct {
+	id         string        // claimant name recorded on jobs
+	poll       time.Duration // wait between claim attempts when the queue is empty
+	staleAfter time.Duration // requeue claims whose heartbeat is older than this
+	backoff    time.Duration // delay before the first retry (doubled per further attempt)
+	maxBackoff time.Duration // cap on retry delay
+	drain      bool          // exit once no job is claimable (instead of polling forever)
+}
+
+// defaultWorkerID names this worker process "<hostname>-<pid>"
+func defaultWorkerID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+// queueJob is one claimed postprocess_jobs row
+type queueJob struct {
+	id           int
+	logPath      string
+	submissionID sql.NullString
+	rootDomain   sql.NullString
+	aggs         string
+	attempts     int
+	maxAttempts  int
+}
+
+// validate checks a claimed job's parameters (a job failing this can never succeed, so it is not retried)
+func (job *queueJob) validate() error {
+	if job.logPath == "" || job.logPath == "-" {
+		return fmt.Errorf("invalid log_path '%s'", job.logPath)
+	}
+	if strings.ContainsRune(job.logPath, 0) {
+		return fmt.Errorf("invalid log_path (contains NUL)")
+	}
+	if job.aggs == "" {
+		return fmt.Errorf("no aggs given")
+	}
+	if job.submissionID.Valid && job.submissionID.String != "" {
+		if _, err := uuid.Parse(job.submissionID.String); err != nil {
+			return fmt.Errorf("invalid submissionid '%s'", job.submissionID.String)
+		}
+	}
+	return nil
+}
+
+// claimQuery atomically claims the oldest claimable pending job (concurrent workers skip rows claimed by others)
+const claimQuery = `UPDATE postprocess_jobs
+SET status = 'running', attempts = attempts + 1, claimed_by = $1, heartbeat_at = now(), started_at = now(), finished_at = NULL
+WHERE id = (
+	SELECT id FROM postprocess_jobs
+	WHERE status = 'pending' AND not_before <= now()
+	ORDER BY id
+	LIMIT 1
+	FOR UPDATE SKIP LOCKED)
+RETURNING id, log_path, submissionid, root_domain, aggs, attempts, max_attempts`
+
+// requeueStaleQuery releases the claims of workers that stopped heartbeating (failing jobs out of attempts for good)
+const requeueStaleQuery = `UPDATE postprocess_jobs
+SET status = CASE WHEN attempts >= max_attempts THEN 'failed' ELSE 'pending' END,
+	error = 'claim by ' || COALESCE(claimed_by, '?') || ' went stale',
+	not_before = now()
+WHERE status = 'running' AND heartbeat_at < now() - make_interval(secs => $1)`
+
+// runWorker claims and runs queued jobs until stopped (SIGINT/SIGTERM, after the current job) or, if draining, out of work
+func runWorker(cfg workerConfig) error {
+	// We rely on the PGxxx environment variables being set...
+	sqlDb, err := sql.Open("postgres", "sslmode=disable")
+	if err != nil {
+		return err
+	}
+	defer sqlDb.Close()
+	if err = core.CheckSchemaVersion(sqlDb); err != nil {
+		return err
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stop)
+
+	log.Printf("worker %s: polling for jobs...\n", cfg.id)
+	for {
+		select {
+		case <-stop:
+			log.Printf("worker %s: stopping\n", cfg.id)
+			return nil
+		default:
+		}
+
+		result, err := sqlDb.Exec(requeueStaleQuery, cfg.staleAfter.Seconds())
+		if err != nil {
+			return err
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			log.Printf("worker %s: recovered %d stale job claim(s)\n", cfg.id, n)
+		}
+
+		var job queueJob
+		err = sqlDb.QueryRow(claimQuery, cfg.id).Scan(&job.id, &job.logPath, &job.submissionID, &job.rootDomain, &job.aggs, &job.attempts, &job.maxAttempts)
+		if err == sql.ErrNoRows {
+			if cfg.drain {
+				log.Printf("worker %s: queue drained\n", cfg.id)
+				return nil
+			}
+			select {
+			case <-stop:
+				log.Printf("worker %s: stopping\n", cfg.id)
+				return nil
+			case <-time.After(cfg.poll):
+			}
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		err = runQueueJob(sqlDb, cfg, &job)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// runQueueJob runs one claimed job (heartbeating all the while) and records its outcome; only bookkeeping errors are returned
+func runQueueJob(sqlDb *sql.DB, cfg workerConfig, job *queueJob) error {
+	log.Printf("worker %s: job %d (attempt %d of %d): %s over %s\n", cfg.id, job.id, job.attempts, job.maxAttempts, job.aggs, job.logPath)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(cfg.staleAfter / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				_, err := sqlDb.Exec(`UPDATE postprocess_jobs SET heartbeat_at = now() WHERE id = $1 AND claimed_by = $2 AND status = 'running'`, job.id, cfg.id)
+				if err != nil {
+					log.Printf("worker %s: job %d: heartbeat failed: %v\n", cfg.id, job.id, err)
+				}
+			}
+		}
+	}()
+
+	// (a retried job replaces whatever an earlier attempt, or an earlier job over the same log, left behind)
+	args := []string{"-aggs", job.aggs, "-output", "postgresql", "-replace"}
+	if job.submissionID.Valid && job.submissionID.String != "" {
+		args = append(args, "-submissionid", job.submissionID.String)
+	}
+	if job.rootDomain.Valid && job.rootDomain.String != "" {
+		args = append(args, "-rootdomain", job.rootDomain.String)
+	}
+	args = append(args, "--", job.logPath)
+	started := time.Now()
+	var jobErr error
+	retry := true
+	if jobErr = job.validate(); jobErr != nil {
+		retry = false
+	} else {
+		jobErr = invokeJob(args, io.Discard) // (its PostgreSQL pool and any Mongo client are closed before it returns)
+	}
+	elapsed := time.Since(started)
+
+	var result sql.Result
+	var err error
+	if jobErr == nil {
+		log.Printf("worker %s: job %d done (%v)\n", cfg.id, job.id, elapsed)
+		result, err = sqlDb.Exec(`UPDATE postprocess_jobs SET status = 'done', error = NULL, finished_at = now()
+			WHERE id = $1 AND claimed_by = $2 AND status = 'running'`, job.id, cfg.id)
+	} else if retry && job.attempts < job.maxAttempts {
+		delay := retryDelay(cfg, job.attempts)
+		log.Printf("worker %s: job %d failed (%v); retrying in %v: %v\n", cfg.id, job.id, elapsed, delay, jobErr)
+		result, err = sqlDb.Exec(`UPDATE postprocess_jobs SET status = 'pending', error = $3, finished_at = now(), not_before = now() + make_interval(secs => $4)
+			WHERE id = $1 AND claimed_by = $2 AND status = 'running'`, job.id, cfg.id, jobErr.Error(), delay.Seconds())
+	} else {
+		log.Printf("worker %s: job %d failed for good (%v): %v\n", cfg.id, job.id, elapsed, jobErr)
+		result, err = sqlDb.Exec(`UPDATE postprocess_jobs SET status = 'failed', error = $3, finished_at = now()
+			WHERE id = $1 AND claimed_by = $2 AND status = 'running'`, job.id, cfg.id, jobErr.Error())
+	}
+	if err != nil {
+		return fmt.Errorf("recording outcome of job %d: %w", job.id, err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		log.Printf("worker %s: job %d: claim was lost (went stale?) before the outcome could be recorded\n", cfg.id, job.id)
+	}
+	return nil
+}
+
+// retryDelay computes the exponential backoff before retrying after the given (1-based) failed attempt
+func retryDelay(cfg workerConfig, attempt int) time.Duration {
+	delay := cfg.backoff
+	for i := 1; i < attempt && delay < cfg.maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > cfg.maxBackoff {
+		delay = cfg.maxBackoff
+	}
+	return delay
+}